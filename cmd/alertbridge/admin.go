@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/njdaniel/alertbridge/internal/handler"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// adminCommands are the subcommands that control a running server through
+// its admin API instead of starting one.
+var adminCommands = map[string]bool{
+	"halt":    true,
+	"resume":  true,
+	"status":  true,
+	"flatten": true,
+}
+
+// runAdmin runs an admin subcommand against the server at ADMIN_URL, by
+// default on localhost at PORT, authenticating with ADMIN_TOKEN. The
+// server's response is written to out.
+func runAdmin(name string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	bots := new(string)
+	if name == "halt" || name == "resume" {
+		bots = fs.String("bots", "", "comma-separated bots, all bots when empty")
+	}
+	bot, account := new(string), new(string)
+	if name == "flatten" {
+		bot = fs.String("bot", "", "halt this bot and close only its own orders and positions; every bot and account when empty")
+		account = fs.String("account", "", "flatten only this account; without -bot this still halts EVERY bot")
+	}
+	reduceOnly, reason := new(bool), new(string)
+	if name == "halt" {
+		reduceOnly = fs.Bool("reduce-only", false, "only allow orders that reduce a position")
+		reason = fs.String("reason", "", "reason announced with the halt")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	method, path := http.MethodPost, "/admin/"+name
+	var req handler.HaltRequest
+	for _, bot := range strings.Split(*bots, ",") {
+		if bot = strings.TrimSpace(bot); bot != "" {
+			req.Bots = append(req.Bots, bot)
+		}
+	}
+	switch name {
+	case "halt":
+		req.Reason = *reason
+		if *reduceOnly {
+			req.Mode = risk.ModeReduceOnly
+		}
+	case "status":
+		method, path = http.MethodGet, "/admin/halt"
+	case "flatten":
+		return adminRequest(method, path, handler.FlattenRequest{Bot: *bot, Account: *account}, out)
+	}
+	return adminRequest(method, path, req, out)
+}
+
+// adminRequest sends body to the admin API and writes the response to out.
+// A 207 Multi-Status response is written and then reported as an error.
+func adminRequest(method, path string, body interface{}, out io.Writer) error {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return errors.New("ADMIN_TOKEN is not set")
+	}
+	base := os.Getenv("ADMIN_URL")
+	if base == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		base = "http://localhost:" + port
+	}
+
+	var reader io.Reader
+	if method == http.MethodPost {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(base, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e handler.ErrorResponse
+		if json.Unmarshal(b, &e) == nil && e.Message != "" {
+			return fmt.Errorf("%s (%d %s)", e.Message, resp.StatusCode, e.Code)
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, b, "", "  ") == nil {
+		b = append(pretty.Bytes(), '\n')
+	}
+	if _, err := out.Write(b); err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusMultiStatus {
+		return errors.New("some steps failed, see the results above")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/handler"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func TestRunAdmin(t *testing.T) {
+	t.Setenv("PROM_URL", "")
+	g := risk.NewGuard("0")
+	h := handler.NewHookHandler(zap.NewNop(), newTestAlpacaClient(t), g, nil, nil, true, true, true)
+	ts := httptest.NewServer(h.AdminHandler("t0ken"))
+	defer ts.Close()
+	t.Setenv("ADMIN_URL", ts.URL)
+	t.Setenv("ADMIN_TOKEN", "t0ken")
+
+	var out bytes.Buffer
+	if err := runAdmin("halt", []string{"-bots", "a, b", "-reduce-only", "-reason", "drill"}, &out); err != nil {
+		t.Fatalf("halt failed: %v", err)
+	}
+	halts := g.Halts()
+	if len(halts.Bots) != 2 || halts.Bots["b"].Mode != risk.ModeReduceOnly || halts.Bots["a"].Reason != "drill" {
+		t.Fatalf("unexpected halts %+v", halts)
+	}
+	if !strings.Contains(out.String(), `"reduce_only"`) {
+		t.Fatalf("expected the new state to be printed, got %s", out.String())
+	}
+
+	out.Reset()
+	if err := runAdmin("status", nil, &out); err != nil || !strings.Contains(out.String(), `"drill"`) {
+		t.Fatalf("unexpected status %q %v", out.String(), err)
+	}
+	if err := runAdmin("resume", []string{"-bots", "a"}, &out); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if halts := g.Halts(); len(halts.Bots) != 1 {
+		t.Fatalf("expected only bot b halted, got %+v", halts)
+	}
+
+	if err := runAdmin("status", []string{"-bots", "a"}, &out); err == nil {
+		t.Fatalf("expected status to take no flags")
+	}
+
+	// The test broker cannot list orders or positions, so the flatten
+	// halts the bot but reports failed steps
+	out.Reset()
+	if err := runAdmin("flatten", []string{"-bot", "c"}, &out); err == nil || !strings.Contains(err.Error(), "some steps failed") {
+		t.Fatalf("expected failed steps to be reported, got %v", err)
+	}
+	if !strings.Contains(out.String(), `"results"`) {
+		t.Fatalf("expected the results to be printed, got %s", out.String())
+	}
+	if halts := g.Halts(); halts.Bots["c"].Mode != risk.ModeHalt {
+		t.Fatalf("expected bot c halted, got %+v", halts)
+	}
+	if _, err := g.Resume([]string{"c"}); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if err := runAdmin("flatten", []string{"-bots", "c"}, &out); err == nil {
+		t.Fatalf("expected flatten to reject -bots")
+	}
+	t.Setenv("ADMIN_TOKEN", "wrong")
+	if err := runAdmin("resume", nil, &out); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+	if halts := g.Halts(); len(halts.Bots) != 1 {
+		t.Fatalf("expected halts untouched, got %+v", halts)
+	}
+	t.Setenv("ADMIN_TOKEN", "")
+	if err := runAdmin("status", nil, &out); err == nil {
+		t.Fatalf("expected error without ADMIN_TOKEN")
+	}
+}
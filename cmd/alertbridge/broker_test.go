@@ -0,0 +1,259 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/config"
+	"github.com/njdaniel/alertbridge/internal/dedup"
+)
+
+func TestNewBrokerSim(t *testing.T) {
+	t.Setenv("SIM_CASH", "5000")
+	t.Setenv("SIM_STATE_FILE", "")
+	t.Setenv("SIM_PRICES", "AAPL=100, BTC/USD=50000")
+
+	b, err := newBroker(zap.NewNop(), "sim", "", "", "")
+	if err != nil {
+		t.Fatalf("newBroker failed: %v", err)
+	}
+	if _, ok := b.(*adapter.SimBroker); !ok {
+		t.Fatalf("expected *adapter.SimBroker, got %T", b)
+	}
+	account, err := b.GetAccount()
+	if err != nil || account.Cash.String() != "5000" {
+		t.Fatalf("expected cash 5000, got %v %v", account, err)
+	}
+	order, err := b.PlaceOrder(adapter.OrderRequest{Bot: "b", Symbol: "BTC/USD", Side: "buy", Notional: &account.Cash})
+	if err != nil || order.Status != "filled" {
+		t.Fatalf("expected filled order from static prices, got %v %v", order, err)
+	}
+}
+
+func TestNewBrokerDefaultAndUnknown(t *testing.T) {
+	b, err := newBroker(zap.NewNop(), "", "k", "s", "http://localhost")
+	if err != nil {
+		t.Fatalf("newBroker failed: %v", err)
+	}
+	if _, ok := b.(*adapter.AlpacaClient); !ok {
+		t.Fatalf("expected *adapter.AlpacaClient, got %T", b)
+	}
+	if _, err := newBroker(zap.NewNop(), "ibkr", "", "", ""); err == nil {
+		t.Fatalf("expected error for unknown broker")
+	}
+}
+
+func TestParsePricesInvalid(t *testing.T) {
+	if _, err := parsePrices("AAPL"); err == nil {
+		t.Fatalf("expected error for missing price")
+	}
+	if _, err := parsePrices("AAPL=abc"); err == nil {
+		t.Fatalf("expected error for invalid price")
+	}
+}
+
+func TestNewAccountBrokers(t *testing.T) {
+	cfg := &config.Config{Accounts: map[string]config.Account{
+		"paper": {Key: "k", Secret: "s", BaseURL: config.PaperBaseURL},
+		"live":  {Key: "k", Secret: "s", BaseURL: config.LiveBaseURL, Live: true},
+	}}
+	brokers, err := newAccountBrokers(zap.NewNop(), "", cfg)
+	if err != nil || len(brokers) != 2 {
+		t.Fatalf("expected 2 brokers, got %d %v", len(brokers), err)
+	}
+	if _, ok := brokers["live"].(*adapter.AlpacaClient); !ok {
+		t.Fatalf("expected *adapter.AlpacaClient, got %T", brokers["live"])
+	}
+}
+
+func TestNewAccountBrokersSim(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SIM_CASH", "5000")
+	t.Setenv("SIM_STATE_FILE", filepath.Join(dir, "sim.json"))
+	t.Setenv("SIM_PRICES", "AAPL=100")
+	cfg := &config.Config{Accounts: map[string]config.Account{
+		"a": {Key: "k", Secret: "s", BaseURL: config.PaperBaseURL},
+		"b": {Key: "k", Secret: "s", BaseURL: config.PaperBaseURL},
+	}}
+	brokers, err := newAccountBrokers(zap.NewNop(), "sim", cfg)
+	if err != nil {
+		t.Fatalf("newAccountBrokers failed: %v", err)
+	}
+	for name, b := range brokers {
+		if _, ok := b.(*adapter.SimBroker); !ok {
+			t.Fatalf("account %s: expected *adapter.SimBroker, got %T", name, b)
+		}
+	}
+
+	// Each account trades its own cash and persists to its own file
+	if _, err := brokers["a"].PlaceOrder(adapter.OrderRequest{Bot: "x", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	if account, _ := brokers["b"].GetAccount(); account.Cash.String() != "5000" {
+		t.Fatalf("expected account b untouched, got cash %s", account.Cash)
+	}
+	if got := accountStateFile(filepath.Join(dir, "sim.json"), "a"); got != filepath.Join(dir, "sim-a.json") {
+		t.Fatalf("unexpected state file %s", got)
+	}
+	if _, err := newAccountBrokers(zap.NewNop(), "ibkr", cfg); err == nil {
+		t.Fatal("expected error for unknown broker")
+	}
+}
+
+func TestNewDedupStore(t *testing.T) {
+	if s, err := newDedupStore("", ""); err != nil {
+		t.Fatalf("newDedupStore failed: %v", err)
+	} else if _, ok := s.(*dedup.MemoryStore); !ok {
+		t.Fatalf("expected *dedup.MemoryStore, got %T", s)
+	}
+	s, err := newDedupStore("1h", filepath.Join(t.TempDir(), "dedup.json"))
+	if err != nil {
+		t.Fatalf("newDedupStore failed: %v", err)
+	}
+	if _, ok := s.(*dedup.FileStore); !ok {
+		t.Fatalf("expected *dedup.FileStore, got %T", s)
+	}
+	if _, err := newDedupStore("soon", ""); err == nil {
+		t.Fatalf("expected error for invalid ttl")
+	}
+}
+
+func TestNewQueue(t *testing.T) {
+	q, workers, err := newQueue(filepath.Join(t.TempDir(), "queue.jsonl"), "", "")
+	if err != nil {
+		t.Fatalf("newQueue failed: %v", err)
+	}
+	q.Close()
+	if workers != 4 {
+		t.Fatalf("expected 4 workers by default, got %d", workers)
+	}
+	if _, workers, err = newQueue(filepath.Join(t.TempDir(), "queue.jsonl"), "24h", "2"); err != nil || workers != 2 {
+		t.Fatalf("expected 2 workers, got %d %v", workers, err)
+	}
+	for _, tt := range []struct{ retention, workers string }{{"soon", ""}, {"", "0"}, {"", "many"}} {
+		if _, _, err := newQueue(filepath.Join(t.TempDir(), "queue.jsonl"), tt.retention, tt.workers); err == nil {
+			t.Fatalf("expected error for retention %q and workers %q", tt.retention, tt.workers)
+		}
+	}
+}
+
+func TestBotCredentials(t *testing.T) {
+	cfg := &config.Config{Bots: map[string]config.Bot{
+		"signed": {Account: "a", Secrets: []string{"new", "old"}},
+		"routed": {Account: "a"},
+	}}
+	creds := botCredentials(cfg)
+	if len(creds) != 2 {
+		t.Fatalf("expected credentials for every bot, got %d", len(creds))
+	}
+	if got := creds["signed"].Secrets; len(got) != 2 || string(got[1]) != "old" {
+		t.Fatalf("unexpected secrets %q", got)
+	}
+	if got := creds["routed"]; len(got.Secrets) != 0 || len(got.Passphrases) != 0 {
+		t.Fatalf("expected no keys for routed bot, got %+v", got)
+	}
+}
+
+func TestNewIPFilter(t *testing.T) {
+	if _, err := newIPFilter("tradingview, 10.0.0.0/8", "127.0.0.1"); err != nil {
+		t.Fatalf("newIPFilter failed: %v", err)
+	}
+	if _, err := newIPFilter("", ""); err != nil {
+		t.Fatalf("expected empty lists to be accepted, got %v", err)
+	}
+	if _, err := newIPFilter("nowhere", ""); err == nil {
+		t.Fatalf("expected error for invalid allow-list")
+	}
+	if _, err := newIPFilter("", "10.0.0.0/40"); err == nil {
+		t.Fatalf("expected error for invalid trusted proxies")
+	}
+}
+
+func TestBotAllowedIPs(t *testing.T) {
+	cfg := &config.Config{Bots: map[string]config.Bot{
+		"tv":   {Account: "a", AllowedIPs: []string{"tradingview"}},
+		"open": {Account: "a"},
+	}}
+	allowed, err := botAllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("botAllowedIPs failed: %v", err)
+	}
+	if len(allowed) != 1 || len(allowed["tv"]) != 4 {
+		t.Fatalf("unexpected allow-lists %v", allowed)
+	}
+}
+
+func TestDryRunBots(t *testing.T) {
+	cfg := &config.Config{Bots: map[string]config.Bot{
+		"shadow": {Account: "a", DryRun: true},
+		"live":   {Account: "a"},
+	}}
+	if bots := dryRunBots(cfg); len(bots) != 1 || !bots["shadow"] {
+		t.Fatalf("unexpected dry-run bots %v", bots)
+	}
+}
+
+func TestNewLimitTable(t *testing.T) {
+	t.Setenv("MAX_QTY", "100")
+	t.Setenv("MAX_NOTIONAL", "")
+	t.Setenv("MAX_POSITION", "")
+	t.Setenv("MAX_EXPOSURE", "50000")
+	t.Setenv("MAX_DAILY_LOSS", "750")
+	defaults, err := envLimits()
+	if err != nil {
+		t.Fatalf("envLimits: %v", err)
+	}
+
+	five, thousand := decimal.NewFromInt(5), decimal.NewFromInt(1000)
+	cfg := &config.Config{
+		Limits: &config.Limits{MaxNotional: &thousand},
+		Bots: map[string]config.Bot{
+			"b":     {Account: "a", Limits: &config.Limits{Symbols: map[string]config.Limits{"TSLA": {MaxQty: &five}}}},
+			"plain": {Account: "a"},
+		},
+	}
+	table := newLimitTable(defaults, cfg)
+	l := table.For("b", "TSLA")
+	if !l.MaxQty.Equal(five) || !l.MaxNotional.Equal(thousand) || l.MaxPosition != nil || l.MaxExposure.String() != "50000" {
+		t.Fatalf("unexpected limits for b/TSLA %+v", l)
+	}
+	if l := table.For("plain", "TSLA"); l.MaxQty.String() != "100" || l.MaxDailyLoss.String() != "750" {
+		t.Fatalf("expected MAX_QTY default, got %+v", l)
+	}
+	if l := newLimitTable(defaults, nil).For("b", "TSLA"); l.MaxQty.String() != "100" || l.MaxNotional != nil {
+		t.Fatalf("unexpected limits without config %+v", l)
+	}
+
+	t.Setenv("MAX_QTY", "-1")
+	if _, err := envLimits(); err == nil {
+		t.Fatalf("expected error for negative MAX_QTY")
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	if l, err := newLimiter("RATE_LIMIT_BOT", ""); l != nil || err != nil {
+		t.Fatalf("expected no limiter, got %v %v", l, err)
+	}
+	if _, err := newLimiter("RATE_LIMIT_BOT", "often"); err == nil {
+		t.Fatalf("expected error for invalid spec")
+	}
+
+	cfg := &config.Config{Bots: map[string]config.Bot{"slow": {Account: "a", RateLimit: "1/h"}}}
+	l := applyBotRateLimits(nil, cfg)
+	if l == nil {
+		t.Fatalf("expected limiter for per-bot override")
+	}
+	if ok, _ := l.Allow("slow"); !ok {
+		t.Fatalf("expected first alert to pass")
+	}
+	if ok, _ := l.Allow("slow"); ok {
+		t.Fatalf("expected second alert to be limited")
+	}
+	if ok, _ := l.Allow("other"); !ok {
+		t.Fatalf("expected bots without override to be unlimited")
+	}
+}
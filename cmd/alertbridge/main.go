@@ -2,24 +2,42 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 
 	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/config"
+	"github.com/njdaniel/alertbridge/internal/dedup"
 	"github.com/njdaniel/alertbridge/internal/handler"
 	"github.com/njdaniel/alertbridge/internal/notify"
+	"github.com/njdaniel/alertbridge/internal/queue"
+	"github.com/njdaniel/alertbridge/internal/ratelimit"
 	"github.com/njdaniel/alertbridge/internal/risk"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	// Subcommands control a running server instead of starting one
+	if len(os.Args) > 1 && adminCommands[os.Args[1]] {
+		if err := runAdmin(os.Args[1], os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	// Initialize logger
 	logger, err := zap.NewProduction()
 	if err != nil {
@@ -64,12 +82,16 @@ func main() {
 		debugLogging = true
 	}
 
-	// Initialize Alpaca client
-	alpacaClient := adapter.NewAlpacaClient(alpacaKey, alpacaSecret, alpacaBase)
-	alpacaClient.SetLogger(logger)
+	// Initialize broker
+	broker, err := newBroker(logger, os.Getenv("BROKER"), alpacaKey, alpacaSecret, alpacaBase)
+	if err != nil {
+		logger.Fatal("failed to create broker", zap.Error(err))
+	}
 
-	// Initialize risk guard
+	// Initialize risk guard; the size limits are added once the config
+	// file is loaded
 	riskGuard := risk.NewGuard(cooldownSec)
+	riskGuard.SetLogger(logger)
 
 	// Initialize Slack notifier if configured
 	var notifier *notify.SlackNotifier
@@ -78,12 +100,179 @@ func main() {
 	}
 
 	// Initialize handler
-	hookHandler := handler.NewHookHandler(logger, alpacaClient, riskGuard, []byte(tvSecret), notifier, notifySuccess, notifyFailure, debugLogging)
+	hookHandler := handler.NewHookHandler(logger, broker, riskGuard, []byte(tvSecret), notifier, notifySuccess, notifyFailure, debugLogging)
+
+	// Rate limits, configured separately from the cooldown rule
+	globalLimit, err := newLimiter("RATE_LIMIT_GLOBAL", os.Getenv("RATE_LIMIT_GLOBAL"))
+	if err != nil {
+		logger.Fatal("invalid rate limit", zap.Error(err))
+	}
+	ipLimit, err := newLimiter("RATE_LIMIT_IP", os.Getenv("RATE_LIMIT_IP"))
+	if err != nil {
+		logger.Fatal("invalid rate limit", zap.Error(err))
+	}
+	botLimit, err := newLimiter("RATE_LIMIT_BOT", os.Getenv("RATE_LIMIT_BOT"))
+	if err != nil {
+		logger.Fatal("invalid rate limit", zap.Error(err))
+	}
+
+	// Route bots to their own accounts when a config file is provided
+	var cfg *config.Config
+	var dryRun map[string]bool
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		cfg, err = config.Load(configFile, strings.EqualFold(os.Getenv("BROKER"), "sim"))
+		if err != nil {
+			logger.Fatal("failed to load config", zap.Error(err))
+		}
+		accountBrokers, err := newAccountBrokers(logger, os.Getenv("BROKER"), cfg)
+		if err != nil {
+			logger.Fatal("failed to create account brokers", zap.Error(err))
+		}
+		hookHandler.SetAccounts(cfg, accountBrokers)
+		logger.Info("multi-account routing enabled",
+			zap.Int("accounts", len(cfg.Accounts)),
+			zap.Int("bots", len(cfg.Bots)))
+		if cfg.HasCredentials() {
+			hookHandler.SetCredentials(botCredentials(cfg))
+			logger.Info("per-bot credentials enabled, unknown bots are rejected")
+		}
+		botIPs, err := botAllowedIPs(cfg)
+		if err != nil {
+			logger.Fatal("invalid bot allowed_ips", zap.Error(err))
+		}
+		hookHandler.SetBotAllowedIPs(botIPs)
+		botLimit = applyBotRateLimits(botLimit, cfg)
+		dryRun = dryRunBots(cfg)
+	}
+	hookHandler.SetRateLimits(globalLimit, ipLimit, botLimit)
+
+	// Cap order and position sizes, then optionally narrow or reorder the
+	// risk rules
+	defaultLimits, err := envLimits()
+	if err != nil {
+		logger.Fatal("invalid limits", zap.Error(err))
+	}
+	limits := newLimitTable(defaultLimits, cfg)
+	riskGuard.Register(risk.NewOrderLimit(limits))
+	riskGuard.Register(risk.NewPositionLimit(limits))
+	riskGuard.Register(risk.NewExposureLimit(limits))
+	riskGuard.Register(risk.NewDailyLoss(limits))
+	if v := os.Getenv("RISK_RULES"); v != "" {
+		if err := riskGuard.SetOrder(strings.Split(v, ",")); err != nil {
+			logger.Fatal("invalid RISK_RULES", zap.String("value", v), zap.Error(err))
+		}
+	}
+	logger.Info("risk rules", zap.Strings("rules", riskGuard.Rules()))
+
+	// Keep kill switch halts across restarts
+	if v := os.Getenv("KILL_SWITCH_FILE"); v != "" {
+		if err := riskGuard.SetHaltFile(v); err != nil {
+			logger.Fatal("failed to load kill switch state", zap.Error(err))
+		}
+		if halts := riskGuard.Halts(); halts.All != nil || len(halts.Bots) > 0 {
+			logger.Warn("kill switch engaged", zap.Any("halts", halts))
+		}
+	}
+
+	// Rehearse alerts without trading, for every bot or those configured
+	dryRunAll := false
+	if v := os.Getenv("DRY_RUN"); strings.ToLower(v) == "true" || v == "1" {
+		dryRunAll = true
+	}
+	hookHandler.SetDryRun(dryRunAll, dryRun)
+	if dryRunAll || len(dryRun) > 0 {
+		logger.Info("dry-run mode enabled",
+			zap.Bool("all_bots", dryRunAll),
+			zap.Int("bots", len(dryRun)))
+	}
+
+	// Bound request bodies and optionally reject unknown alert fields
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			logger.Fatal("invalid MAX_BODY_BYTES", zap.String("value", v), zap.Error(err))
+		}
+		hookHandler.SetBodyLimit(n)
+	}
+	if v := os.Getenv("STRICT_JSON"); strings.ToLower(v) == "true" || v == "1" {
+		hookHandler.SetStrictJSON(true)
+		logger.Info("strict JSON decoding enabled")
+	}
+
+	// Accept a passphrase in the body from senders that cannot sign requests
+	hookHandler.SetPassphrases(os.Getenv("TV_PASSPHRASE"))
+
+	// Expire signed alerts so captured requests cannot be replayed later
+	if v := os.Getenv("ALERT_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Fatal("invalid ALERT_MAX_AGE", zap.String("value", v), zap.Error(err))
+		}
+		maxSkew := 5 * time.Second
+		if v := os.Getenv("ALERT_MAX_SKEW"); v != "" {
+			if maxSkew, err = time.ParseDuration(v); err != nil {
+				logger.Fatal("invalid ALERT_MAX_SKEW", zap.String("value", v), zap.Error(err))
+			}
+		}
+		hookHandler.SetFreshness(maxAge, maxSkew)
+		logger.Info("alert freshness check enabled",
+			zap.Duration("max_age", maxAge),
+			zap.Duration("max_skew", maxSkew))
+	}
+
+	// Remember processed alerts so retries do not trade twice
+	store, err := newDedupStore(os.Getenv("DEDUP_TTL"), os.Getenv("DEDUP_FILE"))
+	if err != nil {
+		logger.Fatal("failed to create dedup store", zap.Error(err))
+	}
+	hookHandler.SetDedup(store)
+
+	// In async mode alerts are persisted to a journal, acknowledged with
+	// 202 Accepted and placed by background workers
+	stopWorkers := func() {}
+	if queueFile := os.Getenv("QUEUE_FILE"); queueFile != "" {
+		q, workers, err := newQueue(queueFile, os.Getenv("QUEUE_RETENTION"), os.Getenv("QUEUE_WORKERS"))
+		if err != nil {
+			logger.Fatal("failed to open queue", zap.Error(err))
+		}
+		hookHandler.SetQueue(q)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			hookHandler.RunWorkers(ctx, workers)
+			close(done)
+		}()
+		stopWorkers = func() {
+			cancel()
+			<-done
+			q.Close()
+		}
+		logger.Info("async mode enabled",
+			zap.String("queue_file", queueFile),
+			zap.Int("workers", workers),
+			zap.Int("resumed", q.Len()))
+	}
+
+	// Only accept webhooks from allowed sources
+	ipFilter, err := newIPFilter(os.Getenv("IP_ALLOWLIST"), os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		logger.Fatal("failed to create IP filter", zap.Error(err))
+	}
+	ipFilter.SetLogger(logger)
+	ipFilter.SetRejectHandler(handler.SourceForbiddenHandler())
 
 	// Create mux and register handlers
 	mux := http.NewServeMux()
-	mux.Handle("/hook", hookHandler)
+	mux.Handle("/hook", ipFilter.Middleware(hookHandler))
 	mux.Handle("/metrics", promhttp.Handler())
+	if apiToken := os.Getenv("API_TOKEN"); apiToken != "" {
+		mux.Handle("/orders/", hookHandler.OrdersHandler(apiToken))
+		logger.Info("Registered /orders endpoint")
+	}
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.Handle("/admin/", hookHandler.AdminHandler(adminToken))
+		logger.Info("Registered /admin endpoints")
+	}
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
@@ -112,7 +301,298 @@ func main() {
 	logger.Info("shutting down server")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
+	err = srv.Shutdown(ctx)
+	stopWorkers()
+	if err != nil {
 		logger.Fatal("server forced to shutdown", zap.Error(err))
 	}
 }
+
+// newBroker builds the broker selected by kind: "alpaca" (the default) or
+// "sim" for the built-in paper-trading simulator.
+func newBroker(logger *zap.Logger, kind, alpacaKey, alpacaSecret, alpacaBase string) (adapter.Broker, error) {
+	switch strings.ToLower(kind) {
+	case "", "alpaca":
+		alpacaClient := adapter.NewAlpacaClient(alpacaKey, alpacaSecret, alpacaBase)
+		alpacaClient.SetLogger(logger)
+		return alpacaClient, nil
+	case "sim":
+		return newSimBroker(logger, os.Getenv("SIM_STATE_FILE"))
+	default:
+		return nil, fmt.Errorf("unknown BROKER %q", kind)
+	}
+}
+
+// newSimBroker builds a simulated broker persisted to stateFile, which may
+// be empty, funded with SIM_CASH and priced from SIM_PRICES.
+func newSimBroker(logger *zap.Logger, stateFile string) (*adapter.SimBroker, error) {
+	cash := decimal.NewFromInt(100000)
+	if v := os.Getenv("SIM_CASH"); v != "" {
+		var err error
+		if cash, err = decimal.NewFromString(v); err != nil {
+			return nil, fmt.Errorf("invalid SIM_CASH %q: %w", v, err)
+		}
+	}
+	sim, err := adapter.NewSimBroker(cash, stateFile)
+	if err != nil {
+		return nil, err
+	}
+	sim.SetLogger(logger)
+	if v := os.Getenv("SIM_PRICES"); v != "" {
+		prices, err := parsePrices(v)
+		if err != nil {
+			return nil, err
+		}
+		sim.SetPriceSource(prices)
+	}
+	logger.Info("using simulated broker", zap.String("cash", cash.String()))
+	return sim, nil
+}
+
+// newAccountBrokers builds a broker of the kind selected by BROKER for
+// every account in cfg. Simulated accounts each get their own cash and
+// positions, persisted next to SIM_STATE_FILE under the account's name.
+func newAccountBrokers(logger *zap.Logger, kind string, cfg *config.Config) (map[string]adapter.Broker, error) {
+	brokers := make(map[string]adapter.Broker, len(cfg.Accounts))
+	for name, acct := range cfg.Accounts {
+		acctLogger := logger.With(zap.String("account", name))
+		var broker adapter.Broker
+		var err error
+		if strings.EqualFold(kind, "sim") {
+			broker, err = newSimBroker(acctLogger, accountStateFile(os.Getenv("SIM_STATE_FILE"), name))
+		} else {
+			broker, err = newBroker(acctLogger, kind, acct.Key, acct.Secret, acct.BaseURL)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", name, err)
+		}
+		brokers[name] = broker
+		logger.Info("configured account",
+			zap.String("account", name),
+			zap.Bool("live", acct.Live),
+			zap.String("base_url", acct.BaseURL))
+	}
+	return brokers, nil
+}
+
+// accountStateFile returns the simulator state file of account, named
+// after stateFile: "sim.json" becomes "sim-paper.json". It is empty when
+// stateFile is.
+func accountStateFile(stateFile, account string) string {
+	if stateFile == "" {
+		return ""
+	}
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + "-" + account + ext
+}
+
+// botCredentials builds the credential table of every bot in cfg.
+func botCredentials(cfg *config.Config) map[string]handler.Credentials {
+	creds := make(map[string]handler.Credentials, len(cfg.Bots))
+	for name, bot := range cfg.Bots {
+		c := handler.Credentials{Passphrases: bot.Passphrases}
+		for _, secret := range bot.Secrets {
+			c.Secrets = append(c.Secrets, []byte(secret))
+		}
+		creds[name] = c
+	}
+	return creds
+}
+
+// botAllowedIPs parses the source allow-list of every bot in cfg.
+func botAllowedIPs(cfg *config.Config) (map[string][]*net.IPNet, error) {
+	allowed := make(map[string][]*net.IPNet)
+	for name, bot := range cfg.Bots {
+		nets, err := auth.ParseCIDRs(bot.AllowedIPs)
+		if err != nil {
+			return nil, fmt.Errorf("bot %q: %w", name, err)
+		}
+		if len(nets) > 0 {
+			allowed[name] = nets
+		}
+	}
+	return allowed, nil
+}
+
+// dryRunBots returns the bots configured with dry_run.
+func dryRunBots(cfg *config.Config) map[string]bool {
+	bots := make(map[string]bool)
+	for name, bot := range cfg.Bots {
+		if bot.DryRun {
+			bots[name] = true
+		}
+	}
+	return bots
+}
+
+// envLimits parses the global order and position caps from MAX_QTY,
+// MAX_NOTIONAL, MAX_POSITION, MAX_EXPOSURE and MAX_DAILY_LOSS. Unset caps
+// are not enforced.
+func envLimits() (risk.Limits, error) {
+	var limits risk.Limits
+	for name, field := range map[string]**decimal.Decimal{
+		"MAX_QTY":        &limits.MaxQty,
+		"MAX_NOTIONAL":   &limits.MaxNotional,
+		"MAX_POSITION":   &limits.MaxPosition,
+		"MAX_EXPOSURE":   &limits.MaxExposure,
+		"MAX_DAILY_LOSS": &limits.MaxDailyLoss,
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		d, err := decimal.NewFromString(v)
+		if err != nil || !d.IsPositive() {
+			return risk.Limits{}, fmt.Errorf("invalid %s %q", name, v)
+		}
+		*field = &d
+	}
+	return limits, nil
+}
+
+// newLimitTable combines the global caps with the limits in cfg, which
+// may be nil. The file's global limits take precedence over defaults.
+func newLimitTable(defaults risk.Limits, cfg *config.Config) *risk.LimitTable {
+	table := &risk.LimitTable{Default: defaults}
+	if cfg == nil {
+		return table
+	}
+	if cfg.Limits != nil {
+		table.Default = riskLimits(*cfg.Limits).Or(defaults)
+		table.Symbols = symbolLimits(cfg.Limits.Symbols)
+	}
+	for name, bot := range cfg.Bots {
+		if bot.Limits == nil {
+			continue
+		}
+		if table.Bots == nil {
+			table.Bots = make(map[string]risk.Limits)
+			table.BotSymbols = make(map[string]map[string]risk.Limits)
+		}
+		table.Bots[name] = riskLimits(*bot.Limits)
+		table.BotSymbols[name] = symbolLimits(bot.Limits.Symbols)
+	}
+	return table
+}
+
+func riskLimits(l config.Limits) risk.Limits {
+	return risk.Limits{
+		MaxQty:       l.MaxQty,
+		MaxNotional:  l.MaxNotional,
+		MaxPosition:  l.MaxPosition,
+		MaxExposure:  l.MaxExposure,
+		MaxDailyLoss: l.MaxDailyLoss,
+	}
+}
+
+func symbolLimits(symbols map[string]config.Limits) map[string]risk.Limits {
+	limits := make(map[string]risk.Limits, len(symbols))
+	for symbol, l := range symbols {
+		limits[symbol] = riskLimits(l)
+	}
+	return limits
+}
+
+// newIPFilter builds the source filter for /hook from IP_ALLOWLIST and
+// TRUSTED_PROXIES, comma-separated lists of CIDRs or IP addresses.
+// IP_ALLOWLIST may include the "tradingview" preset; when empty every
+// source is accepted.
+func newIPFilter(allowList, trustedProxies string) (*auth.IPFilter, error) {
+	allow, err := auth.ParseCIDRs(strings.Split(allowList, ","))
+	if err != nil {
+		return nil, fmt.Errorf("IP_ALLOWLIST: %w", err)
+	}
+	trusted, err := auth.ParseCIDRs(strings.Split(trustedProxies, ","))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	return auth.NewIPFilter(allow, trusted), nil
+}
+
+// newLimiter parses the rate limit spec of the named setting. It returns
+// nil, meaning unlimited, when spec is empty.
+func newLimiter(name, spec string) (*ratelimit.Limiter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	limit, err := ratelimit.ParseLimit(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return ratelimit.New(limit), nil
+}
+
+// applyBotRateLimits applies the per-bot rate_limit overrides in cfg to
+// limiter, creating an otherwise unlimited one when limiter is nil.
+func applyBotRateLimits(limiter *ratelimit.Limiter, cfg *config.Config) *ratelimit.Limiter {
+	for name, bot := range cfg.Bots {
+		if bot.RateLimit == "" {
+			continue
+		}
+		// Validated by config.Load
+		limit, _ := ratelimit.ParseLimit(bot.RateLimit)
+		if limiter == nil {
+			limiter = ratelimit.New(ratelimit.Limit{})
+		}
+		limiter.SetKeyLimit(name, limit)
+	}
+	return limiter
+}
+
+// newQueue opens the async queue journal at file and returns it with the
+// number of workers, 4 by default. Finished jobs are kept for retention,
+// 168h by default.
+func newQueue(file, retention, workers string) (*queue.Queue, int, error) {
+	keep := 7 * 24 * time.Hour
+	if retention != "" {
+		var err error
+		if keep, err = time.ParseDuration(retention); err != nil || keep <= 0 {
+			return nil, 0, fmt.Errorf("invalid QUEUE_RETENTION %q", retention)
+		}
+	}
+	n := 4
+	if workers != "" {
+		var err error
+		if n, err = strconv.Atoi(workers); err != nil || n <= 0 {
+			return nil, 0, fmt.Errorf("invalid QUEUE_WORKERS %q", workers)
+		}
+	}
+	q, err := queue.Open(file, keep)
+	if err != nil {
+		return nil, 0, err
+	}
+	return q, n, nil
+}
+
+// newDedupStore builds the alert dedup store. Entries are kept for ttl,
+// 24h by default, in memory or in file when set.
+func newDedupStore(ttl, file string) (dedup.Store, error) {
+	d := 24 * time.Hour
+	if ttl != "" {
+		var err error
+		if d, err = time.ParseDuration(ttl); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid DEDUP_TTL %q", ttl)
+		}
+	}
+	if file != "" {
+		return dedup.NewFileStore(file, d)
+	}
+	return dedup.NewMemoryStore(d), nil
+}
+
+// parsePrices parses SIM_PRICES, a comma-separated list of SYMBOL=PRICE.
+func parsePrices(v string) (adapter.StaticPrices, error) {
+	prices := adapter.StaticPrices{}
+	for _, pair := range strings.Split(v, ",") {
+		symbol, price, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid SIM_PRICES entry %q", pair)
+		}
+		d, err := decimal.NewFromString(strings.TrimSpace(price))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIM_PRICES price for %s: %w", symbol, err)
+		}
+		prices[strings.TrimSpace(symbol)] = d
+	}
+	return prices, nil
+}
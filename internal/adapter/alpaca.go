@@ -1,7 +1,10 @@
 package adapter
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
@@ -9,6 +12,12 @@ import (
 	"go.uber.org/zap"
 )
 
+// ErrNoPosition is returned when the account holds no position in a symbol.
+var ErrNoPosition = errors.New("no open position")
+
+// ErrOrderNotFound is returned when the broker has no order with an ID.
+var ErrOrderNotFound = errors.New("order not found")
+
 type AlpacaClient struct {
 	client  *alpaca.Client
 	logger  *zap.Logger
@@ -36,8 +45,8 @@ func (c *AlpacaClient) SetLogger(logger *zap.Logger) {
 	}
 }
 
-// isCrypto determines if a symbol represents a crypto pair
-func isCrypto(symbol string) bool {
+// IsCrypto determines if a symbol represents a crypto pair
+func IsCrypto(symbol string) bool {
 	if len(symbol) >= 3 && symbol[len(symbol)-3:] == "USD" {
 		return true
 	}
@@ -47,38 +56,184 @@ func isCrypto(symbol string) bool {
 	return false
 }
 
-func (c *AlpacaClient) CreateOrder(bot, symbol, side, qty string) (*alpaca.Order, error) {
-	// Convert side to Alpaca side
-	alpacaSide := alpaca.Side(side)
+// OrderRequest describes an order to submit to the broker. Empty Type
+// defaults to a market order, empty TimeInForce is derived from the asset
+// class and empty OrderClass sends a simple order. When Notional is set the
+// order is sized in dollars and Qty is ignored.
+type OrderRequest struct {
+	Bot         string
+	Symbol      string
+	Side        string
+	Qty         decimal.Decimal
+	Notional    *decimal.Decimal
+	Type        string
+	TimeInForce string
+	LimitPrice  *decimal.Decimal
+	StopPrice   *decimal.Decimal
+
+	// OrderClass is one of "bracket", "oco" or "oto". The leg prices are
+	// absolute and only sent for non-simple orders.
+	OrderClass           string
+	TakeProfitLimitPrice *decimal.Decimal
+	StopLossStopPrice    *decimal.Decimal
+	StopLossLimitPrice   *decimal.Decimal
+
+	// ClientOrderID is sent instead of a generated ID when set, so that a
+	// retried alert maps to the same order.
+	ClientOrderID string
+}
+
+// clientOrderID tags an order with the bot that placed it.
+func clientOrderID(req OrderRequest) string {
+	if req.ClientOrderID != "" {
+		return req.ClientOrderID
+	}
+	return fmt.Sprintf("%s-%d", req.Bot, time.Now().UnixNano())
+}
+
+// ClientOrderBot returns the bot that placed an order, parsed from the
+// client order IDs AlertBridge generates: "<bot>-<nanos>" with a 19-digit
+// Unix time in nanoseconds, the alert-derived "<bot>-<hash>" and its
+// "<bot>-<hash>-<step>" variant, each optionally followed by "-leg" for
+// simulated exit legs. Since bot names may contain
+// dashes, the suffix is stripped from the right rather than matching a
+// prefix. ok is false for IDs AlertBridge did not generate.
+func ClientOrderBot(clientOrderID string) (bot string, ok bool) {
+	id := strings.TrimSuffix(clientOrderID, "-leg")
+	rest, last, found := cutLast(id)
+	if !found || rest == "" {
+		return "", false
+	}
+	switch {
+	case isHash(last):
+		return rest, true
+	case isDigits(last) && len(last) == 19:
+		return rest, true
+	case isDigits(last):
+		if head, hash, found := cutLast(rest); found && head != "" && isHash(hash) {
+			return head, true
+		}
+	}
+	return "", false
+}
 
+// cutLast splits s around its last dash.
+func cutLast(s string) (before, after string, found bool) {
+	i := strings.LastIndexByte(s, '-')
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// isHash reports whether s is the hex digest of an alert-derived client
+// order ID.
+func isHash(s string) bool {
+	if len(s) != 20 {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateOrder places a market order for qty units of symbol.
+func (c *AlpacaClient) CreateOrder(bot, symbol, side, qty string) (*alpaca.Order, error) {
 	// Parse quantity
 	qtyDec, err := decimal.NewFromString(qty)
 	if err != nil {
 		return nil, fmt.Errorf("invalid qty: %w", err)
 	}
 
-	// Determine time in force based on asset type
+	return c.PlaceOrder(OrderRequest{
+		Bot:    bot,
+		Symbol: symbol,
+		Side:   side,
+		Qty:    qtyDec,
+	})
+}
+
+// BuildPlaceOrderRequest converts req into the request sent to Alpaca,
+// filling in the default order type and time in force.
+func BuildPlaceOrderRequest(req OrderRequest) alpaca.PlaceOrderRequest {
+	orderType := alpaca.Market
+	if req.Type != "" {
+		orderType = alpaca.OrderType(req.Type)
+	}
+
+	// Determine time in force based on asset type unless provided
 	timeInForce := alpaca.Day
-	if isCrypto(symbol) {
+	if IsCrypto(req.Symbol) {
 		timeInForce = alpaca.GTC
 	}
+	if req.TimeInForce != "" {
+		timeInForce = alpaca.TimeInForce(req.TimeInForce)
+	}
 
-	// Create order request
 	orderRequest := alpaca.PlaceOrderRequest{
-		Symbol:        symbol,
-		Qty:           &qtyDec,
-		Side:          alpacaSide,
-		Type:          alpaca.Market,
+		Symbol:        req.Symbol,
+		Side:          alpaca.Side(req.Side),
+		Type:          orderType,
 		TimeInForce:   timeInForce,
-		ClientOrderID: fmt.Sprintf("%s-%d", bot, time.Now().UnixNano()),
+		LimitPrice:    req.LimitPrice,
+		StopPrice:     req.StopPrice,
+		ClientOrderID: clientOrderID(req),
+	}
+	if req.Notional != nil {
+		orderRequest.Notional = req.Notional
+	} else {
+		qty := req.Qty
+		orderRequest.Qty = &qty
 	}
+	if req.OrderClass != "" && req.OrderClass != string(alpaca.Simple) {
+		orderRequest.OrderClass = alpaca.OrderClass(req.OrderClass)
+		if req.TakeProfitLimitPrice != nil {
+			orderRequest.TakeProfit = &alpaca.TakeProfit{LimitPrice: req.TakeProfitLimitPrice}
+		}
+		if req.StopLossStopPrice != nil {
+			orderRequest.StopLoss = &alpaca.StopLoss{
+				StopPrice:  req.StopLossStopPrice,
+				LimitPrice: req.StopLossLimitPrice,
+			}
+		}
+	}
+	return orderRequest
+}
+
+// PlaceOrder submits req to Alpaca.
+func (c *AlpacaClient) PlaceOrder(req OrderRequest) (*alpaca.Order, error) {
+	symbol, side := req.Symbol, req.Side
+	sizing := zap.String("qty", req.Qty.String())
+	if req.Notional != nil {
+		sizing = zap.String("notional", req.Notional.String())
+	}
+
+	orderRequest := BuildPlaceOrderRequest(req)
+	orderType, timeInForce := orderRequest.Type, orderRequest.TimeInForce
 
 	// Log outgoing request for debugging
 	c.logger.Info("placing order",
 		zap.String("baseURL", c.baseURL),
 		zap.String("symbol", symbol),
 		zap.String("side", side),
-		zap.String("qty", qty),
+		sizing,
+		zap.String("type", string(orderType)),
+		zap.String("orderClass", req.OrderClass),
 		zap.String("timeInForce", string(timeInForce)),
 		zap.Any("request", orderRequest))
 
@@ -89,7 +244,8 @@ func (c *AlpacaClient) CreateOrder(bot, symbol, side, qty string) (*alpaca.Order
 			c.logger.Error("alpaca API error",
 				zap.String("symbol", symbol),
 				zap.String("side", side),
-				zap.String("qty", qty),
+				sizing,
+				zap.String("type", string(orderType)),
 				zap.String("timeInForce", string(timeInForce)),
 				zap.Int("status", apiErr.StatusCode),
 				zap.Int("code", apiErr.Code),
@@ -99,7 +255,8 @@ func (c *AlpacaClient) CreateOrder(bot, symbol, side, qty string) (*alpaca.Order
 			c.logger.Error("failed to place order",
 				zap.String("symbol", symbol),
 				zap.String("side", side),
-				zap.String("qty", qty),
+				sizing,
+				zap.String("type", string(orderType)),
 				zap.String("timeInForce", string(timeInForce)),
 				zap.Error(err))
 		}
@@ -109,8 +266,219 @@ func (c *AlpacaClient) CreateOrder(bot, symbol, side, qty string) (*alpaca.Order
 	c.logger.Info("order placed successfully",
 		zap.String("symbol", symbol),
 		zap.String("side", side),
-		zap.String("qty", qty),
+		sizing,
+		zap.String("type", string(orderType)),
 		zap.String("timeInForce", string(timeInForce)),
 		zap.String("orderID", order.ID))
 	return order, nil
 }
+
+// positionSymbol converts a symbol to the form used in position URLs,
+// which do not accept the slash in crypto pairs such as "BTC/USD".
+func positionSymbol(symbol string) string {
+	return strings.ReplaceAll(symbol, "/", "")
+}
+
+// isNotFound reports whether err is an Alpaca 404 response.
+func isNotFound(err error) bool {
+	var apiErr *alpaca.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// GetPosition returns the open position in symbol, or ErrNoPosition.
+func (c *AlpacaClient) GetPosition(symbol string) (*alpaca.Position, error) {
+	pos, err := c.client.GetPosition(positionSymbol(symbol))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNoPosition
+		}
+		c.logger.Error("failed to get position",
+			zap.String("symbol", symbol),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to get position: %w", err)
+	}
+	return pos, nil
+}
+
+// ListPositions returns every open position.
+func (c *AlpacaClient) ListPositions() ([]alpaca.Position, error) {
+	positions, err := c.client.GetPositions()
+	if err != nil {
+		c.logger.Error("failed to list positions", zap.Error(err))
+		return nil, fmt.Errorf("failed to list positions: %w", err)
+	}
+	return positions, nil
+}
+
+// ClosePosition liquidates percent (0-100] of the position in symbol at
+// market, or returns ErrNoPosition.
+func (c *AlpacaClient) ClosePosition(symbol string, percent decimal.Decimal) (*alpaca.Order, error) {
+	c.logger.Info("closing position",
+		zap.String("baseURL", c.baseURL),
+		zap.String("symbol", symbol),
+		zap.String("percent", percent.String()))
+
+	req := alpaca.ClosePositionRequest{}
+	if !percent.Equal(decimal.NewFromInt(100)) {
+		req.Percentage = percent
+	}
+	order, err := c.client.ClosePosition(positionSymbol(symbol), req)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNoPosition
+		}
+		c.logger.Error("failed to close position",
+			zap.String("symbol", symbol),
+			zap.String("percent", percent.String()),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to close position: %w", err)
+	}
+
+	c.logger.Info("position close submitted",
+		zap.String("symbol", symbol),
+		zap.String("percent", percent.String()),
+		zap.String("orderID", order.ID))
+	return order, nil
+}
+
+// CancelOrder cancels an open order.
+func (c *AlpacaClient) CancelOrder(orderID string) error {
+	if err := c.client.CancelOrder(orderID); err != nil {
+		c.logger.Error("failed to cancel order",
+			zap.String("orderID", orderID),
+			zap.Error(err))
+		return fmt.Errorf("failed to cancel order: %w", err)
+	}
+	c.logger.Info("order cancelled", zap.String("orderID", orderID))
+	return nil
+}
+
+// ReplaceOrder amends an open order.
+func (c *AlpacaClient) ReplaceOrder(orderID string, req ReplaceRequest) (*alpaca.Order, error) {
+	order, err := c.client.ReplaceOrder(orderID, alpaca.ReplaceOrderRequest{
+		Qty:         req.Qty,
+		LimitPrice:  req.LimitPrice,
+		StopPrice:   req.StopPrice,
+		TimeInForce: alpaca.TimeInForce(req.TimeInForce),
+	})
+	if err != nil {
+		c.logger.Error("failed to replace order",
+			zap.String("orderID", orderID),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to replace order: %w", err)
+	}
+	c.logger.Info("order replaced",
+		zap.String("orderID", orderID),
+		zap.String("newOrderID", order.ID))
+	return order, nil
+}
+
+// GetAccount returns the account balances.
+func (c *AlpacaClient) GetAccount() (*alpaca.Account, error) {
+	account, err := c.client.GetAccount()
+	if err != nil {
+		c.logger.Error("failed to get account", zap.Error(err))
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	return account, nil
+}
+
+// ListOpenOrders returns all open orders, with bracket and OCO legs nested
+// under their parent.
+func (c *AlpacaClient) ListOpenOrders() ([]alpaca.Order, error) {
+	orders, err := c.listOrders("open", time.Time{})
+	if err != nil {
+		c.logger.Error("failed to list open orders", zap.Error(err))
+		return nil, fmt.Errorf("failed to list open orders: %w", err)
+	}
+	return orders, nil
+}
+
+// ordersPageSize is the number of orders requested per page, Alpaca's
+// maximum.
+var ordersPageSize = 500
+
+// ListOrdersSince returns the orders submitted after since, with bracket
+// and OCO legs nested under their parent.
+func (c *AlpacaClient) ListOrdersSince(since time.Time) ([]alpaca.Order, error) {
+	orders, err := c.listOrders("all", since)
+	if err != nil {
+		c.logger.Error("failed to list orders", zap.Error(err), zap.Time("since", since))
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	return orders, nil
+}
+
+// listOrders returns the orders with status submitted after since, legs
+// nested. It pages through the results in submission order. Alpaca
+// filters by whole seconds, so each page restarts at the second of the
+// last order seen and repeats are skipped.
+func (c *AlpacaClient) listOrders(status string, since time.Time) ([]alpaca.Order, error) {
+	var orders []alpaca.Order
+	seen := make(map[string]bool)
+	after := since
+	for {
+		page, err := c.client.GetOrders(alpaca.GetOrdersRequest{
+			Status:    status,
+			After:     after,
+			Limit:     ordersPageSize,
+			Direction: "asc",
+			Nested:    true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		added := 0
+		for _, o := range page {
+			if seen[o.ID] {
+				continue
+			}
+			seen[o.ID] = true
+			orders = append(orders, o)
+			added++
+		}
+		if len(page) < ordersPageSize {
+			return orders, nil
+		}
+		if added == 0 {
+			// A full page within one second cannot be paged past
+			c.logger.Warn("order list truncated",
+				zap.String("status", status),
+				zap.Time("since", since),
+				zap.Time("after", after),
+				zap.Int("orders", len(orders)))
+			return orders, nil
+		}
+		after = page[len(page)-1].SubmittedAt.Truncate(time.Second).Add(-time.Second)
+	}
+}
+
+// GetOrder returns an order by ID.
+func (c *AlpacaClient) GetOrder(orderID string) (*alpaca.Order, error) {
+	order, err := c.client.GetOrder(orderID)
+	if isNotFound(err) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		c.logger.Error("failed to get order",
+			zap.String("orderID", orderID),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	return order, nil
+}
+
+// GetOrderByClientOrderID returns an order by client order ID.
+func (c *AlpacaClient) GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error) {
+	order, err := c.client.GetOrderByClientOrderID(clientOrderID)
+	if isNotFound(err) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		c.logger.Error("failed to get order",
+			zap.String("clientOrderID", clientOrderID),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	return order, nil
+}
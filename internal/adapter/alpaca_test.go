@@ -2,11 +2,16 @@ package adapter
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
@@ -92,3 +97,389 @@ func TestCreateOrderInvalidQty(t *testing.T) {
 		t.Fatalf("expected error for invalid qty")
 	}
 }
+
+func TestPlaceOrderLimit(t *testing.T) {
+	var requestBody []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requestBody = body
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"lmt"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	c.SetLogger(zap.NewNop())
+
+	limit := decimal.NewFromFloat(101.5)
+	stop := decimal.NewFromInt(100)
+	_, err := c.PlaceOrder(OrderRequest{
+		Bot:         "bot",
+		Symbol:      "AAPL",
+		Side:        "buy",
+		Qty:         decimal.NewFromInt(2),
+		Type:        "stop_limit",
+		TimeInForce: "gtc",
+		LimitPrice:  &limit,
+		StopPrice:   &stop,
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal(requestBody, &req); err != nil {
+		t.Fatalf("failed to parse request body: %v", err)
+	}
+	if req["type"] != "stop_limit" {
+		t.Fatalf("expected type stop_limit, got %v", req["type"])
+	}
+	if req["time_in_force"] != "gtc" {
+		t.Fatalf("expected time_in_force gtc, got %v", req["time_in_force"])
+	}
+	if req["limit_price"] != "101.5" {
+		t.Fatalf("expected limit_price 101.5, got %v", req["limit_price"])
+	}
+	if req["stop_price"] != "100" {
+		t.Fatalf("expected stop_price 100, got %v", req["stop_price"])
+	}
+}
+
+func TestPlaceOrderBracket(t *testing.T) {
+	var requestBody []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requestBody = body
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"brk"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	c.SetLogger(zap.NewNop())
+
+	tp := decimal.NewFromInt(110)
+	sl := decimal.NewFromInt(95)
+	_, err := c.PlaceOrder(OrderRequest{
+		Bot:                  "bot",
+		Symbol:               "AAPL",
+		Side:                 "buy",
+		Qty:                  decimal.NewFromInt(1),
+		OrderClass:           "bracket",
+		TakeProfitLimitPrice: &tp,
+		StopLossStopPrice:    &sl,
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	var req struct {
+		OrderClass string `json:"order_class"`
+		TakeProfit struct {
+			LimitPrice string `json:"limit_price"`
+		} `json:"take_profit"`
+		StopLoss struct {
+			StopPrice  string  `json:"stop_price"`
+			LimitPrice *string `json:"limit_price"`
+		} `json:"stop_loss"`
+	}
+	if err := json.Unmarshal(requestBody, &req); err != nil {
+		t.Fatalf("failed to parse request body: %v", err)
+	}
+	if req.OrderClass != "bracket" {
+		t.Fatalf("expected order_class bracket, got %s", req.OrderClass)
+	}
+	if req.TakeProfit.LimitPrice != "110" || req.StopLoss.StopPrice != "95" {
+		t.Fatalf("unexpected legs: %s", requestBody)
+	}
+	if req.StopLoss.LimitPrice != nil {
+		t.Fatalf("expected no stop loss limit, got %s", *req.StopLoss.LimitPrice)
+	}
+}
+
+func TestGetPositionNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":40410000,"message":"position does not exist"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	if _, err := c.GetPosition("AAPL"); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected ErrNoPosition, got %v", err)
+	}
+	if _, err := c.ClosePosition("AAPL", decimal.NewFromInt(100)); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected ErrNoPosition, got %v", err)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.RawQuery, "missing") || strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":40410000,"message":"order not found"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"o1","client_order_id":"bot-abc","status":"filled","filled_avg_price":"101.5"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	order, err := c.GetOrder("o1")
+	if err != nil {
+		t.Fatalf("GetOrder failed: %v", err)
+	}
+	if order.Status != "filled" || order.FilledAvgPrice == nil || !order.FilledAvgPrice.Equal(decimal.RequireFromString("101.5")) {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	if _, err := c.GetOrderByClientOrderID("bot-abc"); err != nil {
+		t.Fatalf("GetOrderByClientOrderID failed: %v", err)
+	}
+	if _, err := c.GetOrder("missing"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if _, err := c.GetOrderByClientOrderID("missing"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if paths[0] != "/v2/orders/o1?" || paths[1] != "/v2/orders:by_client_order_id?client_order_id=bot-abc" {
+		t.Fatalf("unexpected requests %v", paths)
+	}
+}
+
+func TestClosePositionPercentage(t *testing.T) {
+	var method, path, percentage string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.Path
+		percentage = r.URL.Query().Get("percentage")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"close"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	order, err := c.ClosePosition("BTC/USD", decimal.NewFromInt(50))
+	if err != nil {
+		t.Fatalf("ClosePosition failed: %v", err)
+	}
+	if order.ID != "close" {
+		t.Fatalf("expected order id close, got %s", order.ID)
+	}
+	if method != http.MethodDelete || path != "/v2/positions/BTCUSD" {
+		t.Fatalf("unexpected request %s %s", method, path)
+	}
+	if percentage != "50" {
+		t.Fatalf("expected percentage 50, got %q", percentage)
+	}
+}
+
+func TestPlaceOrderNotional(t *testing.T) {
+	var requestBody []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requestBody = body
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"ntl"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	notional := decimal.NewFromInt(250)
+	if _, err := c.PlaceOrder(OrderRequest{Bot: "bot", Symbol: "AAPL", Side: "buy", Notional: &notional}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal(requestBody, &req); err != nil {
+		t.Fatalf("failed to parse request body: %v", err)
+	}
+	if req["notional"] != "250" {
+		t.Fatalf("expected notional 250, got %v", req["notional"])
+	}
+	if req["qty"] != nil {
+		t.Fatalf("expected no qty, got %v", req["qty"])
+	}
+}
+
+func TestPlaceOrderClientOrderID(t *testing.T) {
+	var requestBody []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"cid"}`))
+	}))
+	defer ts.Close()
+
+	c := NewAlpacaClient("k", "s", ts.URL)
+	req := OrderRequest{Bot: "bot", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1), ClientOrderID: "bot-abc"}
+	if _, err := c.PlaceOrder(req); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(requestBody, &body); err != nil {
+		t.Fatalf("failed to parse request body: %v", err)
+	}
+	if body["client_order_id"] != "bot-abc" {
+		t.Fatalf("expected client_order_id bot-abc, got %v", body["client_order_id"])
+	}
+}
+
+func TestBrokerAccountAndOrders(t *testing.T) {
+	var cancelled, status, nested string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/account", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"acct","equity":"1000","last_equity":"900"}`))
+	})
+	mux.HandleFunc("/v2/positions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"symbol":"AAPL","qty":"5"}]`))
+	})
+	mux.HandleFunc("/v2/orders", func(w http.ResponseWriter, r *http.Request) {
+		status = r.URL.Query().Get("status")
+		nested = r.URL.Query().Get("nested")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"o1"},{"id":"o2"}]`))
+	})
+	mux.HandleFunc("/v2/orders/o1", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			cancelled = "o1"
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"o3"}`))
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var b Broker = NewAlpacaClient("k", "s", ts.URL)
+
+	account, err := b.GetAccount()
+	if err != nil {
+		t.Fatalf("GetAccount failed: %v", err)
+	}
+	if account.ID != "acct" || !account.Equity.Equal(decimal.NewFromInt(1000)) {
+		t.Fatalf("unexpected account %+v", account)
+	}
+
+	positions, err := b.ListPositions()
+	if err != nil || len(positions) != 1 || positions[0].Symbol != "AAPL" {
+		t.Fatalf("unexpected ListPositions result %+v %v", positions, err)
+	}
+
+	orders, err := b.ListOpenOrders()
+	if err != nil {
+		t.Fatalf("ListOpenOrders failed: %v", err)
+	}
+	if len(orders) != 2 || status != "open" {
+		t.Fatalf("expected 2 open orders, got %d (status %q)", len(orders), status)
+	}
+	orders, err = b.ListOrdersSince(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("ListOrdersSince failed: %v", err)
+	}
+	if len(orders) != 2 || status != "all" || nested != "true" {
+		t.Fatalf("expected all nested orders, got %d (status %q, nested %q)", len(orders), status, nested)
+	}
+
+	if err := b.CancelOrder("o1"); err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+	if cancelled != "o1" {
+		t.Fatalf("expected o1 cancelled")
+	}
+
+	limit := decimal.NewFromInt(12)
+	replaced, err := b.ReplaceOrder("o1", ReplaceRequest{LimitPrice: &limit})
+	if err != nil {
+		t.Fatalf("ReplaceOrder failed: %v", err)
+	}
+	if replaced.ID != "o3" {
+		t.Fatalf("expected replacement o3, got %s", replaced.ID)
+	}
+}
+
+func TestClientOrderBot(t *testing.T) {
+	tests := []struct {
+		id  string
+		bot string
+		ok  bool
+	}{
+		{"foo-1700000000000000000", "foo", true},
+		{"foo-bar-1700000000000000000", "foo-bar", true},
+		{"foo-0123456789abcdef0123", "foo", true},
+		{"foo-bar-0123456789abcdef0123-2", "foo-bar", true},
+		{"foo-0123456789abcdef0123-leg", "foo", true},
+		{"close-sim-7", "", false},
+		{"904837e3-3b76-47ec-b432-046db621571b", "", false},
+		{"foo", "", false},
+	}
+	for _, tt := range tests {
+		bot, ok := ClientOrderBot(tt.id)
+		if bot != tt.bot || ok != tt.ok {
+			t.Fatalf("%s: expected %q %v, got %q %v", tt.id, tt.bot, tt.ok, bot, ok)
+		}
+	}
+}
+
+func TestListOrdersPages(t *testing.T) {
+	defer func(n int) { ordersPageSize = n }(ordersPageSize)
+	ordersPageSize = 3
+
+	// Two orders share a second, which a page boundary splits
+	base := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+	all := []struct {
+		id string
+		at time.Time
+	}{
+		{"o1", base},
+		{"o2", base.Add(time.Second)},
+		{"o3", base.Add(2 * time.Second)},
+		{"o4", base.Add(2 * time.Second)},
+		{"o5", base.Add(3 * time.Second)},
+	}
+	var pages int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		if q := r.URL.Query(); q.Get("direction") != "asc" || q.Get("nested") != "true" {
+			t.Errorf("expected ascending, nested orders")
+		}
+		after, _ := time.Parse(time.RFC3339, r.URL.Query().Get("after"))
+		var page []string
+		for _, o := range all {
+			if o.at.After(after) && len(page) < ordersPageSize {
+				page = append(page, `{"id":"`+o.id+`","submitted_at":"`+o.at.Format(time.RFC3339)+`"}`)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[" + strings.Join(page, ",") + "]"))
+	}))
+	defer ts.Close()
+
+	client := NewAlpacaClient("k", "s", ts.URL)
+	for name, list := range map[string]func() ([]alpaca.Order, error){
+		"ListOrdersSince": func() ([]alpaca.Order, error) { return client.ListOrdersSince(base.Add(-time.Hour)) },
+		"ListOpenOrders":  client.ListOpenOrders,
+	} {
+		pages = 0
+		orders, err := list()
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		var ids []string
+		for _, o := range orders {
+			ids = append(ids, o.ID)
+		}
+		if strings.Join(ids, ",") != "o1,o2,o3,o4,o5" || pages < 3 {
+			t.Fatalf("%s: expected o1-o5 over several pages, got %v in %d pages", name, ids, pages)
+		}
+	}
+}
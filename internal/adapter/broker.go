@@ -0,0 +1,52 @@
+package adapter
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+)
+
+// Broker is the trading surface AlertBridge routes alerts to. Orders,
+// positions and accounts use Alpaca's types as the common representation
+// so that other brokers can be added side by side with AlpacaClient.
+type Broker interface {
+	// PlaceOrder submits a new order.
+	PlaceOrder(req OrderRequest) (*alpaca.Order, error)
+	// CancelOrder cancels an open order by broker order ID.
+	CancelOrder(orderID string) error
+	// ReplaceOrder amends an open order by broker order ID.
+	ReplaceOrder(orderID string, req ReplaceRequest) (*alpaca.Order, error)
+	// GetPosition returns the open position in symbol, or ErrNoPosition.
+	GetPosition(symbol string) (*alpaca.Position, error)
+	// ListPositions returns every open position.
+	ListPositions() ([]alpaca.Position, error)
+	// ClosePosition liquidates percent (0-100] of the position in symbol,
+	// or returns ErrNoPosition.
+	ClosePosition(symbol string, percent decimal.Decimal) (*alpaca.Order, error)
+	// GetAccount returns the account balances.
+	GetAccount() (*alpaca.Account, error)
+	// ListOpenOrders returns all orders that are not yet filled or
+	// cancelled. Legs may be nested under their parent, which is then
+	// listed even when it is itself filled.
+	ListOpenOrders() ([]alpaca.Order, error)
+	// ListOrdersSince returns the orders submitted after since, in any
+	// status.
+	ListOrdersSince(since time.Time) ([]alpaca.Order, error)
+	// GetOrder returns an order by broker order ID, or ErrOrderNotFound.
+	GetOrder(orderID string) (*alpaca.Order, error)
+	// GetOrderByClientOrderID returns an order by the client order ID it
+	// was placed with, or ErrOrderNotFound.
+	GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error)
+}
+
+// ReplaceRequest holds the fields of an open order that may be amended.
+// Nil or empty fields are left unchanged.
+type ReplaceRequest struct {
+	Qty         *decimal.Decimal
+	LimitPrice  *decimal.Decimal
+	StopPrice   *decimal.Decimal
+	TimeInForce string
+}
+
+var _ Broker = (*AlpacaClient)(nil)
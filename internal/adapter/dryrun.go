@@ -0,0 +1,170 @@
+package adapter
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// DryRunStatus is the status of the would-be orders returned by a
+// DryRunBroker.
+const DryRunStatus = "dry_run"
+
+// DryRunBroker builds the orders it is asked to place, cancel or replace
+// without submitting them. Positions, accounts and orders are read from the
+// wrapped broker so that closes and position changes are sized as they
+// would be live.
+type DryRunBroker struct {
+	Broker
+	bot    string
+	logger *zap.Logger
+}
+
+// NewDryRunBroker wraps b so that it never trades. Closes are built on
+// behalf of bot.
+func NewDryRunBroker(b Broker, bot string) *DryRunBroker {
+	return &DryRunBroker{Broker: b, bot: bot, logger: zap.NewNop()}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (d *DryRunBroker) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		d.logger = logger
+	}
+}
+
+// Tick ignores the price: passing it on to a simulated broker would fill
+// its resting orders, and a rehearsal must not change the account.
+func (d *DryRunBroker) Tick(symbol string, price decimal.Decimal) {}
+
+// PlaceOrder builds the request Alpaca would receive and returns it as an
+// order with DryRunStatus.
+func (d *DryRunBroker) PlaceOrder(req OrderRequest) (*alpaca.Order, error) {
+	orderRequest := BuildPlaceOrderRequest(req)
+	d.logger.Info("would place order",
+		zap.String("symbol", req.Symbol),
+		zap.String("side", req.Side),
+		zap.Any("request", orderRequest))
+	return dryRunOrder(orderRequest), nil
+}
+
+// ClosePosition returns the market order that would liquidate percent of
+// the position in symbol, or ErrNoPosition.
+func (d *DryRunBroker) ClosePosition(symbol string, percent decimal.Decimal) (*alpaca.Order, error) {
+	pos, err := d.Broker.GetPosition(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if pos.Qty.IsZero() {
+		return nil, ErrNoPosition
+	}
+	side := "sell"
+	if pos.Qty.IsNegative() || pos.Side == "short" {
+		side = "buy"
+	}
+	qty := pos.Qty.Abs().Mul(percent).Div(decimal.NewFromInt(100)).Truncate(9)
+	return d.PlaceOrder(OrderRequest{Bot: d.bot, Symbol: symbol, Side: side, Qty: qty})
+}
+
+// CancelOrder logs the cancellation without sending it.
+func (d *DryRunBroker) CancelOrder(orderID string) error {
+	d.logger.Info("would cancel order", zap.String("orderID", orderID))
+	return nil
+}
+
+// ReplaceOrder returns the open order as it would look after req is
+// applied, with DryRunStatus.
+func (d *DryRunBroker) ReplaceOrder(orderID string, req ReplaceRequest) (*alpaca.Order, error) {
+	order, err := d.Broker.GetOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	replaced := *order
+	if req.Qty != nil {
+		replaced.Qty = req.Qty
+	}
+	if req.LimitPrice != nil {
+		replaced.LimitPrice = req.LimitPrice
+	}
+	if req.StopPrice != nil {
+		replaced.StopPrice = req.StopPrice
+	}
+	if req.TimeInForce != "" {
+		replaced.TimeInForce = alpaca.TimeInForce(req.TimeInForce)
+	}
+	replaced.ID = ""
+	replaced.Replaces = &order.ID
+	replaced.Status = DryRunStatus
+	replaced.Legs = nil
+	d.logger.Info("would replace order",
+		zap.String("orderID", orderID),
+		zap.Any("request", req))
+	return &replaced, nil
+}
+
+// dryRunOrder describes req as the order Alpaca would create for it. Bracket
+// and OTO exits are listed as legs on the opposite side; an OCO order is
+// itself the take-profit exit with the stop loss as its leg.
+func dryRunOrder(req alpaca.PlaceOrderRequest) *alpaca.Order {
+	now := time.Now()
+	order := &alpaca.Order{
+		ClientOrderID: req.ClientOrderID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		SubmittedAt:   now,
+		Symbol:        req.Symbol,
+		AssetClass:    assetClass(req.Symbol),
+		OrderClass:    req.OrderClass,
+		Type:          req.Type,
+		Side:          req.Side,
+		TimeInForce:   req.TimeInForce,
+		Status:        DryRunStatus,
+		Notional:      req.Notional,
+		Qty:           req.Qty,
+		LimitPrice:    req.LimitPrice,
+		StopPrice:     req.StopPrice,
+	}
+	if order.OrderClass == "" {
+		order.OrderClass = alpaca.Simple
+	}
+
+	oco := req.OrderClass == alpaca.OCO
+	exit := alpaca.Sell
+	if req.Side == alpaca.Sell {
+		exit = alpaca.Buy
+	}
+	if oco {
+		exit = req.Side
+	}
+	leg := func(orderType alpaca.OrderType, limit, stop *decimal.Decimal) alpaca.Order {
+		return alpaca.Order{
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Symbol:      req.Symbol,
+			AssetClass:  order.AssetClass,
+			OrderClass:  order.OrderClass,
+			Type:        orderType,
+			Side:        exit,
+			TimeInForce: req.TimeInForce,
+			Status:      DryRunStatus,
+			Qty:         req.Qty,
+			LimitPrice:  limit,
+			StopPrice:   stop,
+		}
+	}
+	if req.TakeProfit != nil && !oco {
+		order.Legs = append(order.Legs, leg(alpaca.Limit, req.TakeProfit.LimitPrice, nil))
+	}
+	if req.StopLoss != nil {
+		orderType := alpaca.Stop
+		if req.StopLoss.LimitPrice != nil {
+			orderType = alpaca.StopLimit
+		}
+		order.Legs = append(order.Legs, leg(orderType, req.StopLoss.LimitPrice, req.StopLoss.StopPrice))
+	}
+	return order
+}
+
+var _ Broker = (*DryRunBroker)(nil)
@@ -0,0 +1,79 @@
+package adapter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+)
+
+func TestDryRunPlaceOrder(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+	d := NewDryRunBroker(s, "b")
+
+	order, err := d.PlaceOrder(OrderRequest{
+		Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("2"),
+		Type: "limit", LimitPrice: decp("99"),
+		OrderClass: "bracket", TakeProfitLimitPrice: decp("110"), StopLossStopPrice: decp("90"),
+		ClientOrderID: "b-sig",
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	if order.ID != "" || order.Status != DryRunStatus || order.ClientOrderID != "b-sig" {
+		t.Fatalf("unexpected dry-run order %+v", order)
+	}
+	if order.TimeInForce != alpaca.Day || !order.Qty.Equal(dec("2")) || !order.LimitPrice.Equal(dec("99")) {
+		t.Fatalf("expected the request Alpaca would receive, got %+v", order)
+	}
+	if len(order.Legs) != 2 || order.Legs[0].Side != alpaca.Sell || order.Legs[1].Type != alpaca.Stop {
+		t.Fatalf("expected take-profit and stop-loss legs, got %+v", order.Legs)
+	}
+
+	if orders, _ := s.ListOpenOrders(); len(orders) != 0 {
+		t.Fatalf("expected nothing sent to the broker, got %d orders", len(orders))
+	}
+	if _, err := s.GetPosition("AAPL"); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected no position, got %v", err)
+	}
+}
+
+func TestDryRunClosePosition(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+	s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("10")})
+	d := NewDryRunBroker(s, "b")
+
+	order, err := d.ClosePosition("AAPL", dec("25"))
+	if err != nil {
+		t.Fatalf("ClosePosition failed: %v", err)
+	}
+	if order.Side != alpaca.Sell || !order.Qty.Equal(dec("2.5")) || order.Status != DryRunStatus {
+		t.Fatalf("unexpected close %+v", order)
+	}
+	if bot, ok := ClientOrderBot(order.ClientOrderID); !ok || bot != "b" {
+		t.Fatalf("expected the close to be tagged with bot b, got %s", order.ClientOrderID)
+	}
+	if pos, _ := s.GetPosition("AAPL"); !pos.Qty.Equal(dec("10")) {
+		t.Fatalf("expected position to be untouched, got %s", pos.Qty)
+	}
+
+	if _, err := d.ClosePosition("MSFT", dec("100")); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected ErrNoPosition, got %v", err)
+	}
+}
+
+func TestDryRunTickIgnored(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+	resting, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), Type: "limit", LimitPrice: decp("90")})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+
+	NewDryRunBroker(s, "b").Tick("AAPL", dec("80"))
+	if order, _ := s.GetOrder(resting.ID); order.Status == "filled" {
+		t.Fatal("expected a rehearsal price not to fill the resting order")
+	}
+}
@@ -0,0 +1,811 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // the trading day is kept in New York time
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// Order statuses used by the simulator, matching Alpaca's.
+const (
+	simStatusNew      = "new"
+	simStatusFilled   = "filled"
+	simStatusCanceled = "canceled"
+	simStatusReplaced = "replaced"
+)
+
+// simZone is the time zone of the US trading day, in which the simulator
+// rolls last_equity over as Alpaca does.
+var simZone = mustLoadLocation("America/New_York")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// ErrNoPrice is returned by the simulator when it has no price for a symbol.
+var ErrNoPrice = errors.New("no price available")
+
+// PriceSource supplies the latest price for a symbol.
+type PriceSource interface {
+	Price(symbol string) (decimal.Decimal, error)
+}
+
+// StaticPrices is a PriceSource backed by a fixed map of prices.
+type StaticPrices map[string]decimal.Decimal
+
+// Price implements PriceSource.
+func (p StaticPrices) Price(symbol string) (decimal.Decimal, error) {
+	if v, ok := p[symbol]; ok {
+		return v, nil
+	}
+	if v, ok := p[positionSymbol(symbol)]; ok {
+		return v, nil
+	}
+	return decimal.Zero, fmt.Errorf("%w for %s", ErrNoPrice, symbol)
+}
+
+// Ticker is implemented by brokers that consume the reference price sent
+// with an alert, such as SimBroker.
+type Ticker interface {
+	Tick(symbol string, price decimal.Decimal)
+}
+
+// SimBroker is an in-memory paper-trading Broker. Market orders fill at the
+// latest price seen for the symbol, either from Tick or from the configured
+// PriceSource. Limit, stop and stop-limit orders rest until a later Tick
+// makes them marketable. Bracket and OTO legs are placed when the entry
+// fills and OCO legs cancel each other. When a state path is configured the
+// account is saved after every change and restored on start.
+type SimBroker struct {
+	mu        sync.Mutex
+	logger    *zap.Logger
+	prices    PriceSource
+	statePath string
+	state     simState
+	now       func() time.Time
+}
+
+type simState struct {
+	Cash       decimal.Decimal            `json:"cash"`
+	LastEquity decimal.Decimal            `json:"last_equity"`
+	Day        string                     `json:"day"`
+	NextID     int64                      `json:"next_id"`
+	Positions  map[string]*simPosition    `json:"positions"`
+	Prices     map[string]decimal.Decimal `json:"prices"`
+	Orders     []*simOrder                `json:"orders"`
+}
+
+type simPosition struct {
+	Symbol        string          `json:"symbol"`
+	Qty           decimal.Decimal `json:"qty"`
+	AvgEntryPrice decimal.Decimal `json:"avg_entry_price"`
+}
+
+// simOrder is an order plus the bookkeeping needed to trigger it later.
+type simOrder struct {
+	Order     alpaca.Order `json:"order"`
+	Triggered bool         `json:"triggered,omitempty"` // stop of a stop-limit has been hit
+	Group     string       `json:"group,omitempty"`     // OCO group; a fill cancels the rest
+
+	// Exit legs placed when this entry fills.
+	TakeProfit    *decimal.Decimal `json:"take_profit,omitempty"`
+	StopLoss      *decimal.Decimal `json:"stop_loss,omitempty"`
+	StopLossLimit *decimal.Decimal `json:"stop_loss_limit,omitempty"`
+}
+
+// NewSimBroker creates a simulator funded with cash. When statePath is
+// non-empty and the file exists, the saved account is restored instead.
+func NewSimBroker(cash decimal.Decimal, statePath string) (*SimBroker, error) {
+	s := &SimBroker{
+		logger:    zap.NewNop(),
+		statePath: statePath,
+		now:       time.Now,
+		state: simState{
+			Cash:       cash,
+			LastEquity: cash,
+			Positions:  make(map[string]*simPosition),
+			Prices:     make(map[string]decimal.Decimal),
+		},
+	}
+	if statePath == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sim state: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return nil, fmt.Errorf("failed to decode sim state: %w", err)
+	}
+	if s.state.Positions == nil {
+		s.state.Positions = make(map[string]*simPosition)
+	}
+	if s.state.Prices == nil {
+		s.state.Prices = make(map[string]decimal.Decimal)
+	}
+	return s, nil
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (s *SimBroker) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		s.logger = logger
+	}
+}
+
+// SetPriceSource sets where prices come from when no tick has been seen.
+func (s *SimBroker) SetPriceSource(p PriceSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prices = p
+}
+
+// Tick records the latest price for symbol and fills any resting orders
+// it makes marketable.
+func (s *SimBroker) Tick(symbol string, price decimal.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollDay()
+	s.state.Prices[positionSymbol(symbol)] = price
+	if s.match(symbol) {
+		s.save()
+	}
+}
+
+// PlaceOrder implements Broker.
+func (s *SimBroker) PlaceOrder(req OrderRequest) (*alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollDay()
+	return s.placeOrderLocked(req)
+}
+
+// placeOrderLocked places req. The caller holds s.mu.
+func (s *SimBroker) placeOrderLocked(req OrderRequest) (*alpaca.Order, error) {
+	// Like Alpaca, refuse a client order ID already in use, so that a
+	// resubmitted order cannot fill twice
+	req.ClientOrderID = clientOrderID(req)
+	for _, so := range s.state.Orders {
+		if so.Order.ClientOrderID == req.ClientOrderID {
+			return nil, &alpaca.APIError{StatusCode: http.StatusUnprocessableEntity, Message: "client_order_id must be unique"}
+		}
+	}
+	side := alpaca.Side(req.Side)
+	if side != alpaca.Buy && side != alpaca.Sell {
+		return nil, fmt.Errorf("invalid side %q", req.Side)
+	}
+	orderType := alpaca.Market
+	if req.Type != "" {
+		orderType = alpaca.OrderType(req.Type)
+	}
+	timeInForce := alpaca.Day
+	if IsCrypto(req.Symbol) {
+		timeInForce = alpaca.GTC
+	}
+	if req.TimeInForce != "" {
+		timeInForce = alpaca.TimeInForce(req.TimeInForce)
+	}
+
+	if (orderType == alpaca.Limit || orderType == alpaca.StopLimit) && req.LimitPrice == nil &&
+		alpaca.OrderClass(req.OrderClass) != alpaca.OCO {
+		return nil, fmt.Errorf("limit_price is required for %s orders", orderType)
+	}
+	if (orderType == alpaca.Stop || orderType == alpaca.StopLimit) && req.StopPrice == nil {
+		return nil, fmt.Errorf("stop_price is required for %s orders", orderType)
+	}
+	if alpaca.OrderClass(req.OrderClass) == alpaca.OCO && (req.TakeProfitLimitPrice == nil || req.StopLossStopPrice == nil) {
+		return nil, errors.New("oco orders require take profit and stop loss prices")
+	}
+
+	price, havePrice := s.price(req.Symbol)
+	if orderType == alpaca.Market && !havePrice {
+		return nil, fmt.Errorf("%w for %s: include price in the alert or configure a price source", ErrNoPrice, req.Symbol)
+	}
+
+	qty := req.Qty
+	if req.Notional != nil {
+		if !havePrice {
+			return nil, fmt.Errorf("%w for %s: notional orders need a price", ErrNoPrice, req.Symbol)
+		}
+		qty = req.Notional.Div(price).Truncate(9)
+	}
+	if !qty.IsPositive() {
+		return nil, errors.New("qty must be positive")
+	}
+
+	// Buys must be covered by cash at the best known price estimate,
+	// except the part that buys back a short, whose sale credited the cash
+	if side == alpaca.Buy {
+		estimate := price
+		if req.LimitPrice != nil && (!havePrice || req.LimitPrice.LessThan(price)) {
+			estimate = *req.LimitPrice
+		}
+		opening := qty
+		if pos, ok := s.state.Positions[positionSymbol(req.Symbol)]; ok && pos.Qty.IsNegative() {
+			opening = decimal.Max(qty.Sub(pos.Qty.Neg()), decimal.Zero)
+		}
+		if cost := opening.Mul(estimate); cost.GreaterThan(s.state.Cash) {
+			return nil, fmt.Errorf("insufficient buying power: need %s, have %s", cost.StringFixed(2), s.state.Cash.StringFixed(2))
+		}
+	}
+
+	so := s.newOrder(req, side, orderType, timeInForce, qty)
+	so.Order.LimitPrice = req.LimitPrice
+	so.Order.StopPrice = req.StopPrice
+	so.Order.Notional = req.Notional
+
+	switch alpaca.OrderClass(req.OrderClass) {
+	case alpaca.Bracket, alpaca.OTO:
+		so.Order.OrderClass = alpaca.OrderClass(req.OrderClass)
+		so.TakeProfit = req.TakeProfitLimitPrice
+		so.StopLoss = req.StopLossStopPrice
+		so.StopLossLimit = req.StopLossLimitPrice
+		s.state.Orders = append(s.state.Orders, so)
+	case alpaca.OCO:
+		// The parent is the take-profit limit and the stop is its leg.
+		so.Order.OrderClass = alpaca.OCO
+		so.Order.Type = alpaca.Limit
+		so.Order.LimitPrice = req.TakeProfitLimitPrice
+		so.Group = so.Order.ID
+		stop := s.newExit(&so.Order, alpaca.Stop, nil, req.StopLossStopPrice)
+		if req.StopLossLimitPrice != nil {
+			stop.Order.Type = alpaca.StopLimit
+			stop.Order.LimitPrice = req.StopLossLimitPrice
+		}
+		so.Order.Legs = []alpaca.Order{stop.Order}
+		s.state.Orders = append(s.state.Orders, so, stop)
+	default:
+		s.state.Orders = append(s.state.Orders, so)
+	}
+
+	s.match(req.Symbol)
+	s.save()
+
+	s.logger.Info("sim order placed",
+		zap.String("symbol", req.Symbol),
+		zap.String("side", req.Side),
+		zap.String("qty", qty.String()),
+		zap.String("type", string(orderType)),
+		zap.String("status", so.Order.Status),
+		zap.String("orderID", so.Order.ID))
+
+	order := so.Order
+	return &order, nil
+}
+
+// CancelOrder implements Broker.
+func (s *SimBroker) CancelOrder(orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	so := s.find(orderID)
+	if so == nil {
+		return fmt.Errorf("order %s not found", orderID)
+	}
+	if so.Order.Status != simStatusNew {
+		return fmt.Errorf("order %s is %s", orderID, so.Order.Status)
+	}
+	s.setStatus(so, simStatusCanceled)
+	s.save()
+	return nil
+}
+
+// ReplaceOrder implements Broker.
+func (s *SimBroker) ReplaceOrder(orderID string, req ReplaceRequest) (*alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.find(orderID)
+	if old == nil {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	if old.Order.Status != simStatusNew {
+		return nil, fmt.Errorf("order %s is %s", orderID, old.Order.Status)
+	}
+
+	so := *old
+	so.Order.ID = s.nextID()
+	so.Order.CreatedAt = s.now()
+	so.Order.UpdatedAt = so.Order.CreatedAt
+	so.Order.SubmittedAt = so.Order.CreatedAt
+	so.Order.Replaces = &old.Order.ID
+	if req.Qty != nil {
+		so.Order.Qty = req.Qty
+	}
+	if req.LimitPrice != nil {
+		so.Order.LimitPrice = req.LimitPrice
+	}
+	if req.StopPrice != nil {
+		so.Order.StopPrice = req.StopPrice
+	}
+	if req.TimeInForce != "" {
+		so.Order.TimeInForce = alpaca.TimeInForce(req.TimeInForce)
+	}
+
+	s.setStatus(old, simStatusReplaced)
+	old.Order.ReplacedBy = &so.Order.ID
+	s.state.Orders = append(s.state.Orders, &so)
+	s.match(so.Order.Symbol)
+	s.save()
+
+	order := so.Order
+	return &order, nil
+}
+
+// GetPosition implements Broker.
+func (s *SimBroker) GetPosition(symbol string) (*alpaca.Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos, ok := s.state.Positions[positionSymbol(symbol)]
+	if !ok || pos.Qty.IsZero() {
+		return nil, ErrNoPosition
+	}
+	return s.position(pos), nil
+}
+
+// ListPositions implements Broker, in symbol order.
+func (s *SimBroker) ListPositions() ([]alpaca.Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var positions []alpaca.Position
+	for _, pos := range s.state.Positions {
+		if !pos.Qty.IsZero() {
+			positions = append(positions, *s.position(pos))
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Symbol < positions[j].Symbol })
+	return positions, nil
+}
+
+// ClosePosition implements Broker.
+func (s *SimBroker) ClosePosition(symbol string, percent decimal.Decimal) (*alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollDay()
+	pos, ok := s.state.Positions[positionSymbol(symbol)]
+	if !ok || pos.Qty.IsZero() {
+		return nil, ErrNoPosition
+	}
+	qty := pos.Qty.Abs().Mul(percent).Div(decimal.NewFromInt(100)).Truncate(9)
+	side := "sell"
+	if pos.Qty.IsNegative() {
+		side = "buy"
+	}
+	// Like Alpaca's, the close belongs to no bot. It is placed under the
+	// same lock so that the position cannot change in between
+	id := "close-" + s.nextID()
+	return s.placeOrderLocked(OrderRequest{Symbol: symbol, Side: side, Qty: qty, ClientOrderID: id})
+}
+
+// GetAccount implements Broker. Equity marks positions at the latest price.
+func (s *SimBroker) GetAccount() (*alpaca.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollDay()
+
+	long, short := decimal.Zero, decimal.Zero
+	for _, pos := range s.state.Positions {
+		value := pos.Qty.Mul(s.mark(pos))
+		if value.IsNegative() {
+			short = short.Add(value)
+		} else {
+			long = long.Add(value)
+		}
+	}
+	equity := s.state.Cash.Add(long).Add(short)
+	return &alpaca.Account{
+		ID:               "sim",
+		Status:           "ACTIVE",
+		Currency:         "USD",
+		Cash:             s.state.Cash,
+		BuyingPower:      s.state.Cash,
+		PortfolioValue:   equity,
+		Equity:           equity,
+		LastEquity:       s.state.LastEquity,
+		LongMarketValue:  long,
+		ShortMarketValue: short,
+		ShortingEnabled:  true,
+	}, nil
+}
+
+// ListOpenOrders implements Broker.
+func (s *SimBroker) ListOpenOrders() ([]alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orders []alpaca.Order
+	for _, so := range s.state.Orders {
+		if so.Order.Status == simStatusNew {
+			orders = append(orders, so.Order)
+		}
+	}
+	return orders, nil
+}
+
+// ListOrdersSince implements Broker. Legs are listed as separate orders.
+func (s *SimBroker) ListOrdersSince(since time.Time) ([]alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orders []alpaca.Order
+	for _, so := range s.state.Orders {
+		if so.Order.CreatedAt.After(since) {
+			orders = append(orders, so.Order)
+		}
+	}
+	return orders, nil
+}
+
+// GetOrder implements Broker.
+func (s *SimBroker) GetOrder(orderID string) (*alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	so := s.find(orderID)
+	if so == nil {
+		return nil, ErrOrderNotFound
+	}
+	order := so.Order
+	return &order, nil
+}
+
+// GetOrderByClientOrderID implements Broker.
+func (s *SimBroker) GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, so := range s.state.Orders {
+		if so.Order.ClientOrderID == clientOrderID {
+			order := so.Order
+			return &order, nil
+		}
+	}
+	return nil, ErrOrderNotFound
+}
+
+// newOrder creates an open order with a fresh ID.
+func (s *SimBroker) newOrder(req OrderRequest, side alpaca.Side, orderType alpaca.OrderType, tif alpaca.TimeInForce, qty decimal.Decimal) *simOrder {
+	now := s.now()
+	return &simOrder{Order: alpaca.Order{
+		ID:            s.nextID(),
+		ClientOrderID: clientOrderID(req),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		SubmittedAt:   now,
+		Symbol:        req.Symbol,
+		AssetClass:    assetClass(req.Symbol),
+		OrderClass:    alpaca.Simple,
+		Type:          orderType,
+		Side:          side,
+		TimeInForce:   tif,
+		Status:        simStatusNew,
+		Qty:           &qty,
+	}}
+}
+
+// newExit creates an exit leg that closes the filled quantity of parent.
+func (s *SimBroker) newExit(parent *alpaca.Order, orderType alpaca.OrderType, limit, stop *decimal.Decimal) *simOrder {
+	side := alpaca.Sell
+	if parent.Side == alpaca.Sell {
+		side = alpaca.Buy
+	}
+	// An OCO parent is itself the exit, so its stop leg shares its side.
+	if parent.OrderClass == alpaca.OCO {
+		side = parent.Side
+	}
+	now := s.now()
+	qty := *parent.Qty
+	return &simOrder{
+		Group: parent.ID,
+		Order: alpaca.Order{
+			ID:            s.nextID(),
+			ClientOrderID: parent.ClientOrderID + "-leg",
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			SubmittedAt:   now,
+			Symbol:        parent.Symbol,
+			AssetClass:    parent.AssetClass,
+			OrderClass:    parent.OrderClass,
+			Type:          orderType,
+			Side:          side,
+			TimeInForce:   parent.TimeInForce,
+			Status:        simStatusNew,
+			Qty:           &qty,
+			LimitPrice:    limit,
+			StopPrice:     stop,
+		},
+	}
+}
+
+// match fills every open order in symbol that the latest price makes
+// marketable, repeating while fills place new legs. It reports whether
+// anything changed.
+func (s *SimBroker) match(symbol string) bool {
+	price, ok := s.price(symbol)
+	if !ok {
+		return false
+	}
+	key := positionSymbol(symbol)
+	changed := false
+	for {
+		filled := false
+		// Iterate over a snapshot since fills append legs.
+		for _, so := range append([]*simOrder(nil), s.state.Orders...) {
+			if so.Order.Status != simStatusNew || positionSymbol(so.Order.Symbol) != key {
+				continue
+			}
+			if s.marketable(so, price) {
+				s.fill(so, price)
+				filled, changed = true, true
+			}
+		}
+		if !filled {
+			return changed
+		}
+	}
+}
+
+// marketable reports whether so would fill at price. It latches the stop
+// of a stop-limit order once hit.
+func (s *SimBroker) marketable(so *simOrder, price decimal.Decimal) bool {
+	o := &so.Order
+	buy := o.Side == alpaca.Buy
+	stopHit := func() bool {
+		if buy {
+			return price.GreaterThanOrEqual(*o.StopPrice)
+		}
+		return price.LessThanOrEqual(*o.StopPrice)
+	}
+	limitOK := func() bool {
+		if buy {
+			return price.LessThanOrEqual(*o.LimitPrice)
+		}
+		return price.GreaterThanOrEqual(*o.LimitPrice)
+	}
+
+	switch o.Type {
+	case alpaca.Market:
+		return true
+	case alpaca.Limit:
+		return limitOK()
+	case alpaca.Stop:
+		return stopHit()
+	case alpaca.StopLimit:
+		if !so.Triggered && stopHit() {
+			so.Triggered = true
+		}
+		return so.Triggered && limitOK()
+	}
+	return false
+}
+
+// fill executes so at price, updating cash and the position, placing any
+// exit legs and cancelling OCO siblings.
+func (s *SimBroker) fill(so *simOrder, price decimal.Decimal) {
+	o := &so.Order
+	now := s.now()
+	qty := *o.Qty
+	fillPrice := price
+	o.Status = simStatusFilled
+	o.FilledQty = qty
+	o.FilledAvgPrice = &fillPrice
+	o.FilledAt = &now
+	o.UpdatedAt = now
+
+	signed := qty
+	if o.Side == alpaca.Sell {
+		signed = qty.Neg()
+	}
+	s.state.Cash = s.state.Cash.Sub(signed.Mul(price))
+
+	key := positionSymbol(o.Symbol)
+	pos, ok := s.state.Positions[key]
+	if !ok {
+		pos = &simPosition{Symbol: key}
+		s.state.Positions[key] = pos
+	}
+	newQty := pos.Qty.Add(signed)
+	switch {
+	case newQty.IsZero():
+		delete(s.state.Positions, key)
+	case pos.Qty.IsZero() || pos.Qty.Sign() == signed.Sign():
+		// Opening or adding: weight the average entry price
+		pos.AvgEntryPrice = pos.AvgEntryPrice.Mul(pos.Qty.Abs()).Add(price.Mul(qty)).Div(newQty.Abs())
+	case newQty.Sign() != pos.Qty.Sign():
+		// Reversed through zero: the remainder was opened at this price
+		pos.AvgEntryPrice = price
+	}
+	pos.Qty = newQty
+
+	s.logger.Info("sim order filled",
+		zap.String("symbol", o.Symbol),
+		zap.String("side", string(o.Side)),
+		zap.String("qty", qty.String()),
+		zap.String("price", price.String()),
+		zap.String("orderID", o.ID))
+
+	if so.Group != "" {
+		for _, other := range s.state.Orders {
+			if other != so && other.Group == so.Group && other.Order.Status == simStatusNew {
+				s.setStatus(other, simStatusCanceled)
+			}
+		}
+	}
+
+	if so.TakeProfit == nil && so.StopLoss == nil {
+		return
+	}
+	var legs []*simOrder
+	if so.TakeProfit != nil {
+		legs = append(legs, s.newExit(o, alpaca.Limit, so.TakeProfit, nil))
+	}
+	if so.StopLoss != nil {
+		leg := s.newExit(o, alpaca.Stop, nil, so.StopLoss)
+		if so.StopLossLimit != nil {
+			leg.Order.Type = alpaca.StopLimit
+			leg.Order.LimitPrice = so.StopLossLimit
+		}
+		legs = append(legs, leg)
+	}
+	for _, leg := range legs {
+		s.state.Orders = append(s.state.Orders, leg)
+		o.Legs = append(o.Legs, leg.Order)
+	}
+}
+
+// setStatus updates the status of so and stamps the matching timestamp.
+func (s *SimBroker) setStatus(so *simOrder, status string) {
+	now := s.now()
+	so.Order.Status = status
+	so.Order.UpdatedAt = now
+	if status == simStatusCanceled {
+		so.Order.CanceledAt = &now
+	}
+	if status == simStatusReplaced {
+		so.Order.ReplacedAt = &now
+	}
+}
+
+// price returns the latest price for symbol, consulting the price source
+// when no tick has been seen.
+func (s *SimBroker) price(symbol string) (decimal.Decimal, bool) {
+	key := positionSymbol(symbol)
+	if p, ok := s.state.Prices[key]; ok {
+		return p, true
+	}
+	if s.prices == nil {
+		return decimal.Zero, false
+	}
+	p, err := s.prices.Price(symbol)
+	if err != nil {
+		s.logger.Debug("sim price lookup failed",
+			zap.String("symbol", symbol),
+			zap.Error(err))
+		return decimal.Zero, false
+	}
+	s.state.Prices[key] = p
+	return p, true
+}
+
+// mark returns the price used to value pos.
+func (s *SimBroker) mark(pos *simPosition) decimal.Decimal {
+	if p, ok := s.state.Prices[pos.Symbol]; ok {
+		return p
+	}
+	return pos.AvgEntryPrice
+}
+
+// position converts pos into Alpaca's representation.
+func (s *SimBroker) position(pos *simPosition) *alpaca.Position {
+	price := s.mark(pos)
+	marketValue := pos.Qty.Mul(price)
+	costBasis := pos.Qty.Mul(pos.AvgEntryPrice)
+	unrealized := marketValue.Sub(costBasis)
+	side := "long"
+	if pos.Qty.IsNegative() {
+		side = "short"
+	}
+	return &alpaca.Position{
+		Symbol:        pos.Symbol,
+		AssetClass:    assetClass(pos.Symbol),
+		Qty:           pos.Qty,
+		QtyAvailable:  pos.Qty,
+		AvgEntryPrice: pos.AvgEntryPrice,
+		Side:          side,
+		MarketValue:   &marketValue,
+		CostBasis:     costBasis,
+		UnrealizedPL:  &unrealized,
+		CurrentPrice:  &price,
+	}
+}
+
+// find returns the order with the given ID, or nil.
+func (s *SimBroker) find(orderID string) *simOrder {
+	for _, so := range s.state.Orders {
+		if so.Order.ID == orderID {
+			return so
+		}
+	}
+	return nil
+}
+
+func (s *SimBroker) nextID() string {
+	s.state.NextID++
+	return fmt.Sprintf("sim-%d", s.state.NextID)
+}
+
+// rollDay snapshots equity as last_equity at the first call of each New
+// York day so daily PnL can be computed the same way as for Alpaca
+// accounts.
+func (s *SimBroker) rollDay() {
+	today := s.now().In(simZone).Format("2006-01-02")
+	if s.state.Day == today {
+		return
+	}
+	equity := s.state.Cash
+	for _, pos := range s.state.Positions {
+		equity = equity.Add(pos.Qty.Mul(s.mark(pos)))
+	}
+	s.state.LastEquity = equity
+	s.state.Day = today
+}
+
+// save writes the state file, if configured, via a temporary file so a
+// crash never leaves it half written.
+func (s *SimBroker) save() {
+	if s.statePath == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		s.logger.Error("failed to encode sim state", zap.Error(err))
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.statePath), filepath.Base(s.statePath)+".tmp")
+	if err != nil {
+		s.logger.Error("failed to save sim state", zap.Error(err))
+		return
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		s.logger.Error("failed to save sim state", zap.Error(err))
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.statePath); err != nil {
+		os.Remove(tmp.Name())
+		s.logger.Error("failed to save sim state", zap.Error(err))
+	}
+}
+
+func assetClass(symbol string) alpaca.AssetClass {
+	if strings.Contains(symbol, "/") || IsCrypto(symbol) {
+		return alpaca.Crypto
+	}
+	return alpaca.USEquity
+}
+
+var _ Broker = (*SimBroker)(nil)
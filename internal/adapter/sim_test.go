@@ -0,0 +1,293 @@
+package adapter
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+)
+
+func dec(v string) decimal.Decimal {
+	return decimal.RequireFromString(v)
+}
+
+func decp(v string) *decimal.Decimal {
+	d := dec(v)
+	return &d
+}
+
+func TestSimMarketOrderFillsAtTick(t *testing.T) {
+	s, err := NewSimBroker(dec("10000"), "")
+	if err != nil {
+		t.Fatalf("NewSimBroker failed: %v", err)
+	}
+
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("10")}); !errors.Is(err, ErrNoPrice) {
+		t.Fatalf("expected ErrNoPrice, got %v", err)
+	}
+
+	s.Tick("AAPL", dec("100"))
+	order, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("10")})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	if order.Status != "filled" || !order.FilledAvgPrice.Equal(dec("100")) {
+		t.Fatalf("expected fill at 100, got %s %v", order.Status, order.FilledAvgPrice)
+	}
+
+	pos, err := s.GetPosition("AAPL")
+	if err != nil {
+		t.Fatalf("GetPosition failed: %v", err)
+	}
+	if !pos.Qty.Equal(dec("10")) || pos.Side != "long" {
+		t.Fatalf("unexpected position %+v", pos)
+	}
+
+	s.Tick("AAPL", dec("110"))
+	account, _ := s.GetAccount()
+	if !account.Cash.Equal(dec("9000")) || !account.Equity.Equal(dec("10100")) {
+		t.Fatalf("unexpected account cash %s equity %s", account.Cash, account.Equity)
+	}
+}
+
+func TestSimLimitAndStopTrigger(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+
+	limit, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), Type: "limit", LimitPrice: decp("95")})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	if limit.Status != "new" {
+		t.Fatalf("expected resting limit, got %s", limit.Status)
+	}
+	stopLimit, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "sell", Qty: dec("1"), Type: "stop_limit",
+		StopPrice: decp("94"), LimitPrice: decp("93")})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+
+	open, _ := s.ListOpenOrders()
+	if len(open) != 2 {
+		t.Fatalf("expected 2 open orders, got %d", len(open))
+	}
+
+	// 92 fills the limit and triggers the stop; the stop-limit then fills
+	// once the price is back above its limit.
+	s.Tick("AAPL", dec("92"))
+	open, _ = s.ListOpenOrders()
+	if len(open) != 1 || open[0].ID != stopLimit.ID {
+		t.Fatalf("expected only the triggered stop-limit open, got %+v", open)
+	}
+	s.Tick("AAPL", dec("93.5"))
+	open, _ = s.ListOpenOrders()
+	if len(open) != 0 {
+		t.Fatalf("expected no open orders, got %d", len(open))
+	}
+	if _, err := s.GetPosition("AAPL"); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected flat position, got %v", err)
+	}
+}
+
+func TestSimBracketLegs(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+
+	entry, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("5"),
+		OrderClass: "bracket", TakeProfitLimitPrice: decp("110"), StopLossStopPrice: decp("95")})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	if entry.Status != "filled" || len(entry.Legs) != 2 {
+		t.Fatalf("expected filled entry with 2 legs, got %s with %d", entry.Status, len(entry.Legs))
+	}
+
+	s.Tick("AAPL", dec("111"))
+	open, _ := s.ListOpenOrders()
+	if len(open) != 0 {
+		t.Fatalf("expected stop leg cancelled after take profit, got %d open", len(open))
+	}
+	if _, err := s.GetPosition("AAPL"); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected flat position, got %v", err)
+	}
+	account, _ := s.GetAccount()
+	if !account.Cash.Equal(dec("10055")) {
+		t.Fatalf("expected cash 10055, got %s", account.Cash)
+	}
+}
+
+func TestSimClosePositionAndReversal(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.SetPriceSource(StaticPrices{"BTC/USD": dec("50000")})
+
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "BTC/USD", Side: "buy", Notional: decp("1000")}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	pos, err := s.GetPosition("BTCUSD")
+	if err != nil || !pos.Qty.Equal(dec("0.02")) {
+		t.Fatalf("expected 0.02 BTC, got %v %v", pos, err)
+	}
+
+	if _, err := s.ClosePosition("BTC/USD", dec("50")); err != nil {
+		t.Fatalf("ClosePosition failed: %v", err)
+	}
+	pos, _ = s.GetPosition("BTC/USD")
+	if !pos.Qty.Equal(dec("0.01")) {
+		t.Fatalf("expected 0.01 BTC left, got %s", pos.Qty)
+	}
+
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "BTC/USD", Side: "sell", Qty: dec("0.03")}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	pos, _ = s.GetPosition("BTC/USD")
+	if !pos.Qty.Equal(dec("-0.02")) || pos.Side != "short" || !pos.AvgEntryPrice.Equal(dec("50000")) {
+		t.Fatalf("unexpected reversed position %+v", pos)
+	}
+	if positions, err := s.ListPositions(); err != nil || len(positions) != 1 || !positions[0].Qty.Equal(dec("-0.02")) {
+		t.Fatalf("unexpected ListPositions result %+v %v", positions, err)
+	}
+}
+
+func TestSimGetOrder(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+	placed, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), ClientOrderID: "b-123"})
+	if err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+
+	order, err := s.GetOrder(placed.ID)
+	if err != nil || order.Status != "filled" {
+		t.Fatalf("unexpected GetOrder result %+v %v", order, err)
+	}
+	if order, err := s.GetOrderByClientOrderID("b-123"); err != nil || order.ID != placed.ID {
+		t.Fatalf("unexpected GetOrderByClientOrderID result %+v %v", order, err)
+	}
+	if _, err := s.GetOrder("sim-999"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if _, err := s.GetOrderByClientOrderID("other"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if orders, err := s.ListOrdersSince(placed.CreatedAt.Add(-time.Second)); err != nil || len(orders) != 1 || orders[0].ID != placed.ID {
+		t.Fatalf("unexpected ListOrdersSince result %+v %v", orders, err)
+	}
+	if orders, _ := s.ListOrdersSince(placed.CreatedAt); len(orders) != 0 {
+		t.Fatalf("expected no orders after the last one, got %+v", orders)
+	}
+}
+
+func TestSimDuplicateClientOrderID(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), ClientOrderID: "b-123"}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	_, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), ClientOrderID: "b-123"})
+	var apiErr *alpaca.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422 for the reused client order ID, got %v", err)
+	}
+	if pos, _ := s.GetPosition("AAPL"); !pos.Qty.Equal(dec("1")) {
+		t.Fatalf("expected one share bought, got %s", pos.Qty)
+	}
+}
+
+func TestSimRollDayNewYork(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	// 23:00 in New York on March 9th, already the 10th in UTC
+	now := time.Date(2026, 3, 10, 3, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	s.Tick("AAPL", dec("100"))
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("10")}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	s.Tick("AAPL", dec("110"))
+	if account, _ := s.GetAccount(); !account.LastEquity.Equal(dec("10000")) {
+		t.Fatalf("expected the day not to roll before midnight in New York, got last_equity %s", account.LastEquity)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if account, _ := s.GetAccount(); !account.LastEquity.Equal(dec("10100")) {
+		t.Fatalf("expected the day to roll at midnight in New York, got last_equity %s", account.LastEquity)
+	}
+}
+
+func TestSimInsufficientCash(t *testing.T) {
+	s, _ := NewSimBroker(dec("100"), "")
+	s.Tick("AAPL", dec("100"))
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("2")}); err == nil {
+		t.Fatalf("expected insufficient buying power error")
+	}
+
+	// Buying back a short needs no cash, only the part that goes long does
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "sell", Qty: dec("1")}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	s.Tick("AAPL", dec("300"))
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("2")}); err == nil {
+		t.Fatalf("expected insufficient buying power for the long part")
+	}
+	if _, err := s.ClosePosition("AAPL", dec("100")); err != nil {
+		t.Fatalf("expected the short to be covered, got %v", err)
+	}
+	if _, err := s.GetPosition("AAPL"); !errors.Is(err, ErrNoPosition) {
+		t.Fatalf("expected no position, got %v", err)
+	}
+}
+
+func TestSimCancelAndReplace(t *testing.T) {
+	s, _ := NewSimBroker(dec("10000"), "")
+	s.Tick("AAPL", dec("100"))
+
+	order, _ := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), Type: "limit", LimitPrice: decp("90")})
+	replaced, err := s.ReplaceOrder(order.ID, ReplaceRequest{LimitPrice: decp("101")})
+	if err != nil {
+		t.Fatalf("ReplaceOrder failed: %v", err)
+	}
+	if replaced.Status != "filled" {
+		t.Fatalf("expected marketable replacement to fill, got %s", replaced.Status)
+	}
+	if err := s.CancelOrder(order.ID); err == nil {
+		t.Fatalf("expected error cancelling replaced order")
+	}
+
+	resting, _ := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("1"), Type: "limit", LimitPrice: decp("90")})
+	if err := s.CancelOrder(resting.ID); err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+	open, _ := s.ListOpenOrders()
+	if len(open) != 0 {
+		t.Fatalf("expected no open orders, got %d", len(open))
+	}
+}
+
+func TestSimStatePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.json")
+
+	s, err := NewSimBroker(dec("1000"), path)
+	if err != nil {
+		t.Fatalf("NewSimBroker failed: %v", err)
+	}
+	s.Tick("AAPL", dec("10"))
+	if _, err := s.PlaceOrder(OrderRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: dec("3")}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+
+	restored, err := NewSimBroker(dec("1000"), path)
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	pos, err := restored.GetPosition("AAPL")
+	if err != nil || !pos.Qty.Equal(dec("3")) {
+		t.Fatalf("expected restored position of 3, got %v %v", pos, err)
+	}
+	account, _ := restored.GetAccount()
+	if !account.Cash.Equal(dec("970")) {
+		t.Fatalf("expected restored cash 970, got %s", account.Cash)
+	}
+}
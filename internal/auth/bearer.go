@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrMissingToken is returned when a request carries no bearer token.
+	ErrMissingToken = errors.New("missing bearer token")
+	// ErrInvalidToken is returned when a bearer token does not match.
+	ErrInvalidToken = errors.New("invalid bearer token")
+)
+
+// VerifyBearer checks that r carries token in an "Authorization: Bearer"
+// header. The comparison takes constant time.
+func VerifyBearer(r *http.Request, token string) error {
+	scheme, provided, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || provided == "" {
+		return ErrMissingToken
+	}
+	if VerifyPassphrase(token, strings.TrimSpace(provided)) != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyBearer(t *testing.T) {
+	tests := []struct {
+		header string
+		want   error
+	}{
+		{"Bearer s3cret", nil},
+		{"bearer s3cret", nil},
+		{"Bearer wrong", ErrInvalidToken},
+		{"Basic s3cret", ErrMissingToken},
+		{"Bearer ", ErrMissingToken},
+		{"", ErrMissingToken},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if err := VerifyBearer(r, "s3cret"); !errors.Is(err, tt.want) {
+			t.Fatalf("VerifyBearer(%q) = %v, want %v", tt.header, err, tt.want)
+		}
+	}
+}
@@ -4,16 +4,31 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
+)
+
+// Timestamp errors returned by CheckFreshness.
+var (
+	ErrMissingTimestamp = errors.New("alert timestamp is missing")
+	ErrStaleTimestamp   = errors.New("alert timestamp is too old")
+	ErrFutureTimestamp  = errors.New("alert timestamp is in the future")
 )
 
 // VerifyHMAC checks the request body against the provided HMAC signature.
-// When the secret is empty, the check is skipped.
-func VerifyHMAC(secret, body []byte, headerSig string) error {
+// When timestamp is set, the signature covers timestamp + "." + body so
+// that the timestamp cannot be altered. When the secret is empty, the
+// check is skipped.
+func VerifyHMAC(secret []byte, timestamp string, body []byte, headerSig string) error {
 	if len(secret) == 0 {
 		return nil
 	}
 	mac := hmac.New(sha256.New, secret)
+	if timestamp != "" {
+		mac.Write([]byte(timestamp + "."))
+	}
 	mac.Write(body)
 	expected := hex.EncodeToString(mac.Sum(nil))
 	if !hmac.Equal([]byte(headerSig), []byte(expected)) {
@@ -21,3 +36,51 @@ func VerifyHMAC(secret, body []byte, headerSig string) error {
 	}
 	return nil
 }
+
+// UnixTimestamp converts a Unix timestamp in milliseconds to a time.
+// Values too small to be milliseconds since 2001 are taken as seconds.
+func UnixTimestamp(v int64) time.Time {
+	if v < 1e12 {
+		return time.Unix(v, 0)
+	}
+	return time.UnixMilli(v)
+}
+
+// ParseTimestamp parses a Unix timestamp header as UnixTimestamp does.
+func ParseTimestamp(v string) (time.Time, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+	}
+	return UnixTimestamp(n), nil
+}
+
+// CheckFreshness rejects a zero ts, a ts older than maxAge and a ts more
+// than maxSkew ahead of now.
+func CheckFreshness(ts, now time.Time, maxAge, maxSkew time.Duration) error {
+	if ts.IsZero() {
+		return ErrMissingTimestamp
+	}
+	if age := now.Sub(ts); age > maxAge {
+		return fmt.Errorf("%w: %s old", ErrStaleTimestamp, age.Round(time.Millisecond))
+	}
+	if ahead := ts.Sub(now); ahead > maxSkew {
+		return fmt.Errorf("%w: %s ahead", ErrFutureTimestamp, ahead.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// VerifyPassphrase checks a shared secret sent in the request body. Both
+// values are hashed first so that the comparison takes constant time
+// regardless of their lengths. An empty expected passphrase never matches.
+func VerifyPassphrase(expected, provided string) error {
+	if expected == "" {
+		return errors.New("no passphrase configured")
+	}
+	e := sha256.Sum256([]byte(expected))
+	p := sha256.Sum256([]byte(provided))
+	if !hmac.Equal(e[:], p[:]) {
+		return errors.New("invalid passphrase")
+	}
+	return nil
+}
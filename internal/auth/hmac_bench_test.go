@@ -17,7 +17,7 @@ func BenchmarkVerifyHMAC(b *testing.B) {
 	sig = hex.EncodeToString(h.Sum(nil))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if err := VerifyHMAC(secret, body, sig); err != nil {
+		if err := VerifyHMAC(secret, "", body, sig); err != nil {
 			b.Fatal(err)
 		}
 	}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyHMACWithTimestamp(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"bot":"b"}`)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("1700000000000." + string(body)))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	if err := VerifyHMAC(secret, "1700000000000", body, sig); err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	if err := VerifyHMAC(secret, "1700000000001", body, sig); err == nil {
+		t.Fatal("expected altered timestamp to fail")
+	}
+	if err := VerifyHMAC(secret, "", body, sig); err == nil {
+		t.Fatal("expected body-only verification to fail")
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	ms, err := ParseTimestamp("1700000000123")
+	if err != nil || !ms.Equal(time.UnixMilli(1700000000123)) {
+		t.Fatalf("expected milliseconds, got %v %v", ms, err)
+	}
+	sec, err := ParseTimestamp("1700000000")
+	if err != nil || !sec.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("expected seconds, got %v %v", sec, err)
+	}
+	if _, err := ParseTimestamp("yesterday"); err == nil {
+		t.Fatal("expected error for invalid timestamp")
+	}
+}
+
+func TestCheckFreshness(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		ts   time.Time
+		want error
+	}{
+		{"fresh", now.Add(-30 * time.Second), nil},
+		{"small skew", now.Add(2 * time.Second), nil},
+		{"missing", time.Time{}, ErrMissingTimestamp},
+		{"stale", now.Add(-2 * time.Minute), ErrStaleTimestamp},
+		{"future", now.Add(time.Minute), ErrFutureTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckFreshness(tt.ts, now, time.Minute, 5*time.Second)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyPassphrase(t *testing.T) {
+	if err := VerifyPassphrase("open sesame", "open sesame"); err != nil {
+		t.Fatalf("expected match, got %v", err)
+	}
+	if err := VerifyPassphrase("open sesame", "open"); err == nil {
+		t.Fatal("expected mismatch")
+	}
+	if err := VerifyPassphrase("", ""); err == nil {
+		t.Fatal("expected error without a configured passphrase")
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+// TradingViewPreset is the allow-list entry that expands to
+// TradingViewIPs.
+const TradingViewPreset = "tradingview"
+
+// TradingViewIPs are the published source addresses of TradingView
+// webhook alerts.
+var TradingViewIPs = []string{
+	"52.89.214.238",
+	"34.212.75.30",
+	"54.218.53.128",
+	"52.32.178.7",
+}
+
+// ParseCIDRs parses a list of CIDRs, bare IP addresses and the
+// "tradingview" preset.
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		switch {
+		case e == "":
+			continue
+		case strings.EqualFold(e, TradingViewPreset):
+			preset, err := ParseCIDRs(TradingViewIPs)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, preset...)
+			continue
+		case !strings.Contains(e, "/"):
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", e)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			e = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", e)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ContainsIP reports whether ip lies in any of nets.
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the client address resolved by IPFilter, falling back
+// to the request's remote address when the request did not pass through
+// one.
+func ClientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey{}).(net.IP); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// IPFilter resolves the client address of requests and rejects those from
+// outside an allow-list.
+type IPFilter struct {
+	logger  *zap.Logger
+	allow   []*net.IPNet // empty allows every source
+	trusted []*net.IPNet
+	reject  http.Handler // writes the response to rejected requests
+}
+
+// NewIPFilter creates an IPFilter. allow lists the accepted sources and
+// may be empty to accept every source. trustedProxies lists the reverse
+// proxies whose X-Forwarded-For header is believed.
+func NewIPFilter(allow, trustedProxies []*net.IPNet) *IPFilter {
+	return &IPFilter{
+		logger:  zap.NewNop(),
+		allow:   allow,
+		trusted: trustedProxies,
+		reject: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}),
+	}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (f *IPFilter) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		f.logger = logger
+	}
+}
+
+// SetRejectHandler sets the handler that writes the 403 response to
+// rejected requests, by default a plain-text "Forbidden".
+func (f *IPFilter) SetRejectHandler(h http.Handler) {
+	if h != nil {
+		f.reject = h
+	}
+}
+
+// ClientIP returns the address of the client that sent r. When r comes
+// from a trusted proxy, X-Forwarded-For is walked from the right and the
+// first address that is not a trusted proxy is the client.
+func (f *IPFilter) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !ContainsIP(f.trusted, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !ContainsIP(f.trusted, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// Middleware rejects requests from sources outside the allow-list through
+// the reject handler and passes the resolved client address on to next,
+// where ClientIP returns it.
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := f.ClientIP(r)
+		if len(f.allow) > 0 && (ip == nil || !ContainsIP(f.allow, ip)) {
+			metrics.SourceRejectedTotal.WithLabelValues("global", "").Inc()
+			f.logger.Warn("rejected request source",
+				zap.String("client_ip", ip.String()),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("path", r.URL.Path))
+			f.reject.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
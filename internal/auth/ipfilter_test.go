@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustCIDRs(t *testing.T, entries ...string) []*net.IPNet {
+	t.Helper()
+	nets, err := ParseCIDRs(entries)
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+	return nets
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets := mustCIDRs(t, "10.0.0.0/8", " 192.168.1.5 ", "", "TradingView", "::1")
+	if len(nets) != 3+len(TradingViewIPs) {
+		t.Fatalf("expected %d networks, got %d", 3+len(TradingViewIPs), len(nets))
+	}
+	if !ContainsIP(nets, net.ParseIP("52.89.214.238")) || !ContainsIP(nets, net.ParseIP("10.1.2.3")) {
+		t.Fatal("expected preset and CIDR addresses to match")
+	}
+	if ContainsIP(nets, net.ParseIP("192.168.1.6")) {
+		t.Fatal("expected bare IP to match only itself")
+	}
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := ParseCIDRs([]string{bad}); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestIPFilterClientIP(t *testing.T) {
+	f := NewIPFilter(nil, mustCIDRs(t, "172.16.0.0/12"))
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct", "52.89.214.238:1234", "", "52.89.214.238"},
+		{"untrusted forwarder ignored", "198.51.100.1:1234", "52.89.214.238", "198.51.100.1"},
+		{"trusted proxy", "172.18.0.2:1234", "52.89.214.238", "52.89.214.238"},
+		{"spoofed left entry ignored", "172.18.0.2:1234", "52.89.214.238, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "172.18.0.2:1234", "52.89.214.238, 172.18.0.3", "52.89.214.238"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/hook", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := f.ClientIP(r); got.String() != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	var seen net.IP
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientIP(r)
+	})
+	f := NewIPFilter(mustCIDRs(t, "tradingview"), mustCIDRs(t, "127.0.0.1"))
+	h := f.Middleware(next)
+
+	r := httptest.NewRequest(http.MethodPost, "/hook", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "34.212.75.30")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK || seen.String() != "34.212.75.30" {
+		t.Fatalf("expected TradingView source to pass, got %d %v", rr.Code, seen)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/hook", nil)
+	r.RemoteAddr = "203.0.113.9:5000"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+}
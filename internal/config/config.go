@@ -0,0 +1,342 @@
+// Package config loads the optional JSON configuration file that describes
+// broker accounts and per-bot settings. Settings that apply to the whole
+// process stay in environment variables.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/ratelimit"
+)
+
+// Default Alpaca API endpoints for paper and live accounts.
+const (
+	PaperBaseURL = "https://paper-api.alpaca.markets"
+	LiveBaseURL  = "https://api.alpaca.markets"
+)
+
+// Routing errors returned by AccountFor.
+var (
+	ErrUnknownBot        = errors.New("bot has no account route")
+	ErrAccountNotAllowed = errors.New("account not allowed")
+)
+
+// Config is the contents of the configuration file.
+type Config struct {
+	// DefaultAccount receives alerts from bots without an entry in Bots.
+	// When empty, such alerts are rejected.
+	DefaultAccount string             `json:"default_account,omitempty"`
+	Accounts       map[string]Account `json:"accounts"`
+	Bots           map[string]Bot     `json:"bots,omitempty"`
+	// Limits caps order and position sizes for every bot, overriding the
+	// MAX_* environment variables.
+	Limits *Limits `json:"limits,omitempty"`
+}
+
+// Account holds the credentials of one named Alpaca account. Key and
+// Secret may reference environment variables as $VAR or ${VAR} so that
+// credentials need not be stored in the file.
+type Account struct {
+	Key     string `json:"key"`
+	Secret  string `json:"secret"`
+	BaseURL string `json:"base_url,omitempty"`
+	Live    bool   `json:"live"`
+}
+
+// Bot holds the routing settings of one bot.
+type Bot struct {
+	// Account is the account the bot trades by default. It may be omitted
+	// when Fanout is set.
+	Account string `json:"account,omitempty"`
+	// AllowedAccounts lists further accounts an alert may select with its
+	// account field.
+	AllowedAccounts []string `json:"allowed_accounts,omitempty"`
+	// Secrets are the HMAC keys accepted in the bot's X-TV-Signature
+	// header and Passphrases the passphrases accepted in its body. Any
+	// listed key is valid, so a new key can be added before the old one is
+	// removed. When either is set the bot no longer accepts the global
+	// TV_SECRET and TV_PASSPHRASE. Keys may reference environment variables
+	// like account credentials.
+	Secrets     []string `json:"secrets,omitempty"`
+	Passphrases []string `json:"passphrases,omitempty"`
+	// Passphrase is shorthand for a single entry in Passphrases.
+	Passphrase string `json:"passphrase,omitempty"`
+	// AllowedIPs restricts the bot to alerts from these CIDRs, IP addresses
+	// or the "tradingview" preset.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+	// RateLimit overrides RATE_LIMIT_BOT for the bot, e.g. "30/m".
+	RateLimit string `json:"rate_limit,omitempty"`
+	// Fanout copies every order alert from the bot into each listed
+	// account, unless the alert selects a single account.
+	Fanout []FanoutTarget `json:"fanout,omitempty"`
+	// DryRun validates and risk-checks the bot's alerts and returns the
+	// orders they would place without trading.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Limits caps the bot's order and position sizes, overriding the global
+	// limits.
+	Limits *Limits `json:"limits,omitempty"`
+}
+
+// Limits caps order and position sizes. Unset caps fall back to the next
+// less specific level. Symbols overrides the caps per symbol; max_exposure
+// and max_daily_loss apply to a whole account or bot and cannot be set per
+// symbol.
+type Limits struct {
+	MaxQty      *decimal.Decimal `json:"max_qty,omitempty"`
+	MaxNotional *decimal.Decimal `json:"max_notional,omitempty"`
+	MaxPosition *decimal.Decimal `json:"max_position,omitempty"`
+	// MaxExposure caps gross market value in dollars: the account's in the
+	// global limits and that of the bot's own positions in a bot's limits.
+	MaxExposure *decimal.Decimal `json:"max_exposure,omitempty"`
+	// MaxDailyLoss is the loss in dollars after which new entries are
+	// refused for the rest of the day: the account's loss in the global
+	// limits and the bot's own loss in a bot's limits.
+	MaxDailyLoss *decimal.Decimal  `json:"max_daily_loss,omitempty"`
+	Symbols      map[string]Limits `json:"symbols,omitempty"`
+}
+
+// FanoutTarget is one account an alert is copied into. At most one of
+// Multiplier, Qty and Notional may be set; by default the alert's size is
+// used unchanged.
+type FanoutTarget struct {
+	Account string `json:"account"`
+	// Multiplier scales the alert's qty or notional.
+	Multiplier *decimal.Decimal `json:"multiplier,omitempty"`
+	// Qty replaces the alert's size with a fixed quantity.
+	Qty *decimal.Decimal `json:"qty,omitempty"`
+	// Notional replaces the alert's size with a fixed dollar amount.
+	Notional *decimal.Decimal `json:"notional,omitempty"`
+}
+
+// Load reads and validates the configuration file at path. When simulated
+// is set, as by BROKER=sim, the accounts trade in the simulator and need
+// no key or secret.
+func Load(path string, simulated bool) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err := cfg.normalize(simulated); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// normalize expands credentials, fills in default base URLs and checks
+// that every account a bot refers to exists. Credentials are only required
+// unless simulated.
+func (c *Config) normalize(simulated bool) error {
+	if len(c.Accounts) == 0 {
+		return errors.New("no accounts configured")
+	}
+	for name, acct := range c.Accounts {
+		acct.Key = os.ExpandEnv(acct.Key)
+		acct.Secret = os.ExpandEnv(acct.Secret)
+		if !simulated && (acct.Key == "" || acct.Secret == "") {
+			return fmt.Errorf("account %q: key and secret are required", name)
+		}
+		if acct.BaseURL == "" {
+			acct.BaseURL = PaperBaseURL
+			if acct.Live {
+				acct.BaseURL = LiveBaseURL
+			}
+		}
+		if err := checkBaseURL(acct); err != nil {
+			return fmt.Errorf("account %q: %w", name, err)
+		}
+		c.Accounts[name] = acct
+	}
+
+	if c.DefaultAccount != "" {
+		if _, ok := c.Accounts[c.DefaultAccount]; !ok {
+			return fmt.Errorf("default_account %q is not defined", c.DefaultAccount)
+		}
+	}
+	if err := c.Limits.check(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	for name, bot := range c.Bots {
+		bot.Passphrase = os.ExpandEnv(bot.Passphrase)
+		if bot.Passphrase != "" {
+			bot.Passphrases = append([]string{bot.Passphrase}, bot.Passphrases...)
+		}
+		if err := expandKeys(bot.Secrets); err != nil {
+			return fmt.Errorf("bot %q: secrets: %w", name, err)
+		}
+		if err := expandKeys(bot.Passphrases); err != nil {
+			return fmt.Errorf("bot %q: passphrases: %w", name, err)
+		}
+		if _, err := auth.ParseCIDRs(bot.AllowedIPs); err != nil {
+			return fmt.Errorf("bot %q: allowed_ips: %w", name, err)
+		}
+		if bot.RateLimit != "" {
+			if _, err := ratelimit.ParseLimit(bot.RateLimit); err != nil {
+				return fmt.Errorf("bot %q: %w", name, err)
+			}
+		}
+		c.Bots[name] = bot
+		if bot.Account == "" && len(bot.Fanout) == 0 {
+			return fmt.Errorf("bot %q: account or fanout is required", name)
+		}
+		if _, ok := c.Accounts[bot.Account]; bot.Account != "" && !ok {
+			return fmt.Errorf("bot %q: account %q is not defined", name, bot.Account)
+		}
+		for _, a := range bot.AllowedAccounts {
+			if _, ok := c.Accounts[a]; !ok {
+				return fmt.Errorf("bot %q: allowed account %q is not defined", name, a)
+			}
+		}
+		if err := c.checkFanout(bot.Fanout); err != nil {
+			return fmt.Errorf("bot %q: %w", name, err)
+		}
+		if err := bot.Limits.check(); err != nil {
+			return fmt.Errorf("bot %q: limits: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// check rejects caps that are not positive and per-symbol settings that
+// cannot be applied per symbol.
+func (l *Limits) check() error {
+	if l == nil {
+		return nil
+	}
+	for field, v := range map[string]*decimal.Decimal{
+		"max_qty":        l.MaxQty,
+		"max_notional":   l.MaxNotional,
+		"max_position":   l.MaxPosition,
+		"max_exposure":   l.MaxExposure,
+		"max_daily_loss": l.MaxDailyLoss,
+	} {
+		if v != nil && !v.IsPositive() {
+			return fmt.Errorf("%s must be positive", field)
+		}
+	}
+	for symbol, sl := range l.Symbols {
+		if sl.MaxExposure != nil || sl.MaxDailyLoss != nil || len(sl.Symbols) > 0 {
+			return fmt.Errorf("symbol %q: only max_qty, max_notional and max_position may be set per symbol", symbol)
+		}
+		if err := sl.check(); err != nil {
+			return fmt.Errorf("symbol %q: %w", symbol, err)
+		}
+	}
+	return nil
+}
+
+// HasCredentials reports whether any bot has its own secrets or
+// passphrases. Alerts from bots missing from Bots are then rejected.
+func (c *Config) HasCredentials() bool {
+	for _, bot := range c.Bots {
+		if len(bot.Secrets) > 0 || len(bot.Passphrases) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// expandKeys expands environment variables in keys in place and rejects
+// keys that end up empty.
+func expandKeys(keys []string) error {
+	for i, k := range keys {
+		keys[i] = os.ExpandEnv(k)
+		if keys[i] == "" {
+			return errors.New("empty key")
+		}
+	}
+	return nil
+}
+
+// checkFanout validates the fan-out targets of one bot.
+func (c *Config) checkFanout(targets []FanoutTarget) error {
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if _, ok := c.Accounts[t.Account]; !ok {
+			return fmt.Errorf("fanout account %q is not defined", t.Account)
+		}
+		if seen[t.Account] {
+			return fmt.Errorf("fanout account %q is listed twice", t.Account)
+		}
+		seen[t.Account] = true
+
+		set := 0
+		for _, v := range []*decimal.Decimal{t.Multiplier, t.Qty, t.Notional} {
+			if v == nil {
+				continue
+			}
+			set++
+			if !v.IsPositive() {
+				return fmt.Errorf("fanout account %q: sizing must be positive", t.Account)
+			}
+		}
+		if set > 1 {
+			return fmt.Errorf("fanout account %q: set at most one of multiplier, qty and notional", t.Account)
+		}
+	}
+	return nil
+}
+
+// checkBaseURL guards against trading real money by accident: a paper
+// account may not point at the live endpoint and vice versa.
+func checkBaseURL(acct Account) error {
+	u, err := url.Parse(acct.BaseURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid base_url %q", acct.BaseURL)
+	}
+	paper := strings.HasPrefix(u.Host, "paper-")
+	if acct.Live && paper {
+		return fmt.Errorf("live account uses paper base_url %q", acct.BaseURL)
+	}
+	if !acct.Live && u.Host == "api.alpaca.markets" {
+		return fmt.Errorf("base_url %q is live but the account is not marked live", acct.BaseURL)
+	}
+	return nil
+}
+
+// AccountFor returns the account an alert from bot should trade. requested
+// is the alert's account field; when set it must be the bot's default
+// account, one of its allowed accounts or one of its fan-out accounts.
+func (c *Config) AccountFor(bot, requested string) (string, error) {
+	b, known := c.Bots[bot]
+	if !known {
+		if c.DefaultAccount == "" {
+			return "", fmt.Errorf("%w: %q", ErrUnknownBot, bot)
+		}
+		if requested != "" && requested != c.DefaultAccount {
+			return "", fmt.Errorf("%w: bot %q may not use account %q", ErrAccountNotAllowed, bot, requested)
+		}
+		return c.DefaultAccount, nil
+	}
+	if requested == "" {
+		if b.Account == "" {
+			return "", fmt.Errorf("%w: bot %q fans out and has no default account", ErrAccountNotAllowed, bot)
+		}
+		return b.Account, nil
+	}
+	if requested == b.Account {
+		return b.Account, nil
+	}
+	for _, a := range b.AllowedAccounts {
+		if a == requested {
+			return a, nil
+		}
+	}
+	for _, t := range b.Fanout {
+		if t.Account == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("%w: bot %q may not use account %q", ErrAccountNotAllowed, bot, requested)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("LIVE_KEY", "lk")
+	path := writeConfig(t, `{
+		"accounts": {
+			"paper": {"key": "pk", "secret": "ps"},
+			"live": {"key": "${LIVE_KEY}", "secret": "ls", "live": true}
+		},
+		"bots": {"trend": {"account": "paper", "allowed_accounts": ["live"], "passphrase": "$LIVE_KEY-pass",
+			"passphrases": ["old-pass"], "secrets": ["new", "${LIVE_KEY}"]}}
+	}`)
+
+	cfg, err := Load(path, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := cfg.Accounts["paper"].BaseURL; got != PaperBaseURL {
+		t.Fatalf("expected paper base url, got %s", got)
+	}
+	live := cfg.Accounts["live"]
+	if live.BaseURL != LiveBaseURL || live.Key != "lk" {
+		t.Fatalf("unexpected live account %+v", live)
+	}
+	trend := cfg.Bots["trend"]
+	if len(trend.Passphrases) != 2 || trend.Passphrases[0] != "lk-pass" || trend.Passphrases[1] != "old-pass" {
+		t.Fatalf("expected expanded passphrases, got %q", trend.Passphrases)
+	}
+	if len(trend.Secrets) != 2 || trend.Secrets[1] != "lk" {
+		t.Fatalf("expected expanded secrets, got %q", trend.Secrets)
+	}
+	if !cfg.HasCredentials() {
+		t.Fatal("expected HasCredentials")
+	}
+}
+
+func TestLoadSimulated(t *testing.T) {
+	path := writeConfig(t, `{
+		"accounts": {"paper": {}, "live": {"key": "$UNSET_ALERTBRIDGE_KEY", "live": true}},
+		"bots": {"trend": {"account": "paper"}}
+	}`)
+
+	if _, err := Load(path, false); err == nil {
+		t.Fatal("expected accounts without credentials to be rejected")
+	}
+	cfg, err := Load(path, true)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := cfg.Accounts["live"].BaseURL; got != LiveBaseURL {
+		t.Fatalf("expected live base url, got %s", got)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no accounts":      `{"accounts": {}}`,
+		"missing secret":   `{"accounts": {"a": {"key": "k"}}}`,
+		"unknown account":  `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "x"}}}`,
+		"unknown allowed":  `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "a", "allowed_accounts": ["x"]}}}`,
+		"unknown default":  `{"default_account": "x", "accounts": {"a": {"key": "k", "secret": "s"}}}`,
+		"paper on live":    `{"accounts": {"a": {"key": "k", "secret": "s", "base_url": "https://api.alpaca.markets"}}}`,
+		"live on paper":    `{"accounts": {"a": {"key": "k", "secret": "s", "live": true, "base_url": "https://paper-api.alpaca.markets"}}}`,
+		"malformed config": `{"accounts": `,
+		"no route":         `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {}}}`,
+		"unknown fanout":   `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"fanout": [{"account": "x"}]}}}`,
+		"duplicate fanout": `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"fanout": [{"account": "a"}, {"account": "a"}]}}}`,
+		"two sizings":      `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"fanout": [{"account": "a", "qty": 1, "multiplier": 2}]}}}`,
+		"empty secret":     `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "a", "secrets": ["$UNSET_ALERTBRIDGE_KEY"]}}}`,
+		"invalid ip":       `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "a", "allowed_ips": ["10.0.0.300"]}}}`,
+		"invalid rate":     `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "a", "rate_limit": "fast"}}}`,
+		"zero multiplier":  `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"fanout": [{"account": "a", "multiplier": "0"}]}}}`,
+		"negative limit":   `{"accounts": {"a": {"key": "k", "secret": "s"}}, "limits": {"max_qty": -1}}`,
+		"zero bot limit":   `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "a", "limits": {"symbols": {"AAPL": {"max_notional": 0}}}}}}`,
+		"symbol exposure":  `{"accounts": {"a": {"key": "k", "secret": "s"}}, "bots": {"b": {"account": "a", "limits": {"symbols": {"AAPL": {"max_exposure": 1000}}}}}}`,
+		"symbol loss":      `{"accounts": {"a": {"key": "k", "secret": "s"}}, "limits": {"symbols": {"AAPL": {"max_daily_loss": 100}}}}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(writeConfig(t, body), false); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadFanout(t *testing.T) {
+	path := writeConfig(t, `{
+		"accounts": {"small": {"key": "k", "secret": "s"}, "large": {"key": "k", "secret": "s"}},
+		"bots": {"copy": {"fanout": [{"account": "small", "multiplier": 0.5}, {"account": "large", "notional": "1000"}]}}
+	}`)
+
+	cfg, err := Load(path, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	fanout := cfg.Bots["copy"].Fanout
+	if len(fanout) != 2 || fanout[0].Multiplier.String() != "0.5" || fanout[1].Notional.String() != "1000" {
+		t.Fatalf("unexpected fanout %+v", fanout)
+	}
+	if _, err := cfg.AccountFor("copy", ""); !errors.Is(err, ErrAccountNotAllowed) {
+		t.Fatalf("expected ErrAccountNotAllowed without default account, got %v", err)
+	}
+	if got, err := cfg.AccountFor("copy", "large"); err != nil || got != "large" {
+		t.Fatalf("expected fanout account large, got %q %v", got, err)
+	}
+}
+
+func TestLoadLimits(t *testing.T) {
+	path := writeConfig(t, `{
+		"accounts": {"a": {"key": "k", "secret": "s"}},
+		"limits": {"max_notional": 10000, "symbols": {"TSLA": {"max_qty": 5}}},
+		"bots": {"b": {"account": "a", "limits": {"max_exposure": "50000", "max_daily_loss": 500, "symbols": {"AAPL": {"max_position": 100}}}}}
+	}`)
+
+	cfg, err := Load(path, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Limits == nil || cfg.Limits.MaxNotional.String() != "10000" || cfg.Limits.Symbols["TSLA"].MaxQty.String() != "5" {
+		t.Fatalf("unexpected global limits %+v", cfg.Limits)
+	}
+	bot := cfg.Bots["b"].Limits
+	if bot == nil || bot.MaxExposure.String() != "50000" || bot.MaxDailyLoss.String() != "500" || bot.Symbols["AAPL"].MaxPosition.String() != "100" {
+		t.Fatalf("unexpected bot limits %+v", bot)
+	}
+}
+
+func TestAccountFor(t *testing.T) {
+	cfg := &Config{
+		Accounts: map[string]Account{"a": {}, "b": {}, "c": {}},
+		Bots:     map[string]Bot{"trend": {Account: "a", AllowedAccounts: []string{"b"}}},
+	}
+
+	if got, err := cfg.AccountFor("trend", ""); err != nil || got != "a" {
+		t.Fatalf("expected default account a, got %q %v", got, err)
+	}
+	if got, err := cfg.AccountFor("trend", "b"); err != nil || got != "b" {
+		t.Fatalf("expected allowed account b, got %q %v", got, err)
+	}
+	if _, err := cfg.AccountFor("trend", "c"); !errors.Is(err, ErrAccountNotAllowed) {
+		t.Fatalf("expected ErrAccountNotAllowed, got %v", err)
+	}
+	if _, err := cfg.AccountFor("other", ""); !errors.Is(err, ErrUnknownBot) {
+		t.Fatalf("expected ErrUnknownBot, got %v", err)
+	}
+
+	cfg.DefaultAccount = "c"
+	if got, err := cfg.AccountFor("other", ""); err != nil || got != "c" {
+		t.Fatalf("expected fallback account c, got %q %v", got, err)
+	}
+	if _, err := cfg.AccountFor("other", "a"); !errors.Is(err, ErrAccountNotAllowed) {
+		t.Fatalf("expected ErrAccountNotAllowed, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package dedup remembers processed webhook alerts for a limited time so
+// that a retried alert returns the original response instead of placing a
+// second order.
+package dedup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrInFlight is returned by Claim while another request is processing
+// the same key.
+var ErrInFlight = errors.New("alert is already being processed")
+
+// Response is the stored outcome of a processed alert.
+type Response struct {
+	Status int    `json:"status"`
+	Body   []byte `json:"body"`
+}
+
+// Store records which alerts have been processed.
+type Store interface {
+	// Claim reserves key for processing. It returns the stored response
+	// when key has already been completed, and ErrInFlight while another
+	// request holds it. A nil response and nil error mean the caller now
+	// owns key and must Complete or Release it.
+	Claim(key string) (*Response, error)
+	// Complete stores the response for a claimed key.
+	Complete(key string, resp Response) error
+	// Release forgets a claimed key so the alert can be retried.
+	Release(key string)
+}
+
+type entry struct {
+	Response *Response `json:"response"` // nil while in flight
+	Expires  time.Time `json:"expires"`
+}
+
+// MemoryStore is a Store that keeps entries in memory for ttl.
+type MemoryStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]entry
+	now     func() time.Time
+}
+
+// NewMemoryStore creates a MemoryStore that forgets entries after ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+// Claim implements Store.
+func (s *MemoryStore) Claim(key string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	if e, ok := s.entries[key]; ok {
+		if e.Response == nil {
+			return nil, ErrInFlight
+		}
+		return e.Response, nil
+	}
+	s.entries[key] = entry{Expires: s.now().Add(s.ttl)}
+	return nil, nil
+}
+
+// Complete implements Store.
+func (s *MemoryStore) Complete(key string, resp Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	s.entries[key] = entry{Response: &resp, Expires: s.now().Add(s.ttl)}
+	return nil
+}
+
+// Lookup returns the response stored for key, without claiming it. Keys
+// in flight are not found.
+func (s *MemoryStore) Lookup(key string) (*Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.Response == nil || s.now().After(e.Expires) {
+		return nil, false
+	}
+	return e.Response, true
+}
+
+// Release implements Store.
+func (s *MemoryStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// expire drops entries past their expiry. The caller holds s.mu.
+func (s *MemoryStore) expire() {
+	now := s.now()
+	for key, e := range s.entries {
+		if now.After(e.Expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// FileStore is a MemoryStore whose completed entries are persisted to a
+// JSON file, so that replays are still recognised after a restart.
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+// NewFileStore creates a FileStore backed by path, loading any entries
+// that have not yet expired.
+func NewFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(ttl), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dedup file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("parse dedup file %s: %w", path, err)
+	}
+	for key, e := range s.entries {
+		if e.Response == nil {
+			delete(s.entries, key)
+		}
+	}
+	s.expire()
+	return s, nil
+}
+
+// Complete implements Store and writes the completed entries to disk.
+func (s *FileStore) Complete(key string, resp Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry{Response: &resp, Expires: s.now().Add(s.ttl)}
+	s.expire()
+	return s.save()
+}
+
+// save atomically writes the completed entries. The caller holds s.mu.
+func (s *FileStore) save() error {
+	completed := make(map[string]entry, len(s.entries))
+	for key, e := range s.entries {
+		if e.Response != nil {
+			completed[key] = e
+		}
+	}
+	data, err := json.Marshal(completed)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write dedup file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write dedup file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write dedup file: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
@@ -0,0 +1,95 @@
+package dedup
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreClaimCompleteRelease(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+
+	if resp, err := s.Claim("a"); resp != nil || err != nil {
+		t.Fatalf("expected first claim to succeed, got %v %v", resp, err)
+	}
+	if _, err := s.Claim("a"); !errors.Is(err, ErrInFlight) {
+		t.Fatalf("expected ErrInFlight, got %v", err)
+	}
+
+	if err := s.Complete("a", Response{Status: 200, Body: []byte(`{"id":"1"}`)}); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	resp, err := s.Claim("a")
+	if err != nil || resp == nil || resp.Status != 200 || string(resp.Body) != `{"id":"1"}` {
+		t.Fatalf("expected stored response, got %v %v", resp, err)
+	}
+
+	s.Claim("b")
+	s.Release("b")
+	if resp, err := s.Claim("b"); resp != nil || err != nil {
+		t.Fatalf("expected released key to be claimable, got %v %v", resp, err)
+	}
+}
+
+func TestMemoryStoreLookup(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore(time.Minute)
+	s.now = func() time.Time { return now }
+
+	s.Claim("a")
+	if _, ok := s.Lookup("a"); ok {
+		t.Fatal("expected a key in flight not to be found")
+	}
+	s.Complete("a", Response{Status: 200, Body: []byte(`{}`)})
+	if resp, ok := s.Lookup("a"); !ok || resp.Status != 200 {
+		t.Fatalf("expected stored response, got %v %v", resp, ok)
+	}
+	if _, err := s.Claim("a"); err != nil {
+		t.Fatalf("expected Lookup not to claim the key, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := s.Lookup("a"); ok {
+		t.Fatal("expected expired response not to be found")
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore(time.Minute)
+	s.now = func() time.Time { return now }
+
+	s.Claim("a")
+	s.Complete("a", Response{Status: 200})
+
+	now = now.Add(2 * time.Minute)
+	if resp, err := s.Claim("a"); resp != nil || err != nil {
+		t.Fatalf("expected expired key to be claimable, got %v %v", resp, err)
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	s.Claim("done")
+	if err := s.Complete("done", Response{Status: 200, Body: []byte("order")}); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	s.Claim("pending")
+
+	reopened, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	resp, err := reopened.Claim("done")
+	if err != nil || resp == nil || string(resp.Body) != "order" {
+		t.Fatalf("expected persisted response, got %v %v", resp, err)
+	}
+	if resp, err := reopened.Claim("pending"); resp != nil || err != nil {
+		t.Fatalf("expected in-flight key to be dropped on restart, got %v %v", resp, err)
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/config"
+)
+
+// SetAccounts enables multi-account routing: each alert is sent to the
+// broker of the account cfg routes its bot to, instead of the broker
+// passed to NewHookHandler. accounts maps account names to brokers.
+func (h *HookHandler) SetAccounts(cfg *config.Config, accounts map[string]adapter.Broker) {
+	h.routing = cfg
+	h.accounts = accounts
+}
+
+// brokerFor returns the name and broker of the account alert is routed to,
+// wrapped by tradingBroker, and writes a 403 response when the bot has no route or requests an
+// account it may not use. It reports whether processing may continue.
+func (h *HookHandler) brokerFor(w http.ResponseWriter, alert AlertRequest) (string, adapter.Broker, bool) {
+	if h.routing == nil {
+		if alert.Account != "" {
+			h.logger.Error("account routing is not configured",
+				zap.String("bot", alert.Bot),
+				zap.String("account", alert.Account))
+			writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, "Account routing is not configured")
+			return "", nil, false
+		}
+		return "", h.tradingBroker(alert, h.broker), true
+	}
+
+	name, err := h.routing.AccountFor(alert.Bot, alert.Account)
+	if err != nil {
+		h.logger.Error("account routing failed",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("account", alert.Account))
+		writeError(w, http.StatusForbidden, CodeAccountForbidden, alert.Bot, err.Error())
+		return "", nil, false
+	}
+	broker, ok := h.accounts[name]
+	if !ok {
+		h.logger.Error("no broker for account",
+			zap.String("bot", alert.Bot),
+			zap.String("account", name))
+		writeError(w, http.StatusInternalServerError, CodeAccountUnavailable, alert.Bot, "Account unavailable")
+		return "", nil, false
+	}
+	return name, h.tradingBroker(alert, broker), true
+}
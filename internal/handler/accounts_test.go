@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/config"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func newRoutedHandler() (*HookHandler, *fakeBroker, *fakeBroker) {
+	paper, live := &fakeBroker{}, &fakeBroker{}
+	cfg := &config.Config{
+		Accounts: map[string]config.Account{"paper": {}, "live": {Live: true}},
+		Bots: map[string]config.Bot{
+			"trend": {Account: "paper", AllowedAccounts: []string{"live"}},
+			"scalp": {Account: "live"},
+		},
+	}
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetAccounts(cfg, map[string]adapter.Broker{"paper": paper, "live": live})
+	return h, paper, live
+}
+
+func TestHandleRoutesBotToAccount(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		paper int
+		live  int
+	}{
+		{"bot default", `{"bot":"trend","symbol":"AAPL","side":"buy","qty":"1"}`, 1, 0},
+		{"other bot", `{"bot":"scalp","symbol":"AAPL","side":"buy","qty":"1"}`, 0, 1},
+		{"allowed override", `{"bot":"trend","symbol":"AAPL","side":"buy","qty":"1","account":"live"}`, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, paper, live := newRoutedHandler()
+			rr := httptest.NewRecorder()
+			h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader([]byte(tt.body))))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+			}
+			if len(paper.placed()) != tt.paper || len(live.placed()) != tt.live {
+				t.Fatalf("expected %d paper and %d live orders, got %d and %d",
+					tt.paper, tt.live, len(paper.placed()), len(live.placed()))
+			}
+		})
+	}
+}
+
+func TestHandleRejectsUnroutedAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"account not allowed", `{"bot":"scalp","symbol":"AAPL","side":"buy","qty":"1","account":"paper"}`},
+		{"unknown bot", `{"bot":"other","symbol":"AAPL","side":"buy","qty":"1"}`},
+		{"position alert", `{"bot":"scalp","symbol":"AAPL","position":"flat","account":"paper"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, paper, live := newRoutedHandler()
+			rr := httptest.NewRecorder()
+			h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader([]byte(tt.body))))
+			if rr.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", rr.Code, rr.Body)
+			}
+			if len(paper.placed())+len(live.placed()) != 0 {
+				t.Fatal("expected no orders")
+			}
+		})
+	}
+}
+
+func TestHandleAccountWithoutRouting(t *testing.T) {
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","account":"live"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	if len(broker.placed()) != 0 {
+		t.Fatal("expected no orders")
+	}
+}
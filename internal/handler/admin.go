@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// HaltRequest is the body of POST /admin/halt and POST /admin/resume. An
+// empty body applies to every bot.
+type HaltRequest struct {
+	Bots   []string `json:"bots,omitempty"`
+	Mode   string   `json:"mode,omitempty"` // halt (the default) or reduce_only
+	Reason string   `json:"reason,omitempty"`
+}
+
+// AdminHandler serves the operator endpoints under /admin/. Requests must
+// carry token as a bearer token.
+//
+//	GET  /admin/halt     the kill switch state
+//	POST /admin/halt     halt every bot or those listed
+//	POST /admin/resume   release every halt or those of the bots listed
+//	POST /admin/flatten  cancel open orders and close positions; without a
+//	                     bot it halts every bot, even for one account
+func (h *HookHandler) AdminHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRequestID(w, r)
+		if err := auth.VerifyBearer(r, token); err != nil {
+			h.logger.Warn("rejected admin request",
+				zap.Error(err),
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "", "Unauthorized")
+			return
+		}
+
+		switch strings.TrimPrefix(r.URL.Path, "/admin/") {
+		case "halt":
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(h.riskGuard.Halts())
+			case http.MethodPost:
+				h.halt(w, r)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "", "Method not allowed")
+			}
+		case "resume":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "", "Method not allowed")
+				return
+			}
+			h.resume(w, r)
+		case "flatten":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "", "Method not allowed")
+				return
+			}
+			h.flatten(w, r)
+		default:
+			writeError(w, http.StatusNotFound, CodeNotFound, "", "Not found")
+		}
+	})
+}
+
+// halt engages the kill switch and announces it on Slack.
+func (h *HookHandler) halt(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHaltRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Mode != "" && req.Mode != risk.ModeHalt && req.Mode != risk.ModeReduceOnly {
+		writeError(w, http.StatusBadRequest, CodeInvalidField, "", "mode must be halt or reduce_only")
+		return
+	}
+
+	halts, err := h.riskGuard.Halt(req.Bots, req.Mode, req.Reason)
+	h.logger.Warn("kill switch engaged via admin API",
+		zap.Strings("bots", req.Bots),
+		zap.String("mode", req.Mode),
+		zap.String("reason", req.Reason),
+		zap.String("remote_addr", r.RemoteAddr))
+
+	msg := "Kill switch: trading halted for " + botList(req.Bots)
+	if req.Mode == risk.ModeReduceOnly {
+		msg = "Kill switch: " + botList(req.Bots) + " set to reduce-only"
+	}
+	if req.Reason != "" {
+		msg += ": " + req.Reason
+	}
+	h.announceHalt(w, msg, halts, err)
+}
+
+// resume releases halts and announces it on Slack.
+func (h *HookHandler) resume(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHaltRequest(w, r)
+	if !ok {
+		return
+	}
+
+	halts, err := h.riskGuard.Resume(req.Bots)
+	h.logger.Warn("kill switch released via admin API",
+		zap.Strings("bots", req.Bots),
+		zap.String("remote_addr", r.RemoteAddr))
+
+	msg := "Kill switch: trading resumed for " + botList(req.Bots)
+	if len(req.Bots) > 0 && halts.All != nil {
+		msg += " (all bots remain halted)"
+	}
+	h.announceHalt(w, msg, halts, err)
+}
+
+// announceHalt posts msg to Slack and writes the new kill switch state. A
+// change that could not be saved is still in effect but is reported as an
+// error, since it will not survive a restart.
+func (h *HookHandler) announceHalt(w http.ResponseWriter, msg string, halts risk.Halts, err error) {
+	if err != nil {
+		h.logger.Error("failed to save kill switch state", zap.Error(err))
+		msg += " (not saved, will not survive a restart)"
+	}
+	if h.notifier != nil {
+		h.notifier.SendMessage(msg)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "", "Kill switch changed but not saved: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(halts)
+}
+
+// decodeAdminRequest decodes an optional JSON body into v. It writes a
+// 400 response for an invalid body and reports whether processing may
+// continue.
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "", "Invalid request body")
+		return false
+	}
+	return true
+}
+
+// decodeHaltRequest reads an optional HaltRequest body, writing a 400
+// response when it is invalid.
+func decodeHaltRequest(w http.ResponseWriter, r *http.Request) (HaltRequest, bool) {
+	var req HaltRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return HaltRequest{}, false
+	}
+	for _, bot := range req.Bots {
+		if strings.TrimSpace(bot) == "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidField, "", "bots must not contain empty names")
+			return HaltRequest{}, false
+		}
+	}
+	return req, true
+}
+
+// botList names bots for an announcement.
+func botList(bots []string) string {
+	if len(bots) == 0 {
+		return "all bots"
+	}
+	if len(bots) == 1 {
+		return "bot " + bots[0]
+	}
+	return "bots " + strings.Join(bots, ", ")
+}
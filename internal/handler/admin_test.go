@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/notify"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func adminRequest(h *HookHandler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	rr := httptest.NewRecorder()
+	h.AdminHandler("t0ken").ServeHTTP(rr, req)
+	return rr
+}
+
+// newSlackRecorder returns a Slack notifier whose messages are collected
+// by the returned function.
+func newSlackRecorder(t *testing.T) (*notify.SlackNotifier, func() []string) {
+	var mu sync.Mutex
+	var messages []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		messages = append(messages, string(b))
+		mu.Unlock()
+	}))
+	t.Cleanup(slack.Close)
+	return notify.NewSlackNotifier(slack.URL, "", ""), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), messages...)
+	}
+}
+
+func TestAdminHalt(t *testing.T) {
+	notifier, messages := newSlackRecorder(t)
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, notifier, false, false, true)
+
+	rr := adminRequest(h, http.MethodPost, "/admin/halt", `{"bots":["b"],"mode":"reduce_only","reason":"slippage"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var halts risk.Halts
+	if err := json.NewDecoder(rr.Body).Decode(&halts); err != nil || halts.Bots["b"].Mode != risk.ModeReduceOnly {
+		t.Fatalf("unexpected halts %+v %v", halts, err)
+	}
+	if m := messages(); len(m) != 1 || !strings.Contains(m[0], "bot b set to reduce-only: slippage") {
+		t.Fatalf("expected the halt to be announced, got %v", m)
+	}
+
+	rr = postAlert(h, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+	var resp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusForbidden || resp.Rule != risk.KillSwitchRule {
+		t.Fatalf("expected kill switch rejection, got %d %+v", rr.Code, resp)
+	}
+	if rr := postAlert(h, `{"bot":"other","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected other bot to trade, got %d: %s", rr.Code, rr.Body)
+	}
+
+	rr = adminRequest(h, http.MethodGet, "/admin/halt", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"reduce_only"`) {
+		t.Fatalf("expected halt state, got %d: %s", rr.Code, rr.Body)
+	}
+
+	// An empty body resumes every bot
+	if rr := adminRequest(h, http.MethodPost, "/admin/resume", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if m := messages(); len(m) != 2 || !strings.Contains(m[1], "trading resumed for all bots") {
+		t.Fatalf("expected the resume to be announced, got %v", m)
+	}
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected bot b to trade again, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestAdminHaltErrors(t *testing.T) {
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, g, nil, nil, true, true, true)
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"invalid mode", http.MethodPost, "/admin/halt", `{"mode":"pause"}`, http.StatusBadRequest},
+		{"invalid body", http.MethodPost, "/admin/halt", `{"bots":`, http.StatusBadRequest},
+		{"empty bot", http.MethodPost, "/admin/halt", `{"bots":[""]}`, http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/admin/resume", "", http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodGet, "/admin/nope", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := adminRequest(h, tt.method, tt.path, tt.body); rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+		})
+	}
+	if halts := g.Halts(); halts.All != nil || len(halts.Bots) != 0 {
+		t.Fatalf("expected no halts after invalid requests, got %+v", halts)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/halt", nil)
+	req.Header.Set("Authorization", "Bearer nope")
+	rr := httptest.NewRecorder()
+	h.AdminHandler("t0ken").ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || g.Halts().All != nil {
+		t.Fatalf("expected 401 without a halt, got %d", rr.Code)
+	}
+
+	// A halt that cannot be saved still applies
+	if err := g.SetHaltFile(filepath.Join(t.TempDir(), "missing", "halts.json")); err != nil {
+		t.Fatalf("SetHaltFile failed: %v", err)
+	}
+	if rr := adminRequest(h, http.MethodPost, "/admin/halt", ""); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", rr.Code, rr.Body)
+	}
+	if g.Halts().All == nil {
+		t.Fatalf("expected the halt to apply")
+	}
+}
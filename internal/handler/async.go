@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/queue"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+// AcceptedResponse is the 202 Accepted response to an alert queued in
+// async mode. ID tracks the alert until a worker has processed it.
+type AcceptedResponse struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	RequestID string `json:"request_id"`
+}
+
+// SetQueue enables async mode: valid alerts are persisted to q and
+// acknowledged with 202 Accepted, and RunWorkers places their orders.
+func (h *HookHandler) SetQueue(q *queue.Queue) {
+	h.queue = q
+	if q != nil {
+		metrics.QueueDepth.Set(float64(q.Len()))
+	}
+}
+
+// enqueue validates alert and persists it to the queue, then writes a 202
+// response carrying the job ID.
+func (h *HookHandler) enqueue(w http.ResponseWriter, alert AlertRequest) {
+	var ok bool
+	if alert.Position != "" {
+		_, ok = h.preparePosition(w, alert)
+	} else {
+		_, _, _, ok = h.prepareOrder(w, alert)
+	}
+	if !ok {
+		return
+	}
+
+	id := newID()
+	// A job that runs again after a restart must reuse its client order
+	// ID so that the broker rejects a second copy of the order
+	if alert.ID == "" {
+		alert.ID = id
+	}
+	payload, err := json.Marshal(alert)
+	if err == nil {
+		_, err = h.queue.Enqueue(queue.Job{
+			ID:        id,
+			Bot:       alert.Bot,
+			RequestID: w.Header().Get(RequestIDHeader),
+			Payload:   payload,
+			Keys:      outcomeKeys(alert),
+		})
+	}
+	if err != nil {
+		h.logger.Error("failed to queue alert",
+			zap.Error(err),
+			zap.String("bot", alert.Bot))
+		writeError(w, http.StatusInternalServerError, CodeInternal, alert.Bot, "Failed to queue alert")
+		return
+	}
+	metrics.QueueDepth.Set(float64(h.queue.Len()))
+
+	h.logger.Info("alert queued",
+		zap.String("bot", alert.Bot),
+		zap.String("symbol", alert.Symbol),
+		zap.String("job_id", id))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(AcceptedResponse{
+		ID:        id,
+		Status:    queue.StatusQueued,
+		RequestID: w.Header().Get(RequestIDHeader),
+	})
+}
+
+// RunWorkers processes queued alerts with n workers until ctx is done. It
+// returns once the alerts being processed have finished; alerts still
+// queued are processed after the next start.
+func (h *HookHandler) RunWorkers(ctx context.Context, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := h.queue.Next(ctx)
+				if err != nil {
+					return
+				}
+				metrics.QueueDepth.Set(float64(h.queue.Len()))
+				h.runJob(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runJob processes a queued alert and records the response it produced
+// as the job's outcome.
+func (h *HookHandler) runJob(job queue.Job) {
+	rec := &bufferWriter{header: make(http.Header)}
+	rec.Header().Set(RequestIDHeader, job.RequestID)
+
+	var alert AlertRequest
+	if err := json.Unmarshal(job.Payload, &alert); err != nil {
+		h.logger.Error("failed to decode queued alert",
+			zap.Error(err),
+			zap.String("job_id", job.ID))
+		writeError(rec, http.StatusInternalServerError, CodeInternal, job.Bot, "Invalid queued alert")
+	} else {
+		h.process(rec, alert)
+	}
+
+	status := rec.statusCode()
+	if err := h.queue.Finish(job.ID, status, rec.body.Bytes()); err != nil {
+		h.logger.Error("failed to record job outcome",
+			zap.Error(err),
+			zap.String("job_id", job.ID))
+	}
+	h.logger.Info("queued alert processed",
+		zap.String("bot", job.Bot),
+		zap.String("job_id", job.ID),
+		zap.String("request_id", job.RequestID),
+		zap.Int("status", status),
+		zap.Duration("latency", time.Since(job.Created)))
+}
+
+// bufferWriter is an http.ResponseWriter that keeps the response in
+// memory, for alerts processed outside an HTTP request.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferWriter) Header() http.Header { return b.header }
+
+func (b *bufferWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// statusCode returns the response status, 200 when none was written.
+func (b *bufferWriter) statusCode() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
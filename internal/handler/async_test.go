@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/queue"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// waitFinished polls q until job id has an outcome.
+func waitFinished(t *testing.T, q *queue.Queue, id string) queue.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := q.Get(id); ok && (job.Status == queue.StatusSucceeded || job.Status == queue.StatusFailed) {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return queue.Job{}
+}
+
+func TestHandleAsync(t *testing.T) {
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetQueue(q)
+
+	body := `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	req.Header.Set(RequestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+	h.Handle(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body)
+	}
+	var accepted AcceptedResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &accepted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if accepted.ID == "" || accepted.Status != queue.StatusQueued || accepted.RequestID != "req-1" {
+		t.Fatalf("unexpected accepted response %+v", accepted)
+	}
+	if len(broker.placed()) != 0 {
+		t.Fatal("expected no order before a worker runs")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.RunWorkers(ctx, 2)
+		close(done)
+	}()
+	job := waitFinished(t, q, accepted.ID)
+	cancel()
+	<-done
+
+	if job.Status != queue.StatusSucceeded || job.Code != http.StatusOK {
+		t.Fatalf("unexpected job outcome %s %d: %s", job.Status, job.Code, job.Result)
+	}
+	orders := broker.placed()
+	if len(orders) != 1 {
+		t.Fatalf("expected 1 order, got %d", len(orders))
+	}
+	if want := clientOrderID(AlertRequest{Bot: "b", ID: accepted.ID}); orders[0].ClientOrderID != want {
+		t.Fatalf("expected client order ID %q derived from the job, got %q", want, orders[0].ClientOrderID)
+	}
+}
+
+func TestHandleAsyncRejectsInvalid(t *testing.T) {
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetQueue(q)
+
+	for _, body := range []string{
+		`{"bot":"b","symbol":"AAPL","side":"hold","qty":"1"}`,
+		`{"bot":"b","symbol":"AAPL","position":"long"}`,
+	} {
+		rr := httptest.NewRecorder()
+		h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("expected invalid alerts not to be queued, got %d", q.Len())
+	}
+}
+
+func TestRunWorkersResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q, err := queue.Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetQueue(q)
+	body := `{"bot":"b","symbol":"AAPL","side":"sell","qty":"2"}`
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	var accepted AcceptedResponse
+	json.Unmarshal(rr.Body.Bytes(), &accepted)
+	q.Close()
+
+	// A new process picks the alert up from the journal
+	q, err = queue.Open(path, 0)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+	broker := &fakeBroker{}
+	h = NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetQueue(q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.RunWorkers(ctx, 1)
+	waitFinished(t, q, accepted.ID)
+
+	orders := broker.placed()
+	if len(orders) != 1 || orders[0].Side != "sell" || orders[0].Qty.String() != "2" {
+		t.Fatalf("expected resumed sell of 2, got %+v", orders)
+	}
+}
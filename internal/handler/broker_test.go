@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// fakeBroker is an in-process adapter.Broker that records placed orders.
+type fakeBroker struct {
+	mu       sync.Mutex
+	orders   []adapter.OrderRequest
+	position *alpaca.Position
+	err      error
+	lookup   func(id string) (*alpaca.Order, error) // GetOrder and GetOrderByClientOrderID
+}
+
+func (f *fakeBroker) PlaceOrder(req adapter.OrderRequest) (*alpaca.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.orders = append(f.orders, req)
+	return &alpaca.Order{ID: "fake", Symbol: req.Symbol, Side: alpaca.Side(req.Side)}, nil
+}
+
+func (f *fakeBroker) CancelOrder(orderID string) error { return f.err }
+
+func (f *fakeBroker) ReplaceOrder(orderID string, req adapter.ReplaceRequest) (*alpaca.Order, error) {
+	return &alpaca.Order{ID: orderID}, f.err
+}
+
+func (f *fakeBroker) GetPosition(symbol string) (*alpaca.Position, error) {
+	if f.position == nil {
+		return nil, adapter.ErrNoPosition
+	}
+	return f.position, nil
+}
+
+func (f *fakeBroker) ListPositions() ([]alpaca.Position, error) {
+	if f.position == nil {
+		return nil, f.err
+	}
+	return []alpaca.Position{*f.position}, f.err
+}
+
+func (f *fakeBroker) ClosePosition(symbol string, percent decimal.Decimal) (*alpaca.Order, error) {
+	if f.position == nil {
+		return nil, adapter.ErrNoPosition
+	}
+	return &alpaca.Order{ID: "close", Symbol: symbol}, f.err
+}
+
+func (f *fakeBroker) GetAccount() (*alpaca.Account, error) { return &alpaca.Account{}, f.err }
+
+func (f *fakeBroker) ListOpenOrders() ([]alpaca.Order, error) { return nil, f.err }
+
+func (f *fakeBroker) ListOrdersSince(since time.Time) ([]alpaca.Order, error) { return nil, f.err }
+
+func (f *fakeBroker) GetOrder(orderID string) (*alpaca.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lookup == nil {
+		return nil, adapter.ErrOrderNotFound
+	}
+	return f.lookup(orderID)
+}
+
+func (f *fakeBroker) GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lookup == nil {
+		return nil, adapter.ErrOrderNotFound
+	}
+	return f.lookup(clientOrderID)
+}
+
+func (f *fakeBroker) placed() []adapter.OrderRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]adapter.OrderRequest(nil), f.orders...)
+}
+
+func TestHandleFakeBroker(t *testing.T) {
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"sell","qty":"3","type":"limit","limit_price":"10"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	orders := broker.placed()
+	if len(orders) != 1 {
+		t.Fatalf("expected 1 order, got %d", len(orders))
+	}
+	if o := orders[0]; o.Bot != "b" || o.Side != "sell" || o.Qty.String() != "3" || o.LimitPrice.String() != "10" {
+		t.Fatalf("unexpected order %+v", o)
+	}
+}
+
+func TestHandleSimBrokerUsesAlertPrice(t *testing.T) {
+	sim, err := adapter.NewSimBroker(decimal.NewFromInt(1000), "")
+	if err != nil {
+		t.Fatalf("NewSimBroker failed: %v", err)
+	}
+	h := NewHookHandler(zap.NewNop(), sim, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"2","price":"50"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	pos, err := sim.GetPosition("AAPL")
+	if err != nil || !pos.AvgEntryPrice.Equal(decimal.NewFromInt(50)) {
+		t.Fatalf("expected position filled at 50, got %v %v", pos, err)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// errNotReducing is returned when a close would add to the position.
+var errNotReducing = errors.New("side does not reduce the open position")
+
+// errCloseTooSmall is returned when a percentage close rounds to nothing.
+var errCloseTooSmall = errors.New("close quantity is zero")
+
+// parseClosePercent recognises the close-position forms of qty: "all"
+// closes the whole position and "N%" closes N percent of it. ok is false
+// for ordinary quantities.
+func parseClosePercent(qty string) (percent decimal.Decimal, ok bool, err error) {
+	q := strings.TrimSpace(qty)
+	if strings.EqualFold(q, "all") {
+		return hundred, true, nil
+	}
+	if !strings.HasSuffix(q, "%") {
+		return decimal.Zero, false, nil
+	}
+	p, err := decimal.NewFromString(strings.TrimSpace(strings.TrimSuffix(q, "%")))
+	if err != nil || !p.IsPositive() || p.GreaterThan(hundred) {
+		return decimal.Zero, true, fmt.Errorf("invalid qty %q: percentage must be in (0, 100]", qty)
+	}
+	return p, true, nil
+}
+
+// validateClose rejects order fields that cannot be combined with a
+// close-position request, which is always a simple market order.
+func validateClose(alert AlertRequest) error {
+	if alert.Notional != "" {
+		return errors.New("qty and notional are mutually exclusive")
+	}
+	if (alert.Type != "" && !strings.EqualFold(alert.Type, orderMarket)) ||
+		alert.LimitPrice != "" || alert.StopPrice != "" {
+		return errors.New("closing a position only supports market orders")
+	}
+	if (alert.OrderClass != "" && !strings.EqualFold(alert.OrderClass, classSimple)) ||
+		alert.TakeProfit != nil || alert.StopLoss != nil {
+		return errors.New("closing a position does not support order_class legs")
+	}
+	if alert.TimeInForce != "" {
+		return errors.New("closing a position does not support time_in_force")
+	}
+	return nil
+}
+
+// closePosition liquidates percent of the position in alert.Symbol with a
+// market order. The alert side must be the one that reduces the position:
+// sell for a long and buy for a short. The order is placed like any other,
+// rather than through the broker's close-position call, so that it carries
+// the bot's client order ID and its fill counts towards the bot's PnL.
+func (h *HookHandler) closePosition(broker adapter.Broker, alert AlertRequest, percent decimal.Decimal) (*alpaca.Order, error) {
+	pos, err := broker.GetPosition(alert.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	long := pos.Qty.IsPositive() && pos.Side != "short"
+	if (long && alert.Side != "sell") || (!long && alert.Side != "buy") {
+		return nil, fmt.Errorf("%w: %s %s position in %s", errNotReducing, pos.Qty.Abs(), pos.Side, alert.Symbol)
+	}
+
+	qty := pos.Qty.Abs()
+	if !percent.Equal(hundred) {
+		qty = qty.Mul(percent).Div(hundred).Truncate(9)
+	}
+	if !qty.IsPositive() {
+		return nil, fmt.Errorf("%w: %s%% of %s %s rounds to zero", errCloseTooSmall, percent, pos.Qty.Abs(), alert.Symbol)
+	}
+	return broker.PlaceOrder(adapter.OrderRequest{
+		Bot:           alert.Bot,
+		Symbol:        alert.Symbol,
+		Side:          alert.Side,
+		Qty:           qty,
+		Type:          orderMarket,
+		ClientOrderID: clientOrderID(alert),
+	})
+}
+
+// isCloseRejection reports whether err means there was nothing valid to
+// close, which is the client's problem rather than the broker's.
+func isCloseRejection(err error) bool {
+	return errors.Is(err, adapter.ErrNoPosition) || errors.Is(err, errNotReducing) ||
+		errors.Is(err, errCloseTooSmall)
+}
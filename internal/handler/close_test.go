@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// newPositionAlpacaClient serves a single position (or none when position
+// is empty) and records the side, qty and client order ID of closing
+// orders.
+func newPositionAlpacaClient(t *testing.T, position string, closed *string) *adapter.AlpacaClient {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/positions/AAPL", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if position == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":40410000,"message":"position does not exist"}`))
+			return
+		}
+		w.Write([]byte(position))
+	})
+	mux.HandleFunc("/v2/orders", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Side          string `json:"side"`
+			Qty           string `json:"qty"`
+			ClientOrderID string `json:"client_order_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		*closed = req.Side + " " + req.Qty + " " + req.ClientOrderID
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"close"}`))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return adapter.NewAlpacaClient("key", "secret", ts.URL)
+}
+
+func TestParseClosePercent(t *testing.T) {
+	tests := []struct {
+		qty     string
+		want    string
+		ok      bool
+		wantErr bool
+	}{
+		{"all", "100", true, false},
+		{"ALL", "100", true, false},
+		{"50%", "50", true, false},
+		{"12.5 %", "12.5", true, false},
+		{"100%", "100", true, false},
+		{"0%", "", true, true},
+		{"150%", "", true, true},
+		{"x%", "", true, true},
+		{"10", "", false, false},
+	}
+	for _, tt := range tests {
+		p, ok, err := parseClosePercent(tt.qty)
+		if ok != tt.ok || (err != nil) != tt.wantErr {
+			t.Fatalf("%q: got ok=%v err=%v", tt.qty, ok, err)
+		}
+		if err == nil && ok && p.String() != tt.want {
+			t.Fatalf("%q: expected %s, got %s", tt.qty, tt.want, p)
+		}
+	}
+}
+
+func TestHandleCloseAll(t *testing.T) {
+	var closed string
+	client := newPositionAlpacaClient(t, `{"symbol":"AAPL","qty":"10","side":"long"}`, &closed)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"id":"a1","bot":"b","symbol":"AAPL","side":"sell","qty":"all"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	// The close is an ordinary order tagged with the bot's client order ID
+	want := "sell 10 " + clientOrderID(AlertRequest{ID: "a1", Bot: "b"})
+	if closed != want {
+		t.Fatalf("expected close %q, got %q", want, closed)
+	}
+}
+
+func TestHandleClosePercentShort(t *testing.T) {
+	var closed string
+	client := newPositionAlpacaClient(t, `{"symbol":"AAPL","qty":"-10","side":"short"}`, &closed)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"50%"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if !strings.HasPrefix(closed, "buy 5 b-") {
+		t.Fatalf("expected buy of 5 tagged with bot b, got %q", closed)
+	}
+}
+
+func TestHandleCloseNoPosition(t *testing.T) {
+	var closed string
+	client := newPositionAlpacaClient(t, "", &closed)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"sell","qty":"all"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rr.Code)
+	}
+}
+
+func TestHandleCloseWrongSide(t *testing.T) {
+	var closed string
+	client := newPositionAlpacaClient(t, `{"symbol":"AAPL","qty":"10","side":"long"}`, &closed)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"all"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rr.Code)
+	}
+	if closed != "" {
+		t.Fatalf("expected no close request")
+	}
+}
+
+func TestHandleCloseLimitRejected(t *testing.T) {
+	var closed string
+	client := newPositionAlpacaClient(t, `{"symbol":"AAPL","qty":"10","side":"long"}`, &closed)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"sell","qty":"all","type":"limit","limit_price":"100"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+)
+
+// passphraseFields are the body fields that may carry the passphrase.
+var passphraseFields = []string{"passphrase", "secret"}
+
+// Credentials are the keys a bot authenticates with. Any listed key is
+// accepted so that keys can be rotated without downtime.
+type Credentials struct {
+	Secrets     [][]byte // HMAC keys for the X-TV-Signature header
+	Passphrases []string // passphrases for the body
+}
+
+// SetPassphrases enables passphrase authentication for senders such as
+// TradingView that cannot set a signature header. An alert must then carry
+// global in its passphrase or secret field. When a TV secret is also set,
+// either a valid signature header or a valid passphrase is accepted.
+func (h *HookHandler) SetPassphrases(global string) {
+	h.passphrase = global
+}
+
+// SetCredentials registers per-bot keys. Alerts from bots missing from
+// creds are rejected. A bot with no keys of its own authenticates with the
+// global TV secret and passphrase.
+func (h *HookHandler) SetCredentials(creds map[string]Credentials) {
+	h.credentials = creds
+}
+
+// credentialsFor returns the keys alerts from bot must authenticate with.
+// ok is false for bots missing from the credential table.
+func (h *HookHandler) credentialsFor(bot string) (creds Credentials, ok bool) {
+	if h.credentials != nil {
+		c, found := h.credentials[bot]
+		if !found {
+			return Credentials{}, false
+		}
+		if len(c.Secrets) > 0 || len(c.Passphrases) > 0 {
+			return c, true
+		}
+	}
+	if len(h.tvSecret) > 0 {
+		creds.Secrets = [][]byte{h.tvSecret}
+	}
+	if h.passphrase != "" {
+		creds.Passphrases = []string{h.passphrase}
+	}
+	return creds, true
+}
+
+// authenticate verifies the X-TV-Signature header or the body passphrase
+// of alert against its bot's keys, and writes a 401 response when neither
+// is valid. A signature, when sent, must be valid. It reports whether
+// processing may continue.
+func (h *HookHandler) authenticate(w http.ResponseWriter, r *http.Request, body []byte, alert AlertRequest) bool {
+	creds, ok := h.credentialsFor(alert.Bot)
+	if !ok {
+		fields := []zap.Field{zap.String("bot", alert.Bot)}
+		if h.fullLogging {
+			fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
+		}
+		h.logger.Error("unknown bot", fields...)
+		writeError(w, http.StatusUnauthorized, CodeUnknownBot, alert.Bot, "Unknown bot")
+		return false
+	}
+	if len(creds.Secrets) == 0 && len(creds.Passphrases) == 0 {
+		return true
+	}
+
+	sig := r.Header.Get("X-TV-Signature")
+	if sig != "" && len(creds.Secrets) > 0 {
+		return h.checkSignature(w, r, body, alert, creds.Secrets, sig)
+	}
+	if len(creds.Passphrases) > 0 {
+		return h.checkPassphrase(w, r, alert, creds.Passphrases)
+	}
+	writeError(w, http.StatusUnauthorized, CodeUnauthorized, alert.Bot, "Missing signature")
+	return false
+}
+
+// checkSignature verifies sig against each of secrets and writes a 401
+// response when none matches. It reports whether processing may continue.
+func (h *HookHandler) checkSignature(w http.ResponseWriter, r *http.Request, body []byte, alert AlertRequest, secrets [][]byte, sig string) bool {
+	timestamp := r.Header.Get(timestampHeader)
+	var err error
+	for _, secret := range secrets {
+		if err = auth.VerifyHMAC(secret, timestamp, body, sig); err == nil {
+			return true
+		}
+	}
+
+	prefix := sig
+	if len(prefix) > 8 {
+		prefix = prefix[:8]
+	}
+	fields := []zap.Field{zap.Error(err), zap.String("bot", alert.Bot), zap.String("signature", prefix+"...")}
+	if h.fullLogging {
+		fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
+	}
+	h.logger.Error("invalid signature", fields...)
+	writeError(w, http.StatusUnauthorized, CodeUnauthorized, alert.Bot, "Invalid signature")
+	return false
+}
+
+// checkPassphrase verifies the passphrase carried by alert against each of
+// passphrases and writes a 401 response when it is missing or matches
+// none. It reports whether processing may continue.
+func (h *HookHandler) checkPassphrase(w http.ResponseWriter, r *http.Request, alert AlertRequest, passphrases []string) bool {
+	provided := alert.Passphrase
+	if provided == "" {
+		provided = alert.Secret
+	}
+	if provided == "" {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, alert.Bot, "Missing passphrase")
+		return false
+	}
+
+	var err error
+	for _, expected := range passphrases {
+		if err = auth.VerifyPassphrase(expected, provided); err == nil {
+			return true
+		}
+	}
+
+	fields := []zap.Field{zap.Error(err), zap.String("bot", alert.Bot)}
+	if h.fullLogging {
+		fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
+	}
+	h.logger.Error("invalid passphrase", fields...)
+	writeError(w, http.StatusUnauthorized, CodeUnauthorized, alert.Bot, "Invalid passphrase")
+	return false
+}
+
+// redactBody removes passphrase fields from a request body before it is
+// logged. A body that cannot be parsed but may contain a passphrase is
+// replaced entirely.
+func redactBody(body []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		for _, f := range passphraseFields {
+			if bytes.Contains(body, []byte(`"`+f+`"`)) {
+				return "[redacted]"
+			}
+		}
+		return string(body)
+	}
+
+	redacted := false
+	for _, f := range passphraseFields {
+		if _, ok := fields[f]; ok {
+			fields[f] = json.RawMessage(`"[redacted]"`)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return string(body)
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return "[redacted]"
+	}
+	return string(b)
+}
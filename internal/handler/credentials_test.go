@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func TestHandlePassphrase(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		sig  bool
+		code int
+	}{
+		{"global passphrase", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","passphrase":"global"}`, false, http.StatusOK},
+		{"secret alias", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","secret":"global"}`, false, http.StatusOK},
+		{"per-bot passphrase", `{"bot":"own","symbol":"AAPL","side":"buy","qty":"1","passphrase":"mine"}`, false, http.StatusOK},
+		{"global rejected for per-bot", `{"bot":"own","symbol":"AAPL","side":"buy","qty":"1","passphrase":"global"}`, false, http.StatusUnauthorized},
+		{"wrong passphrase", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","passphrase":"nope"}`, false, http.StatusUnauthorized},
+		{"missing passphrase", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, false, http.StatusUnauthorized},
+		{"signed without passphrase", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), []byte("hmac"), nil, true, true, true)
+			h.SetPassphrases("global")
+			h.SetCredentials(map[string]Credentials{"b": {}, "own": {Passphrases: []string{"mine"}}})
+
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(tt.body))
+			if tt.sig {
+				req.Header.Set("X-TV-Signature", sign("hmac", []byte(tt.body)))
+			}
+			rr := httptest.NewRecorder()
+			h.Handle(rr, req)
+			if rr.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rr.Code, rr.Body)
+			}
+			if want := tt.code == http.StatusOK; want != (len(broker.placed()) == 1) {
+				t.Fatalf("unexpected orders %+v", broker.placed())
+			}
+		})
+	}
+}
+
+func TestHandlePassphraseNotLogged(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	h := NewHookHandler(zap.New(core), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetPassphrases("hunter2")
+
+	body := `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","passphrase":"hunter2"}`
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	for _, entry := range logs.All() {
+		for k, v := range entry.ContextMap() {
+			if s, ok := v.(string); ok && strings.Contains(s, "hunter2") {
+				t.Fatalf("passphrase logged in %q field %s", entry.Message, k)
+			}
+		}
+	}
+}
+
+func TestHandlePerBotSecrets(t *testing.T) {
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+	other := []byte(`{"bot":"other","symbol":"AAPL","side":"buy","qty":"1"}`)
+	tests := []struct {
+		name string
+		body []byte
+		sig  string
+		code int
+	}{
+		{"current key", body, sign("new", body), http.StatusOK},
+		{"previous key during rotation", body, sign("old", body), http.StatusOK},
+		{"global key rejected", body, sign("global", body), http.StatusUnauthorized},
+		{"another bot's key rejected", body, sign("other-key", body), http.StatusUnauthorized},
+		{"missing signature", body, "", http.StatusUnauthorized},
+		{"unknown bot", []byte(`{"bot":"x","symbol":"AAPL","side":"buy","qty":"1"}`), sign("global", body), http.StatusUnauthorized},
+		{"bot without own keys uses global", other, sign("global", other), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), []byte("global"), nil, true, true, true)
+			h.SetCredentials(map[string]Credentials{
+				"b":     {Secrets: [][]byte{[]byte("new"), []byte("old")}},
+				"c":     {Secrets: [][]byte{[]byte("other-key")}},
+				"other": {},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(string(tt.body)))
+			if tt.sig != "" {
+				req.Header.Set("X-TV-Signature", tt.sig)
+			}
+			rr := httptest.NewRecorder()
+			h.Handle(rr, req)
+			if rr.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rr.Code, rr.Body)
+			}
+			if want := tt.code == http.StatusOK; want != (len(broker.placed()) == 1) {
+				t.Fatalf("unexpected orders %+v", broker.placed())
+			}
+		})
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := map[string]string{
+		`{"bot":"b","passphrase":"x"}`: `{"bot":"b","passphrase":"[redacted]"}`,
+		`{"secret":"x"}`:               `{"secret":"[redacted]"}`,
+		`{"bot":"b"}`:                  `{"bot":"b"}`,
+		`{"passphrase":"x"`:            `[redacted]`,
+		`not json`:                     `not json`,
+	}
+	for in, want := range tests {
+		if got := redactBody([]byte(in)); got != want {
+			t.Fatalf("redactBody(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
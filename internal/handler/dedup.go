@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/dedup"
+)
+
+// SetDedup enables idempotent processing: an alert whose ID was already
+// processed successfully returns the stored response instead of trading
+// again.
+func (h *HookHandler) SetDedup(store dedup.Store) {
+	h.dedup = store
+}
+
+// alertID returns the idempotency ID of alert: its id field or, failing
+// that, a digest of its order fields and ts. It is empty when neither id
+// nor ts is set, because identical alerts may then be legitimate repeats.
+func alertID(alert AlertRequest) string {
+	if alert.ID != "" {
+		return alert.ID
+	}
+	if alert.TS == 0 {
+		return ""
+	}
+	fields := []string{alert.Bot, alert.Symbol, alert.Side, alert.Qty, alert.Notional,
+		alert.Position, alert.Account, strconv.FormatInt(alert.TS, 10)}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientOrderID derives a deterministic client order ID from the alert ID,
+// so that Alpaca also rejects an order placed twice for the same alert.
+// It keeps the bot prefix of generated IDs and is empty without an alert ID.
+func clientOrderID(alert AlertRequest) string {
+	id := alertID(alert)
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(alert.Bot + "|" + id))
+	return alert.Bot + "-" + hex.EncodeToString(sum[:10])
+}
+
+// recordingWriter captures the status and body of a response so it can be
+// stored for replays.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recordingWriter) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recordingWriter) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// claimAlert reserves key in the dedup store. When the alert was already
+// processed it replays the stored response, and while it is in flight it
+// writes a 409 response; in both cases it reports false. Otherwise it
+// returns a writer that records the response for finishAlert.
+func (h *HookHandler) claimAlert(w http.ResponseWriter, alert AlertRequest, key string) (*recordingWriter, bool) {
+	stored, err := h.dedup.Claim(key)
+	if errors.Is(err, dedup.ErrInFlight) {
+		h.logger.Warn("duplicate alert in flight",
+			zap.String("bot", alert.Bot),
+			zap.String("alert_id", alertID(alert)))
+		writeError(w, http.StatusConflict, CodeInFlight, alert.Bot, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		h.logger.Error("dedup store failed",
+			zap.Error(err),
+			zap.String("bot", alert.Bot))
+		writeError(w, http.StatusInternalServerError, CodeInternal, alert.Bot, "Dedup store unavailable")
+		return nil, false
+	}
+	if stored != nil {
+		h.logger.Info("replaying duplicate alert",
+			zap.String("bot", alert.Bot),
+			zap.String("alert_id", alertID(alert)),
+			zap.Int("status", stored.Status))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+		return nil, false
+	}
+	return &recordingWriter{ResponseWriter: w}, true
+}
+
+// finishAlert stores a successful response for key, or releases key after
+// a failure so that the alert can be retried.
+func (h *HookHandler) finishAlert(rw *recordingWriter, key string) {
+	if rw.status < http.StatusOK || rw.status >= http.StatusMultipleChoices {
+		h.dedup.Release(key)
+		return
+	}
+	resp := dedup.Response{Status: rw.status, Body: rw.body.Bytes()}
+	if err := h.dedup.Complete(key, resp); err != nil {
+		h.logger.Error("failed to store alert response",
+			zap.Error(err),
+			zap.String("key", key))
+	}
+}
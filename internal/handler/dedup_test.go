@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/dedup"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func TestHandleReplaysDuplicateAlert(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"explicit id", `{"id":"alert-1","bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`},
+		{"derived from ts", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","ts":1700000000000}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+			h.SetDedup(dedup.NewMemoryStore(time.Hour))
+
+			first := httptest.NewRecorder()
+			h.Handle(first, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(tt.body)))
+			second := httptest.NewRecorder()
+			h.Handle(second, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(tt.body)))
+
+			if first.Code != http.StatusOK || second.Code != http.StatusOK {
+				t.Fatalf("expected 200 twice, got %d and %d", first.Code, second.Code)
+			}
+			if second.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatal("expected replay header")
+			}
+			if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+				t.Fatalf("expected original response, got %s", second.Body)
+			}
+			orders := broker.placed()
+			if len(orders) != 1 {
+				t.Fatalf("expected 1 order, got %d", len(orders))
+			}
+			if !strings.HasPrefix(orders[0].ClientOrderID, "b-") {
+				t.Fatalf("expected deterministic client order id, got %q", orders[0].ClientOrderID)
+			}
+		})
+	}
+}
+
+func TestHandleRetriesFailedAlert(t *testing.T) {
+	broker := &fakeBroker{err: errors.New("broker down")}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetDedup(dedup.NewMemoryStore(time.Hour))
+
+	body := `{"id":"alert-1","bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+
+	broker.err = nil
+	rr = httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected fresh 200 after failure, got %d", rr.Code)
+	}
+	if len(broker.placed()) != 1 {
+		t.Fatalf("expected 1 order, got %d", len(broker.placed()))
+	}
+}
+
+func TestHandleInFlightAlert(t *testing.T) {
+	store := dedup.NewMemoryStore(time.Hour)
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetDedup(store)
+	store.Claim("b:alert-1")
+
+	body := `{"id":"alert-1","bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rr.Code)
+	}
+}
+
+func TestClientOrderID(t *testing.T) {
+	a := AlertRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: "1", TS: 1}
+	if clientOrderID(AlertRequest{Bot: "b"}) != "" {
+		t.Fatal("expected no client order id without id or ts")
+	}
+	if id := clientOrderID(a); id != clientOrderID(a) || !strings.HasPrefix(id, "b-") {
+		t.Fatalf("expected stable bot-prefixed id, got %q", id)
+	}
+	b := a
+	b.Qty = "2"
+	if clientOrderID(a) == clientOrderID(b) {
+		t.Fatal("expected different alerts to get different ids")
+	}
+	b = a
+	b.ID = "x"
+	if clientOrderID(a) == clientOrderID(b) {
+		t.Fatal("expected explicit id to take precedence")
+	}
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// Order modes recorded in the mode label of metrics.OrderTotal.
+const (
+	modeLive   = "live"
+	modeDryRun = "dry_run"
+)
+
+// SetDryRun makes alerts run through validation and the risk rules
+// without trading: every alert when all is set, or alerts from the bots in
+// bots. An alert may also ask for a dry run with its dry_run field, but
+// cannot opt out of one.
+func (h *HookHandler) SetDryRun(all bool, bots map[string]bool) {
+	h.dryRunAll = all
+	h.dryRunBots = bots
+}
+
+// isDryRun reports whether alert must not trade.
+func (h *HookHandler) isDryRun(alert AlertRequest) bool {
+	return alert.DryRun || h.dryRunAll || h.dryRunBots[alert.Bot]
+}
+
+// tradingBroker returns broker, or a wrapper that only builds orders when
+// alert is a dry run.
+func (h *HookHandler) tradingBroker(alert AlertRequest, broker adapter.Broker) adapter.Broker {
+	if !alert.DryRun {
+		return broker
+	}
+	dry := adapter.NewDryRunBroker(broker, alert.Bot)
+	dry.SetLogger(h.logger.With(zap.String("bot", alert.Bot)))
+	return dry
+}
+
+// orderMode returns the mode label of alert's orders.
+func orderMode(alert AlertRequest) string {
+	if alert.DryRun {
+		return modeDryRun
+	}
+	return modeLive
+}
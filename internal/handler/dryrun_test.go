@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/notify"
+	"github.com/njdaniel/alertbridge/internal/risk"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+func postAlert(h *HookHandler, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	return rr
+}
+
+func TestHandleDryRunPayload(t *testing.T) {
+	var messages []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		messages = append(messages, string(b))
+	}))
+	defer slack.Close()
+
+	broker := &fakeBroker{}
+	notifier := notify.NewSlackNotifier(slack.URL, "", "")
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("60"), nil, notifier, true, true, true)
+	dryRuns := metrics.OrderTotal.WithLabelValues("dry-payload", "buy", modeDryRun)
+	before := testutil.ToFloat64(dryRuns)
+
+	rr := postAlert(h, `{"bot":"dry-payload","symbol":"AAPL","side":"buy","qty":"2","type":"limit","limit_price":"150","dry_run":true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var order alpaca.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &order); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if order.Status != adapter.DryRunStatus || order.Symbol != "AAPL" || !order.Qty.Equal(decimal.NewFromInt(2)) || !order.LimitPrice.Equal(decimal.NewFromInt(150)) {
+		t.Fatalf("expected the would-be order, got %+v", order)
+	}
+	if orders := broker.placed(); len(orders) != 0 {
+		t.Fatalf("expected no orders sent to the broker, got %+v", orders)
+	}
+	if diff := testutil.ToFloat64(dryRuns) - before; diff != 1 {
+		t.Fatalf("expected dry-run order to be counted, got %v", diff)
+	}
+	if len(messages) != 1 || !strings.Contains(messages[0], "Would place") {
+		t.Fatalf("expected a would-place notification, got %v", messages)
+	}
+
+	// The rehearsal did not start the cooldown, so the live alert passes
+	if rr := postAlert(h, `{"bot":"dry-payload","symbol":"AAPL","side":"buy","qty":"2"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected the live alert to pass, got %d: %s", rr.Code, rr.Body)
+	}
+	// The risk rules still apply to rehearsals
+	if rr := postAlert(h, `{"bot":"dry-payload","symbol":"AAPL","side":"buy","qty":"2","dry_run":true}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected cooldown to reject the dry run, got %d", rr.Code)
+	}
+}
+
+func TestHandleDryRunBot(t *testing.T) {
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetDryRun(false, map[string]bool{"shadow": true})
+
+	if rr := postAlert(h, `{"bot":"shadow","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	// An alert cannot turn dry-run off for its bot
+	if rr := postAlert(h, `{"bot":"shadow","symbol":"AAPL","side":"buy","qty":"1","dry_run":false}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if orders := broker.placed(); len(orders) != 0 {
+		t.Fatalf("expected shadow bot not to trade, got %+v", orders)
+	}
+
+	if rr := postAlert(h, `{"bot":"live","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if orders := broker.placed(); len(orders) != 1 || orders[0].Bot != "live" {
+		t.Fatalf("expected live bot to trade, got %+v", orders)
+	}
+}
+
+func TestHandleDryRunValidation(t *testing.T) {
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetDryRun(true, nil)
+
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"hold","qty":"1"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid alert to be rejected in dry-run mode, got %d", rr.Code)
+	}
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"sell","qty":"all"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected nothing to close, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestHandleDryRunFanout(t *testing.T) {
+	small, large, fixed := &fakeBroker{}, &fakeBroker{}, &fakeBroker{}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+	h.SetDryRun(true, nil)
+
+	rr := postAlert(h, `{"bot":"copy","symbol":"AAPL","side":"buy","qty":"10"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var resp FanoutResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Results) != 3 || resp.Results[1].Order == nil || !resp.Results[1].Order.Qty.Equal(decimal.NewFromInt(30)) {
+		t.Fatalf("expected scaled would-be orders, got %+v", resp.Results)
+	}
+	for _, b := range []*fakeBroker{small, large, fixed} {
+		if orders := b.placed(); len(orders) != 0 {
+			t.Fatalf("expected no orders sent to the broker, got %+v", orders)
+		}
+	}
+}
+
+func TestHandleDryRunPosition(t *testing.T) {
+	broker := &fakeBroker{position: &alpaca.Position{Symbol: "AAPL", Qty: decimal.NewFromInt(10), Side: "long"}}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	rr := postAlert(h, `{"bot":"b","symbol":"AAPL","position":"short","qty":"5","dry_run":true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var result PositionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result.Orders) != 2 || result.Orders[0].Status != adapter.DryRunStatus || !result.Orders[1].Qty.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if orders := broker.placed(); len(orders) != 0 {
+		t.Fatalf("expected no orders sent to the broker, got %+v", orders)
+	}
+}
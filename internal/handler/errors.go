@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+)
+
+// RequestIDHeader carries the request ID. A valid ID sent by the client is
+// echoed back; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// Error codes returned in ErrorResponse. They are part of the API and are
+// documented in docs/webhook.md.
+const (
+	CodeInvalidRequest     = "invalid_request"     // body unreadable
+	CodeInvalidJSON        = "invalid_json"        // body is not a valid alert
+	CodeBodyTooLarge       = "body_too_large"      // body exceeds the size limit
+	CodeMissingFields      = "missing_fields"      // a required field is empty
+	CodeInvalidField       = "invalid_field"       // a field has an invalid value
+	CodeUnauthorized       = "unauthorized"        // signature or passphrase missing or invalid
+	CodeUnknownBot         = "unknown_bot"         // bot has no credentials
+	CodeStaleAlert         = "stale_alert"         // timestamp missing, too old or in the future
+	CodeSourceForbidden    = "source_forbidden"    // source address not allowed globally or for the bot
+	CodeAccountForbidden   = "account_forbidden"   // account not allowed for the bot
+	CodeAccountUnavailable = "account_unavailable" // account has no broker
+	CodeRateLimited        = "rate_limited"        // too many requests, see Retry-After
+	CodeRiskRejected       = "risk_rejected"       // a risk rule rejected the alert
+	CodeInFlight           = "in_flight"           // the same alert is being processed
+	CodeNothingToClose     = "nothing_to_close"    // no position to close or reduce
+	CodeBrokerRejected     = "broker_rejected"     // the broker refused the order
+	CodeBrokerError        = "broker_error"        // the broker could not be reached or failed
+	CodeInternal           = "internal_error"      // alertbridge failed
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+)
+
+// retryableCodes are the codes of failures that may succeed when the same
+// alert is sent again later.
+var retryableCodes = map[string]bool{
+	CodeRateLimited: true,
+	CodeInFlight:    true,
+	CodeBrokerError: true,
+	CodeInternal:    true,
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Bot       string `json:"bot,omitempty"`
+	Rule      string `json:"rule,omitempty"` // the risk rule that rejected the alert
+	RequestID string `json:"request_id"`
+	Retryable bool   `json:"retryable"`
+}
+
+// requestIDPattern limits echoed request IDs to safe, bounded values.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// setRequestID sets the response's request ID header, reusing one already
+// set, then a valid one sent by the client, and returns it.
+func setRequestID(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = newID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SourceForbiddenHandler writes the 403 response to requests rejected by
+// the IP allow-list, for auth.IPFilter.SetRejectHandler.
+func SourceForbiddenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRequestID(w, r)
+		writeError(w, http.StatusForbidden, CodeSourceForbidden, "", "Source address not allowed")
+	})
+}
+
+// writeError writes an ErrorResponse with status. The request ID is read
+// from the response header set by setRequestID.
+func writeError(w http.ResponseWriter, status int, code, bot, message string) {
+	writeErrorResponse(w, status, ErrorResponse{Code: code, Message: message, Bot: bot})
+}
+
+// writeErrorResponse fills in the request ID and retryability of resp and
+// writes it with status.
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	resp.RequestID = w.Header().Get(RequestIDHeader)
+	resp.Retryable = retryableCodes[resp.Code]
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// brokerErrorCode classifies an order error: client errors returned by
+// Alpaca are rejections that fail again when retried, except rate limits.
+func brokerErrorCode(err error) string {
+	var apiErr *alpaca.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+		apiErr.StatusCode != http.StatusTooManyRequests {
+		return CodeBrokerRejected
+	}
+	return CodeBrokerError
+}
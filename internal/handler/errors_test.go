@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/ratelimit"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func TestHandleErrorResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		setup     func(h *HookHandler, b *fakeBroker)
+		status    int
+		code      string
+		retryable bool
+	}{
+		{"malformed body", `{`, nil, http.StatusBadRequest, CodeInvalidJSON, false},
+		{"missing fields", `{"bot":"b","symbol":"AAPL"}`, nil, http.StatusBadRequest, CodeMissingFields, false},
+		{"invalid side", `{"bot":"b","symbol":"AAPL","side":"hold","qty":"1"}`, nil, http.StatusBadRequest, CodeInvalidField, false},
+		{"bad signature", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, func(h *HookHandler, b *fakeBroker) {
+			h.tvSecret = []byte("secret")
+		}, http.StatusUnauthorized, CodeUnauthorized, false},
+		{"risk rejection", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, func(h *HookHandler, b *fakeBroker) {
+			h.riskGuard = risk.NewGuard("60")
+			h.riskGuard.Check("b")
+		}, http.StatusForbidden, CodeRiskRejected, false},
+		{"rate limited", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, func(h *HookHandler, b *fakeBroker) {
+			h.SetRateLimits(nil, nil, ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 1}))
+			h.botLimit.Allow("b")
+		}, http.StatusTooManyRequests, CodeRateLimited, true},
+		{"broker failure", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, func(h *HookHandler, b *fakeBroker) {
+			b.err = errors.New("connection reset")
+		}, http.StatusInternalServerError, CodeBrokerError, true},
+		{"broker rejection", `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`, func(h *HookHandler, b *fakeBroker) {
+			b.err = &alpaca.APIError{StatusCode: http.StatusForbidden, Message: "insufficient buying power"}
+		}, http.StatusInternalServerError, CodeBrokerRejected, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+			if tt.setup != nil {
+				tt.setup(h, broker)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			h.Handle(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("expected JSON content type, got %q", ct)
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode error response %q: %v", rr.Body, err)
+			}
+			if resp.Code != tt.code || resp.Retryable != tt.retryable || resp.Message == "" {
+				t.Fatalf("unexpected error response %+v", resp)
+			}
+			if resp.RequestID == "" || resp.RequestID != rr.Header().Get(RequestIDHeader) {
+				t.Fatalf("expected request ID %q in body, got %q", rr.Header().Get(RequestIDHeader), resp.RequestID)
+			}
+			if strings.Contains(tt.body, `"bot"`) && resp.Bot != "b" {
+				t.Fatalf("expected bot in error response, got %q", resp.Bot)
+			}
+		})
+	}
+}
+
+func TestSetRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		echo bool
+	}{
+		{"echoes client ID", "abc-123", true},
+		{"generates when missing", "", false},
+		{"replaces unsafe ID", "bad id\n", false},
+		{"replaces long ID", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/hook", nil)
+			if tt.sent != "" {
+				req.Header.Set(RequestIDHeader, tt.sent)
+			}
+			rr := httptest.NewRecorder()
+
+			id := setRequestID(rr, req)
+			if got := rr.Header().Get(RequestIDHeader); got != id {
+				t.Fatalf("expected header %q, got %q", id, got)
+			}
+			if (id == tt.sent) != tt.echo {
+				t.Fatalf("unexpected request ID %q for %q", id, tt.sent)
+			}
+			if !tt.echo && len(id) != 32 {
+				t.Fatalf("expected generated ID, got %q", id)
+			}
+			if again := setRequestID(rr, req); again != id {
+				t.Fatalf("expected existing ID to be reused, got %q", again)
+			}
+		})
+	}
+}
+
+func TestServeHTTPErrorResponses(t *testing.T) {
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodPost, "/other", http.StatusNotFound, CodeNotFound},
+		{http.MethodGet, "/hook", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		var resp ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode error response: %v", err)
+		}
+		if rr.Code != tt.status || resp.Code != tt.code {
+			t.Fatalf("%s %s: got %d %+v", tt.method, tt.path, rr.Code, resp)
+		}
+	}
+}
+
+func TestSourceForbiddenHandler(t *testing.T) {
+	allow, err := auth.ParseCIDRs([]string{"tradingview"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs: %v", err)
+	}
+	f := auth.NewIPFilter(allow, nil)
+	f.SetRejectHandler(SourceForbiddenHandler())
+	h := f.Middleware(NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true))
+
+	r := httptest.NewRequest(http.MethodPost, "/hook", nil)
+	r.RemoteAddr = "203.0.113.9:5000"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	var resp ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if rr.Code != http.StatusForbidden || resp.Code != CodeSourceForbidden || resp.RequestID == "" {
+		t.Fatalf("expected a source_forbidden error, got %d %+v", rr.Code, resp)
+	}
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/config"
+	"github.com/njdaniel/alertbridge/internal/risk"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+// FanoutResult is the outcome of a fanned-out alert in one account.
+type FanoutResult struct {
+	Account string        `json:"account"`
+	Order   *alpaca.Order `json:"order,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// FanoutResponse is the response to a fanned-out alert.
+type FanoutResponse struct {
+	Results []FanoutResult `json:"results"`
+}
+
+// fanoutTargets returns the accounts alert is copied into, or nil when it
+// trades a single account. An alert that names an account is never fanned
+// out.
+func (h *HookHandler) fanoutTargets(alert AlertRequest) []config.FanoutTarget {
+	if h.routing == nil || alert.Account != "" {
+		return nil
+	}
+	return h.routing.Bots[alert.Bot].Fanout
+}
+
+// scaleOrder sizes req for one fan-out target. Multiplied equity
+// quantities are rounded down to whole shares, and a leg that rounds to
+// none is an error.
+func scaleOrder(req adapter.OrderRequest, t config.FanoutTarget) (adapter.OrderRequest, error) {
+	switch {
+	case t.Qty != nil:
+		req.Qty = *t.Qty
+		req.Notional = nil
+	case t.Notional != nil:
+		if req.Type != orderMarket || (req.OrderClass != "" && req.OrderClass != classSimple) {
+			return req, errors.New("notional sizing is only supported for simple market orders")
+		}
+		notional := *t.Notional
+		req.Notional = &notional
+		req.Qty = decimal.Zero
+	case t.Multiplier != nil:
+		if req.Notional != nil {
+			// Alpaca accepts notional amounts to the cent
+			notional := req.Notional.Mul(*t.Multiplier).Round(2)
+			req.Notional = &notional
+		} else if adapter.IsCrypto(req.Symbol) {
+			// and crypto quantities to nine decimal places
+			req.Qty = req.Qty.Mul(*t.Multiplier).Truncate(9)
+		} else {
+			// Fractional shares are refused for bracket, OCO and OTO orders
+			// and outside day orders, so equity legs are whole shares
+			scaled := req.Qty.Mul(*t.Multiplier)
+			req.Qty = scaled.Floor()
+			if !req.Qty.IsPositive() {
+				return req, fmt.Errorf("scaled qty %s is less than one share", scaled)
+			}
+		}
+	}
+	if req.Notional == nil && !req.Qty.IsPositive() {
+		return req, fmt.Errorf("scaled qty %s is not positive", req.Qty)
+	}
+	if req.Notional != nil && !req.Notional.IsPositive() {
+		return req, fmt.Errorf("scaled notional %s is not positive", req.Notional)
+	}
+	return req, nil
+}
+
+// handleFanout places req, or closes percent of the position when closing,
+// in every target account concurrently. The orders of all accounts are
+// checked by the risk rules first, and one rejection rejects the alert.
+// The response reports each account; it is 200 when all succeed, 207 when
+// some fail and 500 when all fail.
+func (h *HookHandler) handleFanout(w http.ResponseWriter, alert AlertRequest, targets []config.FanoutTarget, req adapter.OrderRequest, closing bool, percent decimal.Decimal) {
+	brokers := make([]adapter.Broker, len(targets))
+	for i, t := range targets {
+		broker, ok := h.accounts[t.Account]
+		if !ok {
+			h.logger.Error("no broker for account",
+				zap.String("bot", alert.Bot),
+				zap.String("account", t.Account))
+			writeError(w, http.StatusInternalServerError, CodeAccountUnavailable, alert.Bot, "Account unavailable")
+			return
+		}
+		brokers[i] = h.tradingBroker(alert, broker)
+		if !h.observePrice(w, brokers[i], alert) {
+			return
+		}
+	}
+
+	// Size the order for each account, then check all of them together
+	reqs := make([]adapter.OrderRequest, len(targets))
+	results := make([]FanoutResult, len(targets))
+	var intents []risk.Intent
+	var sized []int
+	for i, t := range targets {
+		results[i] = FanoutResult{Account: t.Account}
+		if !closing {
+			scaled, err := scaleOrder(req, t)
+			if err != nil {
+				h.logger.Error("failed to create order",
+					zap.Error(err),
+					zap.String("bot", alert.Bot),
+					zap.String("account", t.Account),
+					zap.String("symbol", alert.Symbol),
+					zap.String("side", alert.Side))
+				results[i].Error = err.Error()
+				continue
+			}
+			reqs[i] = scaled
+		}
+		intents = append(intents, orderIntent(alert, t.Account, brokers[i], reqs[i], closing))
+		sized = append(sized, i)
+	}
+	intents, ok := h.checkRisk(w, alert, intents...)
+	if !ok {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for j, i := range sized {
+		if !closing {
+			reqs[i] = applyIntent(reqs[i], intents[j])
+		}
+		wg.Add(1)
+		go func(i int, t config.FanoutTarget) {
+			defer wg.Done()
+
+			var order *alpaca.Order
+			var err error
+			if closing {
+				order, err = h.closePosition(brokers[i], alert, percent)
+			} else {
+				order, err = brokers[i].PlaceOrder(reqs[i])
+			}
+			if err != nil {
+				h.logger.Error("failed to create order",
+					zap.Error(err),
+					zap.String("bot", alert.Bot),
+					zap.String("account", t.Account),
+					zap.String("symbol", alert.Symbol),
+					zap.String("side", alert.Side))
+				results[i].Error = err.Error()
+				return
+			}
+			metrics.OrderTotal.WithLabelValues(alert.Bot, alert.Side, orderMode(alert)).Inc()
+			msg := "order created successfully"
+			if alert.DryRun {
+				msg = "would place order"
+			}
+			h.logger.Info(msg,
+				zap.String("bot", alert.Bot),
+				zap.String("account", t.Account),
+				zap.String("symbol", alert.Symbol),
+				zap.String("side", alert.Side),
+				zap.String("order_id", order.ID),
+				zap.Bool("dry_run", alert.DryRun))
+			results[i].Order = order
+		}(i, targets[i])
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, r := range results {
+		if r.Error != "" {
+			failed = append(failed, r.Account+": "+r.Error)
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(failed) == len(results):
+		status = http.StatusInternalServerError
+		h.riskGuard.Release(intents...)
+		if h.notifier != nil && h.notifyFailure {
+			h.notifier.SendMessage("Fan-out failed for bot " + alert.Bot + " in all accounts: " + strings.Join(failed, "; "))
+		}
+	case len(failed) > 0:
+		status = http.StatusMultiStatus
+		if h.notifier != nil && h.notifyFailure {
+			h.notifier.SendMessage(fmt.Sprintf("Fan-out partially failed for bot %s (%d of %d accounts): %s",
+				alert.Bot, len(failed), len(results), strings.Join(failed, "; ")))
+		}
+	default:
+		if h.notifier != nil && h.notifySuccess {
+			note := "Order created"
+			if alert.DryRun {
+				note = "Would place"
+			}
+			h.notifier.SendMessage(fmt.Sprintf("%s: %s %s %s %s in %d accounts",
+				note, alert.Bot, alert.Side, alert.Symbol, sizeLabel(alert), len(results)))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(FanoutResponse{Results: results})
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/config"
+	"github.com/njdaniel/alertbridge/internal/notify"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func decPtr(s string) *decimal.Decimal {
+	d := decimal.RequireFromString(s)
+	return &d
+}
+
+func newFanoutHandler(notifier *notify.SlackNotifier, brokers map[string]adapter.Broker) *HookHandler {
+	cfg := &config.Config{
+		Accounts: map[string]config.Account{"small": {}, "large": {}, "fixed": {}},
+		Bots: map[string]config.Bot{
+			"copy": {Fanout: []config.FanoutTarget{
+				{Account: "small", Multiplier: decPtr("0.5")},
+				{Account: "large", Multiplier: decPtr("3")},
+				{Account: "fixed", Qty: decPtr("7")},
+			}},
+		},
+	}
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, notifier, true, true, true)
+	h.SetAccounts(cfg, brokers)
+	return h
+}
+
+func TestHandleFanout(t *testing.T) {
+	small, large, fixed := &fakeBroker{}, &fakeBroker{}, &fakeBroker{}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+
+	body := []byte(`{"bot":"copy","symbol":"AAPL","side":"buy","qty":"10"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	var resp FanoutResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Results) != 3 || resp.Results[0].Account != "small" || resp.Results[0].Order == nil {
+		t.Fatalf("unexpected results %+v", resp.Results)
+	}
+
+	for name, tc := range map[string]struct {
+		broker *fakeBroker
+		qty    string
+	}{"small": {small, "5"}, "large": {large, "30"}, "fixed": {fixed, "7"}} {
+		orders := tc.broker.placed()
+		if len(orders) != 1 || orders[0].Qty.String() != tc.qty {
+			t.Fatalf("%s: expected one order of %s, got %+v", name, tc.qty, orders)
+		}
+	}
+}
+
+func TestHandleFanoutPartialFailure(t *testing.T) {
+	var messages []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		messages = append(messages, string(b))
+	}))
+	defer slack.Close()
+
+	small, fixed := &fakeBroker{}, &fakeBroker{}
+	large := &fakeBroker{err: errors.New("insufficient buying power")}
+	notifier := notify.NewSlackNotifier(slack.URL, "", "")
+	h := newFanoutHandler(notifier, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+
+	body := []byte(`{"bot":"copy","symbol":"AAPL","side":"buy","qty":"10"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rr.Code, rr.Body)
+	}
+
+	var resp FanoutResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if r := resp.Results[1]; r.Account != "large" || r.Order != nil || r.Error == "" {
+		t.Fatalf("expected failure for large, got %+v", r)
+	}
+	if len(messages) != 1 || !strings.Contains(messages[0], "partially failed") || !strings.Contains(messages[0], "large") {
+		t.Fatalf("expected partial failure notification, got %v", messages)
+	}
+}
+
+func TestHandleFanoutWholeShares(t *testing.T) {
+	small, large, fixed := &fakeBroker{}, &fakeBroker{}, &fakeBroker{}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+
+	// Half of one share rounds down to none, so the small account is skipped
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook",
+		strings.NewReader(`{"bot":"copy","symbol":"AAPL","side":"buy","qty":"1"}`)))
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rr.Code, rr.Body)
+	}
+	var resp FanoutResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Results[0].Account != "small" || resp.Results[0].Error == "" || len(small.placed()) != 0 {
+		t.Fatalf("expected no order for the small account, got %+v", resp.Results[0])
+	}
+	if orders := large.placed(); len(orders) != 1 || orders[0].Qty.String() != "3" {
+		t.Fatalf("expected 3 shares in the large account, got %+v", orders)
+	}
+}
+
+func TestHandleFanoutRiskRule(t *testing.T) {
+	small, large, fixed := &fakeBroker{}, &fakeBroker{}, &fakeBroker{}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+	var accounts []string
+	h.riskGuard.Register(ruleFunc{"max_qty", func(in risk.Intent) risk.Decision {
+		accounts = append(accounts, in.Account+" "+in.Qty.String())
+		if in.Qty.GreaterThan(decimal.NewFromInt(20)) {
+			return risk.Deny("qty %s too large", in.Qty)
+		}
+		return risk.Allow()
+	}})
+
+	body := []byte(`{"bot":"copy","symbol":"AAPL","side":"buy","qty":"10"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rr.Code, rr.Body)
+	}
+	if strings.Join(accounts, ",") != "small 5,large 30" {
+		t.Fatalf("expected each account's scaled order to be checked, got %v", accounts)
+	}
+	for _, b := range []*fakeBroker{small, large, fixed} {
+		if orders := b.placed(); len(orders) != 0 {
+			t.Fatalf("expected no orders after a rejection, got %+v", orders)
+		}
+	}
+}
+
+func TestHandleFanoutExplicitAccount(t *testing.T) {
+	small, large, fixed := &fakeBroker{}, &fakeBroker{}, &fakeBroker{}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+
+	body := []byte(`{"bot":"copy","symbol":"AAPL","side":"buy","qty":"10","account":"large"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if len(small.placed()) != 0 || len(fixed.placed()) != 0 {
+		t.Fatal("expected only the selected account to trade")
+	}
+	if orders := large.placed(); len(orders) != 1 || orders[0].Qty.String() != "10" {
+		t.Fatalf("expected unscaled order in large, got %+v", orders)
+	}
+}
+
+func TestHandleFanoutPosition(t *testing.T) {
+	small, large, fixed := &fakeBroker{}, &fakeBroker{}, &fakeBroker{}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": fixed})
+
+	rr := postAlert(h, `{"bot":"copy","symbol":"AAPL","position":"long","qty":"10"}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidField) {
+		t.Fatalf("expected 400 invalid_field, got %d: %s", rr.Code, rr.Body)
+	}
+
+	// Naming one of the accounts targets the position there
+	if rr := postAlert(h, `{"bot":"copy","symbol":"AAPL","position":"long","qty":"10","account":"large"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if len(small.placed()) != 0 || len(fixed.placed()) != 0 {
+		t.Fatal("expected only the selected account to trade")
+	}
+	if orders := large.placed(); len(orders) != 1 || orders[0].Qty.String() != "10" {
+		t.Fatalf("expected the position opened in large, got %+v", orders)
+	}
+}
+
+func TestScaleOrder(t *testing.T) {
+	market := adapter.OrderRequest{Symbol: "AAPL", Type: orderMarket, Qty: decimal.RequireFromString("3")}
+	notional := adapter.OrderRequest{Symbol: "AAPL", Type: orderMarket, Notional: decPtr("100.015")}
+	limit := adapter.OrderRequest{Symbol: "AAPL", Type: orderLimit, Qty: decimal.RequireFromString("3")}
+	crypto := adapter.OrderRequest{Symbol: "BTC/USD", Type: orderMarket, Qty: decimal.RequireFromString("3")}
+
+	tests := []struct {
+		name     string
+		req      adapter.OrderRequest
+		target   config.FanoutTarget
+		qty      string
+		notional string
+		wantErr  bool
+	}{
+		{"unchanged", market, config.FanoutTarget{}, "3", "", false},
+		{"multiplier qty", market, config.FanoutTarget{Multiplier: decPtr("1.5")}, "4", "", false},
+		{"multiplier below one share", market, config.FanoutTarget{Multiplier: decPtr("0.1")}, "", "", true},
+		{"multiplier crypto qty", crypto, config.FanoutTarget{Multiplier: decPtr("0.1")}, "0.3", "", false},
+		{"multiplier notional", notional, config.FanoutTarget{Multiplier: decPtr("2")}, "0", "200.03", false},
+		{"fixed qty replaces notional", notional, config.FanoutTarget{Qty: decPtr("4")}, "4", "", false},
+		{"fixed notional", market, config.FanoutTarget{Notional: decPtr("50")}, "0", "50", false},
+		{"notional on limit", limit, config.FanoutTarget{Notional: decPtr("50")}, "", "", true},
+		{"truncated to zero", crypto, config.FanoutTarget{Multiplier: decPtr("0.0000000001")}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scaleOrder(tt.req, tt.target)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Qty.String() != tt.qty {
+				t.Fatalf("expected qty %s, got %s", tt.qty, got.Qty)
+			}
+			if (tt.notional == "") != (got.Notional == nil) || (got.Notional != nil && got.Notional.String() != tt.notional) {
+				t.Fatalf("expected notional %q, got %v", tt.notional, got.Notional)
+			}
+		})
+	}
+}
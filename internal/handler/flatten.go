@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// Steps of a flatten reported in FlattenResult.Action.
+const (
+	flattenCancel = "cancel"
+	flattenClose  = "close"
+)
+
+// FlattenRequest is the body of POST /admin/flatten. An empty body
+// flattens every account.
+type FlattenRequest struct {
+	// Bot limits the flatten to the accounts the bot trades, to its own
+	// open orders and to the part of each position its own orders opened,
+	// so other bots sharing those accounts keep their positions.
+	Bot string `json:"bot,omitempty"`
+	// Account limits the flatten to one account. Without Bot, every bot is
+	// still halted, including bots that trade other accounts.
+	Account string `json:"account,omitempty"`
+}
+
+// FlattenResult is the outcome of cancelling one order or closing one
+// position. Symbol is empty when the orders or positions of an account
+// could not be listed.
+type FlattenResult struct {
+	Account string           `json:"account,omitempty"`
+	Symbol  string           `json:"symbol"`
+	Action  string           `json:"action"` // cancel or close
+	OrderID string           `json:"order_id,omitempty"`
+	Qty     *decimal.Decimal `json:"qty,omitempty"` // the position closed
+	Error   string           `json:"error,omitempty"`
+}
+
+// FlattenResponse is the response to POST /admin/flatten.
+type FlattenResponse struct {
+	Bot     string          `json:"bot,omitempty"`
+	Halts   risk.Halts      `json:"halts"`
+	Results []FlattenResult `json:"results"`
+}
+
+// flatten halts the bot, or every bot, and then cancels the open orders
+// and closes the positions of the accounts it trades. A flatten without a
+// bot halts every bot even when it is narrowed to one account, since the
+// kill switch has no per-account halt. The response is 200 OK when every
+// step succeeds and 207 Multi-Status otherwise.
+func (h *HookHandler) flatten(w http.ResponseWriter, r *http.Request) {
+	var req FlattenRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	req.Bot = strings.TrimSpace(req.Bot)
+	var bots []string
+	if req.Bot != "" {
+		bots = []string{req.Bot}
+	}
+	accounts, err := h.flattenAccounts(req.Bot, req.Account)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidField, req.Bot, err.Error())
+		return
+	}
+
+	// Halt first so that no alert reopens a position while flattening
+	halts, haltErr := h.riskGuard.Halt(bots, risk.ModeHalt, "flattened")
+	if haltErr != nil {
+		h.logger.Error("failed to save kill switch state", zap.Error(haltErr))
+	}
+
+	resp := FlattenResponse{Bot: req.Bot, Halts: halts, Results: []FlattenResult{}}
+	for _, account := range accounts {
+		resp.Results = append(resp.Results, h.flattenAccount(account, req.Bot)...)
+	}
+
+	var cancelled, closed, failed int
+	var failures []string
+	for _, res := range resp.Results {
+		switch {
+		case res.Error != "":
+			failed++
+			failures = append(failures, fmt.Sprintf("%s %s %s: %s", res.Action, res.Account, res.Symbol, res.Error))
+		case res.Action == flattenCancel:
+			cancelled++
+		case res.Action == flattenClose:
+			closed++
+		}
+	}
+	h.logger.Warn("flattened via admin API",
+		zap.String("bot", req.Bot),
+		zap.String("account", req.Account),
+		zap.Int("cancelled", cancelled),
+		zap.Int("closed", closed),
+		zap.Int("failed", failed),
+		zap.String("remote_addr", r.RemoteAddr))
+
+	if h.notifier != nil {
+		msg := fmt.Sprintf("Flatten of %s: cancelled %d orders, closed %d positions, %d failed; trading halted",
+			botList(bots), cancelled, closed, failed)
+		if haltErr != nil {
+			msg += " (halt not saved, will not survive a restart)"
+		}
+		for _, f := range failures {
+			msg += "\n" + f
+		}
+		h.notifier.SendMessage(msg)
+	}
+
+	status := http.StatusOK
+	if failed > 0 || haltErr != nil {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// flattenAccounts returns the accounts a flatten of bot, or of every bot
+// when bot is empty, acts on, narrowed to account when it is set.
+func (h *HookHandler) flattenAccounts(bot, account string) ([]namedBroker, error) {
+	if h.routing == nil {
+		if account != "" {
+			return nil, errors.New("account routing is not configured")
+		}
+		return []namedBroker{{broker: h.broker}}, nil
+	}
+
+	accounts := h.orderAccounts()
+	if bot != "" {
+		var names []string
+		if b, ok := h.routing.Bots[bot]; ok {
+			names = append(names, b.Account)
+			names = append(names, b.AllowedAccounts...)
+			for _, t := range b.Fanout {
+				names = append(names, t.Account)
+			}
+		} else if h.routing.DefaultAccount != "" {
+			names = append(names, h.routing.DefaultAccount)
+		} else {
+			return nil, fmt.Errorf("bot %q has no account", bot)
+		}
+		accounts = filterAccounts(accounts, names)
+	}
+	if account != "" {
+		accounts = filterAccounts(accounts, []string{account})
+		if len(accounts) == 0 {
+			if bot == "" {
+				return nil, fmt.Errorf("account %q is not defined", account)
+			}
+			return nil, fmt.Errorf("account %q is not traded by bot %s", account, bot)
+		}
+	}
+	return accounts, nil
+}
+
+// Cancelled orders are polled every cancelPoll for up to cancelWait before
+// positions are closed, since a broker may process cancels asynchronously
+// and refuse to sell shares an open order still holds.
+var (
+	cancelWait = 10 * time.Second
+	cancelPoll = 250 * time.Millisecond
+)
+
+// finalStatuses are the order statuses after which an order holds nothing.
+var finalStatuses = map[string]bool{
+	"filled":   true,
+	"canceled": true,
+	"expired":  true,
+	"rejected": true,
+	"replaced": true,
+}
+
+// flattenAccount cancels the open orders placed by bot, with their legs, or
+// every open order when bot is empty, and then closes the positions in
+// account: the part of each that bot's orders opened, or all of them when
+// bot is empty.
+func (h *HookHandler) flattenAccount(account namedBroker, bot string) []FlattenResult {
+	var results []FlattenResult
+
+	// The bot's order history attributes the legs the broker named itself
+	// and the positions its fills opened
+	var history []alpaca.Order
+	if bot != "" {
+		var err error
+		if history, err = account.broker.ListOrdersSince(time.Time{}); err != nil {
+			h.logger.Error("failed to list orders", zap.Error(err), zap.String("account", account.name))
+			return append(results,
+				FlattenResult{Account: account.name, Action: flattenCancel, Error: err.Error()},
+				FlattenResult{Account: account.name, Action: flattenClose, Error: err.Error()})
+		}
+	}
+	legs := botLegs(history, bot)
+
+	orders, err := account.broker.ListOpenOrders()
+	if err != nil {
+		h.logger.Error("failed to list open orders", zap.Error(err), zap.String("account", account.name))
+		results = append(results, FlattenResult{Account: account.name, Action: flattenCancel, Error: err.Error()})
+	}
+	var cancelled []string
+	cancel := func(order alpaca.Order) {
+		res := FlattenResult{Account: account.name, Symbol: order.Symbol, Action: flattenCancel, OrderID: order.ID}
+		if err := account.broker.CancelOrder(order.ID); err != nil {
+			h.logger.Error("failed to cancel order",
+				zap.Error(err),
+				zap.String("account", account.name),
+				zap.String("orderID", order.ID))
+			res.Error = err.Error()
+		} else {
+			cancelled = append(cancelled, order.ID)
+		}
+		results = append(results, res)
+	}
+	for _, order := range orders {
+		owned := bot == "" || legs[order.ID]
+		if owner, ok := adapter.ClientOrderBot(order.ClientOrderID); ok && owner == bot {
+			owned = true
+		}
+		if !owned {
+			continue
+		}
+		// Cancelling an open parent cancels its legs; once it has filled,
+		// the legs that still hold the position are cancelled one by one
+		if !finalStatuses[order.Status] {
+			cancel(order)
+			continue
+		}
+		for _, leg := range order.Legs {
+			if !finalStatuses[leg.Status] {
+				cancel(leg)
+			}
+		}
+	}
+	if pending := awaitCancels(account.broker, cancelled); len(pending) > 0 {
+		h.logger.Warn("cancels still pending, closing anyway",
+			zap.String("account", account.name),
+			zap.Strings("orderIDs", pending))
+	}
+
+	positions, err := account.broker.ListPositions()
+	if err != nil {
+		h.logger.Error("failed to list positions", zap.Error(err), zap.String("account", account.name))
+		results = append(results, FlattenResult{Account: account.name, Action: flattenClose, Error: err.Error()})
+		return results
+	}
+	held := botHoldings(history, bot)
+	for _, pos := range positions {
+		qty := pos.Qty
+		if bot != "" {
+			// Close no more than the bot holds, and nothing it is not in
+			own := held[strings.ReplaceAll(pos.Symbol, "/", "")]
+			if own.Sign() != qty.Sign() {
+				continue
+			}
+			if own.Abs().LessThan(qty.Abs()) {
+				qty = own
+			}
+		}
+		res := FlattenResult{Account: account.name, Symbol: pos.Symbol, Action: flattenClose, Qty: &qty}
+		var order *alpaca.Order
+		if bot == "" {
+			order, err = account.broker.ClosePosition(pos.Symbol, decimal.NewFromInt(100))
+		} else {
+			order, err = account.broker.PlaceOrder(closingOrder(bot, pos.Symbol, qty))
+		}
+		switch {
+		case errors.Is(err, adapter.ErrNoPosition):
+			// Closed in the meantime
+		case err != nil:
+			h.logger.Error("failed to close position",
+				zap.Error(err),
+				zap.String("account", account.name),
+				zap.String("symbol", pos.Symbol))
+			res.Error = err.Error()
+		default:
+			res.OrderID = order.ID
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// awaitCancels polls broker until the orders in ids reach a final status
+// or cancelWait has passed, and returns the IDs of those still pending.
+// Orders the broker no longer knows count as final.
+func awaitCancels(broker adapter.Broker, ids []string) []string {
+	deadline := time.Now().Add(cancelWait)
+	for {
+		var pending []string
+		for _, id := range ids {
+			order, err := broker.GetOrder(id)
+			if errors.Is(err, adapter.ErrOrderNotFound) || (err == nil && finalStatuses[order.Status]) {
+				continue
+			}
+			pending = append(pending, id)
+		}
+		if len(pending) == 0 || !time.Now().Before(deadline) {
+			return pending
+		}
+		ids = pending
+		time.Sleep(cancelPoll)
+	}
+}
+
+// closingOrder returns the market order that closes qty, a signed
+// position, on behalf of bot.
+func closingOrder(bot, symbol string, qty decimal.Decimal) adapter.OrderRequest {
+	side := "sell"
+	if qty.IsNegative() {
+		side = "buy"
+	}
+	return adapter.OrderRequest{Bot: bot, Symbol: symbol, Side: side, Qty: qty.Abs(), Type: orderMarket}
+}
+
+// botHoldings returns the net quantity bot's filled orders in history
+// have bought or sold short in each symbol, keyed by the symbol without
+// the slash of crypto pairs.
+func botHoldings(history []alpaca.Order, bot string) map[string]decimal.Decimal {
+	held := make(map[string]decimal.Decimal)
+	var fill func(o alpaca.Order)
+	fill = func(o alpaca.Order) {
+		if o.FilledQty.IsPositive() {
+			symbol := strings.ReplaceAll(o.Symbol, "/", "")
+			if o.Side == alpaca.Sell {
+				held[symbol] = held[symbol].Sub(o.FilledQty)
+			} else {
+				held[symbol] = held[symbol].Add(o.FilledQty)
+			}
+		}
+		for _, leg := range o.Legs {
+			fill(leg)
+		}
+	}
+	for _, o := range history {
+		if owner, ok := adapter.ClientOrderBot(o.ClientOrderID); ok && owner == bot {
+			fill(o)
+		}
+	}
+	return held
+}
+
+// botLegs returns the IDs of the legs nested under bot's orders in
+// history. The broker names legs itself, so their client order IDs do not
+// carry the bot.
+func botLegs(history []alpaca.Order, bot string) map[string]bool {
+	legs := make(map[string]bool)
+	var add func(o alpaca.Order)
+	add = func(o alpaca.Order) {
+		for _, leg := range o.Legs {
+			legs[leg.ID] = true
+			add(leg)
+		}
+	}
+	for _, o := range history {
+		if owner, ok := adapter.ClientOrderBot(o.ClientOrderID); ok && owner == bot {
+			add(o)
+		}
+	}
+	return legs
+}
+
+// filterAccounts returns the accounts named in names, keeping their order.
+func filterAccounts(accounts []namedBroker, names []string) []namedBroker {
+	var out []namedBroker
+	for _, a := range accounts {
+		for _, name := range names {
+			if a.name == name {
+				out = append(out, a)
+				break
+			}
+		}
+	}
+	return out
+}
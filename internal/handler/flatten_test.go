@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// newFlattenBroker returns a simulator where bots b and c each hold a
+// position and have a resting limit order, and bot b-2 holds part of the
+// AAPL position.
+func newFlattenBroker(t *testing.T) *adapter.SimBroker {
+	t.Helper()
+	s, err := adapter.NewSimBroker(decimal.NewFromInt(100000), "")
+	if err != nil {
+		t.Fatalf("NewSimBroker: %v", err)
+	}
+	s.SetPriceSource(adapter.StaticPrices{"AAPL": decimal.NewFromInt(100), "MSFT": decimal.NewFromInt(200)})
+	limit := decimal.NewFromInt(50)
+	for _, req := range []adapter.OrderRequest{
+		{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)},
+		{Bot: "c", Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(5)},
+		{Bot: "b-2", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(4)},
+		{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1), Type: "limit", LimitPrice: &limit},
+		{Bot: "c", Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1), Type: "limit", LimitPrice: &limit},
+	} {
+		if _, err := s.PlaceOrder(req); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+	}
+	return s
+}
+
+func TestAdminFlattenBot(t *testing.T) {
+	notifier, messages := newSlackRecorder(t)
+	broker := newFlattenBroker(t)
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, notifier, false, false, true)
+
+	rr := adminRequest(h, http.MethodPost, "/admin/flatten", `{"bot":"b"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var resp FlattenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Action != flattenCancel || resp.Results[0].Symbol != "AAPL" {
+		t.Fatalf("expected b's order cancelled and its AAPL closed, got %+v", resp.Results)
+	}
+	if res := resp.Results[1]; res.Action != flattenClose || !res.Qty.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("expected only b's 10 AAPL closed, got %+v", res)
+	}
+	if resp.Halts.Bots["b"].Mode != risk.ModeHalt || resp.Halts.All != nil {
+		t.Fatalf("expected bot b halted, got %+v", resp.Halts)
+	}
+
+	// Bots sharing the account keep their positions and orders
+	positions, _ := broker.ListPositions()
+	if len(positions) != 2 || !positions[0].Qty.Equal(decimal.NewFromInt(4)) || !positions[1].Qty.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("expected b-2's AAPL and c's MSFT left, got %+v", positions)
+	}
+	open, _ := broker.ListOpenOrders()
+	if len(open) != 1 || open[0].Symbol != "MSFT" {
+		t.Fatalf("expected only bot c's order left open, got %+v", open)
+	}
+	if m := messages(); len(m) != 1 || !strings.Contains(m[0], "Flatten of bot b: cancelled 1 orders, closed 1 positions, 0 failed") {
+		t.Fatalf("expected a flatten summary, got %v", m)
+	}
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected bot b to be halted, got %d", rr.Code)
+	}
+	if rr := postAlert(h, `{"bot":"c","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected bot c to trade, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestAdminFlattenAccounts(t *testing.T) {
+	small, large := newFlattenBroker(t), newFlattenBroker(t)
+	failing := &fakeBroker{err: errors.New("broker down")}
+	h := newFanoutHandler(nil, map[string]adapter.Broker{"small": small, "large": large, "fixed": failing})
+
+	if rr := adminRequest(h, http.MethodPost, "/admin/flatten", `{"account":"other"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown account, got %d", rr.Code)
+	}
+	if rr := adminRequest(h, http.MethodPost, "/admin/flatten", `{"bot":"unrouted"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bot without accounts, got %d", rr.Code)
+	}
+	if rr := adminRequest(h, http.MethodGet, "/admin/flatten", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rr.Code)
+	}
+
+	rr := adminRequest(h, http.MethodPost, "/admin/flatten", `{"account":"small"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if positions, _ := large.ListPositions(); len(positions) != 2 {
+		t.Fatalf("expected other accounts untouched, got %+v", positions)
+	}
+	if halts := h.riskGuard.Halts(); halts.All == nil {
+		t.Fatalf("expected every bot halted, got %+v", halts)
+	}
+
+	rr = adminRequest(h, http.MethodPost, "/admin/flatten", "")
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rr.Code, rr.Body)
+	}
+	var resp FlattenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	var failed []FlattenResult
+	for _, res := range resp.Results {
+		if res.Error != "" {
+			failed = append(failed, res)
+		}
+	}
+	if len(failed) != 2 || failed[0].Account != "fixed" {
+		t.Fatalf("expected listing failures for fixed, got %+v", failed)
+	}
+	if positions, _ := large.ListPositions(); len(positions) != 0 {
+		t.Fatalf("expected large flattened, got %+v", positions)
+	}
+}
+
+func TestFlattenAccountClosePosition(t *testing.T) {
+	broker := &fakeBroker{position: &alpaca.Position{Symbol: "AAPL", Qty: decimal.NewFromInt(3)}}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	results := h.flattenAccount(namedBroker{broker: broker}, "")
+	if len(results) != 1 || results[0].Action != flattenClose || results[0].Error != "" || !results[0].Qty.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+// legBroker lists open bracket orders the way Alpaca does, with the legs
+// nested under their parent and named by the broker, and cancels them
+// asynchronously.
+type legBroker struct {
+	*fakeBroker
+	open, history []alpaca.Order
+	cancelled     []string
+	polls         int
+}
+
+func (b *legBroker) ListOpenOrders() ([]alpaca.Order, error) { return b.open, nil }
+
+func (b *legBroker) ListOrdersSince(since time.Time) ([]alpaca.Order, error) { return b.history, nil }
+
+func (b *legBroker) CancelOrder(orderID string) error {
+	b.cancelled = append(b.cancelled, orderID)
+	return nil
+}
+
+func (b *legBroker) GetOrder(orderID string) (*alpaca.Order, error) {
+	// The first poll finds the cancel still pending
+	b.polls++
+	if b.polls == 1 {
+		return &alpaca.Order{ID: orderID, Status: "pending_cancel"}, nil
+	}
+	return &alpaca.Order{ID: orderID, Status: "canceled"}, nil
+}
+
+func TestFlattenAccountBracketLegs(t *testing.T) {
+	defer func(wait, poll time.Duration) { cancelWait, cancelPoll = wait, poll }(cancelWait, cancelPoll)
+	cancelWait, cancelPoll = time.Second, time.Millisecond
+
+	filled := alpaca.Order{
+		ID: "p1", ClientOrderID: "b-0123456789abcdef0123", Symbol: "AAPL", Side: alpaca.Buy,
+		Status: "filled", FilledQty: decimal.NewFromInt(10),
+		Legs: []alpaca.Order{
+			{ID: "l1", ClientOrderID: "6b5f3e2a-95c1-4f0e-9d0b-1c2d3e4f5a6b", Symbol: "AAPL", Side: alpaca.Sell, Status: "new"},
+			{ID: "l2", ClientOrderID: "0d7e1c4b-2a3f-4e5d-8c9b-7a6f5e4d3c2b", Symbol: "AAPL", Side: alpaca.Sell, Status: "held"},
+		},
+	}
+	other := alpaca.Order{
+		ID: "p2", ClientOrderID: "c-0123456789abcdef0123", Symbol: "MSFT", Side: alpaca.Buy,
+		Status: "filled", FilledQty: decimal.NewFromInt(5),
+		Legs: []alpaca.Order{
+			{ID: "l3", ClientOrderID: "9a8b7c6d-5e4f-4a3b-2c1d-0e9f8a7b6c5d", Symbol: "MSFT", Side: alpaca.Sell, Status: "new"},
+		},
+	}
+	broker := &legBroker{
+		fakeBroker: &fakeBroker{},
+		open:       []alpaca.Order{filled, other},
+		history:    []alpaca.Order{filled, other},
+	}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	results := h.flattenAccount(namedBroker{broker: broker}, "b")
+	if strings.Join(broker.cancelled, ",") != "l1,l2" {
+		t.Fatalf("expected only b's legs cancelled, got %v", broker.cancelled)
+	}
+	if broker.polls < 3 {
+		t.Fatalf("expected the pending cancel to be polled again, got %d polls", broker.polls)
+	}
+	for _, res := range results {
+		if res.Error != "" {
+			t.Fatalf("unexpected failure %+v", res)
+		}
+	}
+}
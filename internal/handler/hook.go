@@ -2,40 +2,94 @@ package handler
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 
 	"github.com/njdaniel/alertbridge/internal/adapter"
 	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/config"
+	"github.com/njdaniel/alertbridge/internal/dedup"
 	"github.com/njdaniel/alertbridge/internal/notify"
+	"github.com/njdaniel/alertbridge/internal/queue"
+	"github.com/njdaniel/alertbridge/internal/ratelimit"
 	"github.com/njdaniel/alertbridge/internal/risk"
 	"github.com/njdaniel/alertbridge/pkg/metrics"
 )
 
+// timestampHeader carries the alert's Unix timestamp in milliseconds. It
+// is signed together with the body.
+const timestampHeader = "X-TV-Timestamp"
+
 type AlertRequest struct {
-	Bot    string `json:"bot"`
-	Symbol string `json:"symbol"`
-	Side   string `json:"side"`
-	Qty    string `json:"qty"`
-	TS     int64  `json:"ts,omitempty"`
+	ID          string         `json:"id,omitempty"` // idempotency key, see alertID
+	Passphrase  string         `json:"passphrase,omitempty"`
+	Secret      string         `json:"secret,omitempty"` // alias of passphrase
+	Bot         string         `json:"bot"`
+	Symbol      string         `json:"symbol"`
+	Side        string         `json:"side"`
+	Qty         string         `json:"qty,omitempty"`
+	Notional    string         `json:"notional,omitempty"`
+	Type        string         `json:"type,omitempty"`
+	LimitPrice  string         `json:"limit_price,omitempty"`
+	StopPrice   string         `json:"stop_price,omitempty"`
+	TimeInForce string         `json:"time_in_force,omitempty"`
+	OrderClass  string         `json:"order_class,omitempty"`
+	TakeProfit  *TakeProfitLeg `json:"take_profit,omitempty"`
+	StopLoss    *StopLossLeg   `json:"stop_loss,omitempty"`
+	Price       string         `json:"price,omitempty"`    // reference price, e.g. {{close}}
+	Position    string         `json:"position,omitempty"` // target position: long, short or flat
+	Account     string         `json:"account,omitempty"`  // account override, must be allowed for the bot
+	DryRun      bool           `json:"dry_run,omitempty"`  // build and return the orders without placing them
+	TS          int64          `json:"ts,omitempty"`
 }
 
 type HookHandler struct {
 	logger        *zap.Logger
-	alpacaClient  *adapter.AlpacaClient
+	broker        adapter.Broker
 	riskGuard     *risk.Guard
 	tvSecret      []byte
 	notifier      *notify.SlackNotifier
 	notifySuccess bool
 	notifyFailure bool
 	fullLogging   bool // when true, log remote address and full request body
+
+	// Multi-account routing, nil when a single broker serves every bot
+	routing  *config.Config
+	accounts map[string]adapter.Broker
+
+	dedup dedup.Store // nil disables idempotent processing
+
+	maxAge  time.Duration // zero disables the timestamp freshness check
+	maxSkew time.Duration
+
+	passphrase  string                 // global body passphrase
+	credentials map[string]Credentials // per-bot keys, nil accepts any bot
+
+	botAllowedIPs map[string][]*net.IPNet // per-bot source allow-lists
+
+	globalLimit *ratelimit.Limiter
+	ipLimit     *ratelimit.Limiter
+	botLimit    *ratelimit.Limiter
+
+	maxBodyBytes int64 // zero or less disables the body size cap
+	strictJSON   bool  // reject unknown fields
+
+	queue    *queue.Queue       // nil processes alerts synchronously
+	outcomes *dedup.MemoryStore // outcomes of synchronous alerts for GET /orders
+
+	dryRunAll  bool            // no alert trades
+	dryRunBots map[string]bool // bots whose alerts never trade
 }
 
 func NewHookHandler(
 	logger *zap.Logger,
-	alpacaClient *adapter.AlpacaClient,
+	broker adapter.Broker,
 	riskGuard *risk.Guard,
 	tvSecret []byte,
 	notifier *notify.SlackNotifier,
@@ -45,150 +99,420 @@ func NewHookHandler(
 ) *HookHandler {
 	return &HookHandler{
 		logger:        logger,
-		alpacaClient:  alpacaClient,
+		broker:        broker,
 		riskGuard:     riskGuard,
 		tvSecret:      tvSecret,
 		notifier:      notifier,
 		notifySuccess: success,
 		notifyFailure: failure,
 		fullLogging:   fullLogging,
+		maxBodyBytes:  DefaultMaxBodyBytes,
+		outcomes:      dedup.NewMemoryStore(outcomeTTL),
 	}
 }
 
 // ServeHTTP implements http.Handler interface
 func (h *HookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	setRequestID(w, r)
 	if r.URL.Path != "/hook" {
-		http.NotFound(w, r)
+		writeError(w, http.StatusNotFound, CodeNotFound, "", "Not found")
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "", "Method not allowed")
 		return
 	}
 	h.Handle(w, r)
 }
 
 func (h *HookHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	// Read request body
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		fields := []zap.Field{zap.Error(err)}
-		if h.fullLogging {
-			fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
-		}
-		h.logger.Error("failed to read request body", fields...)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	requestID := setRequestID(w, r)
+
+	// Shed floods before doing any work
+	if !h.allowSource(w, r) {
+		return
+	}
+
+	// Read request body within the size limit
+	bodyBytes, ok := h.readBody(w, r)
+	if !ok {
 		return
 	}
 
 	// Log incoming request
-	reqFields := []zap.Field{zap.String("user_agent", r.UserAgent())}
+	reqFields := []zap.Field{zap.String("request_id", requestID), zap.String("user_agent", r.UserAgent())}
 	if h.fullLogging {
 		reqFields = append(reqFields,
 			zap.String("remote_addr", r.RemoteAddr),
-			zap.String("body", string(bodyBytes)))
+			zap.String("body", redactBody(bodyBytes)))
 	} else {
 		reqFields = append(reqFields, zap.Int("body_len", len(bodyBytes)))
 	}
 	h.logger.Info("received webhook request", reqFields...)
 
-	// Verify request signature when secret is provided
-	sig := r.Header.Get("X-TV-Signature")
-	if len(h.tvSecret) > 0 {
-		if sig == "" {
-			http.Error(w, "Missing signature", http.StatusUnauthorized)
-			return
-		}
-		if err := auth.VerifyHMAC(h.tvSecret, bodyBytes, sig); err != nil {
-			fields := []zap.Field{zap.Error(err), zap.String("signature", sig[:8]+"...")}
-			if h.fullLogging {
-				fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
-			}
-			h.logger.Error("invalid signature", fields...)
-			http.Error(w, "Invalid signature", http.StatusUnauthorized)
-			return
-		}
-	}
-
 	// Parse request body
-	var alert AlertRequest
-	if err := json.Unmarshal(bodyBytes, &alert); err != nil {
+	alert, err := h.decodeAlert(bodyBytes)
+	if err != nil {
 		fields := []zap.Field{zap.Error(err)}
 		if h.fullLogging {
-			fields = append(fields, zap.String("body", string(bodyBytes)))
+			fields = append(fields, zap.String("body", redactBody(bodyBytes)))
 		}
 		h.logger.Error("failed to decode request", fields...)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		// The sender is not authenticated yet, so the details are only logged
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "", "Invalid JSON body")
 		return
 	}
 
-	// Validate required fields
-	if alert.Bot == "" || alert.Symbol == "" || alert.Side == "" || alert.Qty == "" {
-		h.logger.Error("missing required fields",
-			zap.String("bot", alert.Bot),
-			zap.String("symbol", alert.Symbol),
-			zap.String("side", alert.Side),
-			zap.String("qty", alert.Qty))
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	// Verify the bot's source address, then its signature or passphrase
+	if !h.checkSource(w, r, alert) || !h.authenticate(w, r, bodyBytes, alert) {
 		return
 	}
+	alert.Passphrase, alert.Secret = "", ""
 
-	// Validate side
-	if alert.Side != "buy" && alert.Side != "sell" {
-		h.logger.Error("invalid side",
-			zap.String("side", alert.Side),
-			zap.String("bot", alert.Bot))
-		http.Error(w, "Invalid side", http.StatusBadRequest)
+	// Limit how fast each bot may send alerts
+	if !h.allowBot(w, r, alert) {
 		return
 	}
 
-	// Check risk rules
-	if err := h.riskGuard.Check(alert.Bot); err != nil {
-		h.logger.Error("risk check failed",
-			zap.Error(err),
-			zap.String("bot", alert.Bot))
-		if h.notifier != nil && h.notifyFailure {
-			if notifyErr := h.notifier.SendMessage("Risk check failed for bot " + alert.Bot + ": " + err.Error()); notifyErr != nil {
-				h.logger.Error("failed to send notification",
-					zap.Error(notifyErr),
-					zap.String("bot", alert.Bot))
-			}
+	// Reject stale and future-dated alerts so captured requests expire
+	if !h.checkFreshness(w, r, alert) {
+		return
+	}
+
+	// Replays of an already processed alert return the original response
+	if id := alertID(alert); id != "" && h.dedup != nil {
+		key := alert.Bot + ":" + id
+		if h.isDryRun(alert) {
+			// A rehearsal must not block the live alert
+			key += ":" + modeDryRun
 		}
-		http.Error(w, err.Error(), http.StatusForbidden)
+		rw, ok := h.claimAlert(w, alert, key)
+		if !ok {
+			return
+		}
+		defer h.finishAlert(rw, key)
+		w = rw
+	}
+
+	// In async mode the alert is validated, queued and processed by a worker
+	if h.queue != nil {
+		h.enqueue(w, alert)
+		return
+	}
+	if keys := outcomeKeys(alert); len(keys) > 0 {
+		rw := &recordingWriter{ResponseWriter: w}
+		started := time.Now()
+		h.process(rw, alert)
+		h.recordOutcome(rw, alert, requestID, started, keys)
+		return
+	}
+	h.process(w, alert)
+}
+
+// process executes a validated, authenticated alert and writes the
+// response.
+func (h *HookHandler) process(w http.ResponseWriter, alert AlertRequest) {
+	alert.DryRun = h.isDryRun(alert)
+
+	// Target-position alerts derive side and quantity from the current position
+	if alert.Position != "" {
+		h.handlePosition(w, alert)
+		return
+	}
+
+	orderReq, closing, closePercent, ok := h.prepareOrder(w, alert)
+	if !ok {
 		return
 	}
 
+	// Fan-out bots copy the order into each of their accounts
+	if targets := h.fanoutTargets(alert); len(targets) > 0 {
+		h.handleFanout(w, alert, targets, orderReq, closing, closePercent)
+		return
+	}
+
+	// Pick the account the alert trades
+	account, broker, ok := h.brokerFor(w, alert)
+	if !ok {
+		return
+	}
+
+	// Feed the reference price to brokers that simulate fills
+	if !h.observePrice(w, broker, alert) {
+		return
+	}
+
+	// Check risk rules, which may resize the order
+	intents, ok := h.checkRisk(w, alert, orderIntent(alert, account, broker, orderReq, closing))
+	if !ok {
+		return
+	}
+	if !closing {
+		orderReq = applyIntent(orderReq, intents[0])
+	}
+
 	// Create order
-	order, err := h.alpacaClient.CreateOrder(alert.Bot, alert.Symbol, alert.Side, alert.Qty)
+	var order *alpaca.Order
+	var err error
+	if closing {
+		order, err = h.closePosition(broker, alert, closePercent)
+	} else {
+		order, err = broker.PlaceOrder(orderReq)
+	}
 	if err != nil {
-		h.logger.Error("failed to create order",
+		// No order was placed, so the alert must not hold the bot's cooldown
+		h.riskGuard.Release(intents...)
+	}
+	if err != nil && closing && isCloseRejection(err) {
+		h.logger.Error("nothing to close",
 			zap.Error(err),
 			zap.String("bot", alert.Bot),
 			zap.String("symbol", alert.Symbol),
 			zap.String("side", alert.Side),
 			zap.String("qty", alert.Qty))
+		if h.notifier != nil && h.notifyFailure {
+			h.notifier.SendMessage("Close failed for bot " + alert.Bot + ": " + err.Error())
+		}
+		writeError(w, http.StatusUnprocessableEntity, CodeNothingToClose, alert.Bot, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to create order",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("symbol", alert.Symbol),
+			zap.String("side", alert.Side),
+			zap.String("qty", alert.Qty),
+			zap.String("notional", alert.Notional),
+			zap.String("type", orderReq.Type))
 		if h.notifier != nil && h.notifyFailure {
 			h.notifier.SendMessage("Order creation failed for bot " + alert.Bot + ": " + err.Error())
 		}
-		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, brokerErrorCode(err), alert.Bot, "Failed to create order")
 		return
 	}
 
 	// Increment metrics
-	metrics.OrderTotal.WithLabelValues(alert.Bot, alert.Side).Inc()
+	metrics.OrderTotal.WithLabelValues(alert.Bot, alert.Side, orderMode(alert)).Inc()
 
 	// Log success
-	h.logger.Info("order created successfully",
+	msg, note := "order created successfully", "Order created: "
+	if alert.DryRun {
+		msg, note = "would place order", "Would place: "
+	}
+	h.logger.Info(msg,
 		zap.String("bot", alert.Bot),
 		zap.String("symbol", alert.Symbol),
 		zap.String("side", alert.Side),
 		zap.String("qty", alert.Qty),
-		zap.String("order_id", order.ID))
+		zap.String("notional", alert.Notional),
+		zap.String("type", orderReq.Type),
+		zap.String("account", account),
+		zap.String("order_id", order.ID),
+		zap.Bool("dry_run", alert.DryRun))
 	if h.notifier != nil && h.notifySuccess {
-		h.notifier.SendMessage("Order created: " + alert.Bot + " " + alert.Side + " " + alert.Symbol + " qty " + alert.Qty)
+		h.notifier.SendMessage(note + alert.Bot + " " + alert.Side + " " + alert.Symbol + " " + sizeLabel(alert))
 	}
 
 	// Return success
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(order)
 }
+
+// prepareOrder validates an order alert and builds its order request, or
+// reports a closing alert and the percentage of the position it closes. It
+// writes a 400 response for an invalid alert and reports whether
+// processing may continue.
+func (h *HookHandler) prepareOrder(w http.ResponseWriter, alert AlertRequest) (adapter.OrderRequest, bool, decimal.Decimal, bool) {
+	// Validate required fields
+	if alert.Bot == "" || alert.Symbol == "" || alert.Side == "" || (alert.Qty == "" && alert.Notional == "") {
+		h.logger.Error("missing required fields",
+			zap.String("bot", alert.Bot),
+			zap.String("symbol", alert.Symbol),
+			zap.String("side", alert.Side),
+			zap.String("qty", alert.Qty),
+			zap.String("notional", alert.Notional))
+		writeError(w, http.StatusBadRequest, CodeMissingFields, alert.Bot, "Missing required fields")
+		return adapter.OrderRequest{}, false, decimal.Zero, false
+	}
+
+	// Validate side
+	if alert.Side != "buy" && alert.Side != "sell" {
+		h.logger.Error("invalid side",
+			zap.String("side", alert.Side),
+			zap.String("bot", alert.Bot))
+		writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, "Invalid side")
+		return adapter.OrderRequest{}, false, decimal.Zero, false
+	}
+
+	// Reject malformed symbols and sizes before any rule or broker sees them
+	if err := validateFields(alert); err != nil {
+		h.logger.Error("invalid alert",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("symbol", alert.Symbol),
+			zap.String("qty", alert.Qty),
+			zap.String("notional", alert.Notional))
+		writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, err.Error())
+		return adapter.OrderRequest{}, false, decimal.Zero, false
+	}
+
+	// qty "all" or "N%" closes part or all of the open position
+	closePercent, closing, err := parseClosePercent(alert.Qty)
+	if err == nil && closing {
+		err = validateClose(alert)
+	}
+	if err != nil {
+		h.logger.Error("invalid close request",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("qty", alert.Qty))
+		writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, err.Error())
+		return adapter.OrderRequest{}, false, decimal.Zero, false
+	}
+
+	// Validate order type, prices and time in force
+	var orderReq adapter.OrderRequest
+	if !closing {
+		orderReq, err = buildOrder(alert)
+		if err != nil {
+			h.logger.Error("invalid order",
+				zap.Error(err),
+				zap.String("bot", alert.Bot),
+				zap.String("type", alert.Type),
+				zap.String("order_class", alert.OrderClass))
+			writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, err.Error())
+			return adapter.OrderRequest{}, false, decimal.Zero, false
+		}
+	}
+
+	return orderReq, closing, closePercent, true
+}
+
+// SetFreshness rejects alerts whose timestamp is older than maxAge or more
+// than maxSkew in the future. The timestamp is taken from the signed
+// X-TV-Timestamp header, falling back to the ts field.
+func (h *HookHandler) SetFreshness(maxAge, maxSkew time.Duration) {
+	h.maxAge = maxAge
+	h.maxSkew = maxSkew
+}
+
+// checkFreshness enforces the freshness window set by SetFreshness and
+// writes a 401 response for a missing, stale or future-dated timestamp.
+// It reports whether processing may continue.
+func (h *HookHandler) checkFreshness(w http.ResponseWriter, r *http.Request, alert AlertRequest) bool {
+	if h.maxAge <= 0 {
+		return true
+	}
+
+	var ts time.Time
+	var err error
+	if v := r.Header.Get(timestampHeader); v != "" {
+		ts, err = auth.ParseTimestamp(v)
+	} else if alert.TS != 0 {
+		ts = auth.UnixTimestamp(alert.TS)
+	}
+	if err == nil {
+		err = auth.CheckFreshness(ts, time.Now(), h.maxAge, h.maxSkew)
+	}
+	if err == nil {
+		return true
+	}
+
+	reason := "invalid"
+	switch {
+	case errors.Is(err, auth.ErrMissingTimestamp):
+		reason = "missing"
+	case errors.Is(err, auth.ErrStaleTimestamp):
+		reason = "stale"
+	case errors.Is(err, auth.ErrFutureTimestamp):
+		reason = "future"
+	}
+	metrics.StaleAlertTotal.WithLabelValues(alert.Bot, reason).Inc()
+	fields := []zap.Field{zap.Error(err), zap.String("bot", alert.Bot), zap.String("reason", reason)}
+	if h.fullLogging {
+		fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
+	}
+	h.logger.Error("rejected alert timestamp", fields...)
+	writeError(w, http.StatusUnauthorized, CodeStaleAlert, alert.Bot, err.Error())
+	return false
+}
+
+// checkRisk runs the intents of alert through the risk guard and writes a
+// 403 response naming the rule that rejected it. It returns the intents as
+// modified by the rules and reports whether processing may continue.
+func (h *HookHandler) checkRisk(w http.ResponseWriter, alert AlertRequest, intents ...risk.Intent) ([]risk.Intent, bool) {
+	intents, err := h.riskGuard.Evaluate(intents...)
+	if err == nil {
+		return intents, true
+	}
+	rule := risk.RejectedBy(err)
+	metrics.RiskRejectedTotal.WithLabelValues(alert.Bot, rule).Inc()
+	h.logger.Error("risk check failed",
+		zap.Error(err),
+		zap.String("bot", alert.Bot),
+		zap.String("rule", rule))
+	if h.notifier != nil && h.notifyFailure {
+		if notifyErr := h.notifier.SendMessage("Risk check failed for bot " + alert.Bot + " (" + rule + "): " + err.Error()); notifyErr != nil {
+			h.logger.Error("failed to send notification",
+				zap.Error(notifyErr),
+				zap.String("bot", alert.Bot))
+		}
+	}
+	writeErrorResponse(w, http.StatusForbidden, ErrorResponse{
+		Code:    CodeRiskRejected,
+		Message: err.Error(),
+		Bot:     alert.Bot,
+		Rule:    rule,
+	})
+	return nil, false
+}
+
+// orderIntent describes req, or a close when closing, to the risk rules.
+func orderIntent(alert AlertRequest, account string, broker adapter.Broker, req adapter.OrderRequest, closing bool) risk.Intent {
+	in := risk.Intent{
+		Bot:     alert.Bot,
+		Account: account,
+		Broker:  broker,
+		Symbol:  alert.Symbol,
+		Side:    alert.Side,
+		Closing: closing,
+		DryRun:  alert.DryRun,
+	}
+	if closing {
+		in.Type = orderMarket
+		in.Price, _ = parseOptionalPositive("price", alert.Price)
+		return in
+	}
+	in.Qty, in.Notional, in.Type = req.Qty, req.Notional, req.Type
+	in.Price = req.LimitPrice
+	if in.Price == nil {
+		in.Price, _ = parseOptionalPositive("price", alert.Price)
+	}
+	return in
+}
+
+// applyIntent resizes req as a risk rule modified its intent.
+func applyIntent(req adapter.OrderRequest, in risk.Intent) adapter.OrderRequest {
+	req.Qty, req.Notional = in.Qty, in.Notional
+	return req
+}
+
+// observePrice validates the alert's reference price and passes it to
+// brokers that consume prices, such as the simulator. It writes a 400
+// response for an invalid price and reports whether processing may continue.
+func (h *HookHandler) observePrice(w http.ResponseWriter, broker adapter.Broker, alert AlertRequest) bool {
+	price, err := parseOptionalPositive("price", alert.Price)
+	if err != nil {
+		h.logger.Error("invalid price",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("price", alert.Price))
+		writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, err.Error())
+		return false
+	}
+	if ticker, ok := broker.(adapter.Ticker); ok && price != nil {
+		ticker.Tick(alert.Symbol, *price)
+	}
+	return true
+}
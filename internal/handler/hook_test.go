@@ -5,15 +5,25 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 
 	"github.com/njdaniel/alertbridge/internal/adapter"
 	"github.com/njdaniel/alertbridge/internal/auth"
 	"github.com/njdaniel/alertbridge/internal/risk"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
 )
 
 // sign calculates the TradingView HMAC signature used in tests.
@@ -68,11 +78,29 @@ func TestHandleCooldown(t *testing.T) {
 	}
 }
 
+func TestHandleCooldownReleasedOnOrderError(t *testing.T) {
+	broker := &fakeBroker{err: errors.New("broker down")}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("60"), nil, nil, true, true, true)
+
+	body := `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`
+	if rr := postAlert(h, body); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	// The failed alert placed nothing, so the retry is not held back
+	broker.err = nil
+	if rr := postAlert(h, body); rr.Code != http.StatusOK {
+		t.Fatalf("expected retry 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if rr := postAlert(h, body); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected cooldown 403, got %d", rr.Code)
+	}
+}
+
 func TestVerifyHMAC(t *testing.T) {
 	body := []byte("test")
 	secret := []byte("s")
 	sig := sign(string(secret), body)
-	if err := auth.VerifyHMAC(secret, body, sig); err != nil {
+	if err := auth.VerifyHMAC(secret, "", body, sig); err != nil {
 		t.Fatalf("expected valid signature, got %v", err)
 	}
 }
@@ -80,13 +108,13 @@ func TestVerifyHMAC(t *testing.T) {
 func TestVerifyHMACInvalid(t *testing.T) {
 	body := []byte("test")
 	secret := []byte("s")
-	if err := auth.VerifyHMAC(secret, body, "bad"); err == nil {
+	if err := auth.VerifyHMAC(secret, "", body, "bad"); err == nil {
 		t.Fatalf("expected error for invalid signature")
 	}
 }
 
 func TestVerifyHMACDisabled(t *testing.T) {
-	if err := auth.VerifyHMAC(nil, []byte("test"), "anything"); err != nil {
+	if err := auth.VerifyHMAC(nil, "", []byte("test"), "anything"); err != nil {
 		t.Fatalf("expected nil when secret empty, got %v", err)
 	}
 }
@@ -122,6 +150,76 @@ func TestHandleInvalidSignature(t *testing.T) {
 	}
 }
 
+func TestHandleSignedTimestamp(t *testing.T) {
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), []byte("s"), nil, true, true, true)
+	h.SetFreshness(time.Minute, 5*time.Second)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	req.Header.Set("X-TV-Timestamp", ts)
+	req.Header.Set("X-TV-Signature", sign("s", []byte(ts+"."+string(body))))
+	rr := httptest.NewRecorder()
+
+	h.Handle(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	// The same signature does not verify with a refreshed timestamp
+	req = httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	req.Header.Set("X-TV-Timestamp", strconv.FormatInt(time.Now().UnixMilli()+1, 10))
+	req.Header.Set("X-TV-Signature", sign("s", []byte(ts+"."+string(body))))
+	rr = httptest.NewRecorder()
+	h.Handle(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestHandleRejectsStaleAlert(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		header string
+		ts     int64
+		reason string
+	}{
+		{"missing", "", 0, "missing"},
+		{"stale body ts", "", now.Add(-time.Hour).UnixMilli(), "stale"},
+		{"stale header", strconv.FormatInt(now.Add(-time.Hour).UnixMilli(), 10), 0, "stale"},
+		{"future", "", now.Add(time.Hour).UnixMilli(), "future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+			h.SetFreshness(time.Minute, 5*time.Second)
+
+			body := fmt.Sprintf(`{"bot":"stale_bot","symbol":"AAPL","side":"buy","qty":"1","ts":%d}`, tt.ts)
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+			if tt.header != "" {
+				req.Header.Set("X-TV-Timestamp", tt.header)
+			}
+			before := testutil.ToFloat64(metrics.StaleAlertTotal.WithLabelValues("stale_bot", tt.reason))
+			rr := httptest.NewRecorder()
+
+			h.Handle(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d", rr.Code)
+			}
+			if len(broker.placed()) != 0 {
+				t.Fatal("expected no orders")
+			}
+			after := testutil.ToFloat64(metrics.StaleAlertTotal.WithLabelValues("stale_bot", tt.reason))
+			if after-before != 1 {
+				t.Fatalf("expected stale_alert_total{reason=%q} to increment", tt.reason)
+			}
+		})
+	}
+}
+
 func TestHandleInvalidJSON(t *testing.T) {
 	client := newTestAlpacaClient(t)
 	g := risk.NewGuard("0")
@@ -186,10 +284,80 @@ func TestHandleRiskFail(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
 	rr := httptest.NewRecorder()
 
+	before := testutil.ToFloat64(metrics.RiskRejectedTotal.WithLabelValues("b", "pnl"))
 	h.Handle(rr, req)
 	if rr.Code != http.StatusForbidden {
 		t.Fatalf("expected 403, got %d", rr.Code)
 	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Code != CodeRiskRejected || resp.Rule != "pnl" {
+		t.Fatalf("expected rejection by the pnl rule, got %+v", resp)
+	}
+	if diff := testutil.ToFloat64(metrics.RiskRejectedTotal.WithLabelValues("b", "pnl")) - before; diff != 1 {
+		t.Fatalf("expected rejection to be counted by rule, got %v", diff)
+	}
+}
+
+// ruleFunc adapts a function to risk.Rule.
+type ruleFunc struct {
+	name string
+	fn   func(risk.Intent) risk.Decision
+}
+
+func (r ruleFunc) Name() string                          { return r.name }
+func (r ruleFunc) Evaluate(in risk.Intent) risk.Decision { return r.fn(in) }
+
+func TestHandleRiskRuleModify(t *testing.T) {
+	broker := &fakeBroker{}
+	g := risk.NewGuard("0")
+	var seen risk.Intent
+	g.Register(ruleFunc{"halve", func(in risk.Intent) risk.Decision {
+		seen = in
+		in.Qty = in.Qty.Div(decimal.NewFromInt(2))
+		return risk.Modify(in, "halved")
+	}})
+	h := NewHookHandler(zap.NewNop(), broker, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"10","type":"limit","limit_price":"150"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if seen.Symbol != "AAPL" || seen.Side != "buy" || seen.Type != "limit" || seen.Price == nil || !seen.Price.Equal(decimal.NewFromInt(150)) || seen.Broker == nil {
+		t.Fatalf("expected the rule to see the order intent, got %+v", seen)
+	}
+	if orders := broker.placed(); len(orders) != 1 || !orders[0].Qty.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("expected the modified quantity to be placed, got %+v", orders)
+	}
+}
+
+func TestHandleRiskLimits(t *testing.T) {
+	max := decimal.NewFromInt(10)
+	limits := &risk.LimitTable{Symbols: map[string]risk.Limits{"AAPL": {MaxPosition: &max}}}
+	g := risk.NewGuard("0")
+	g.Register(risk.NewOrderLimit(limits))
+	g.Register(risk.NewPositionLimit(limits))
+	broker := &fakeBroker{position: &alpaca.Position{Symbol: "AAPL", Qty: decimal.NewFromInt(8), Side: "long"}}
+	h := NewHookHandler(zap.NewNop(), broker, g, nil, nil, true, true, true)
+
+	rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"5"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rr.Code, rr.Body)
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Rule != "max_position" {
+		t.Fatalf("expected max_position rejection, got %+v %v", resp, err)
+	}
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"2"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if orders := broker.placed(); len(orders) != 1 || !orders[0].Qty.Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("expected only the order within the limit, got %+v", orders)
+	}
 }
 
 func TestHandleOrderError(t *testing.T) {
@@ -211,3 +379,63 @@ func TestHandleOrderError(t *testing.T) {
 		t.Fatalf("expected 500, got %d", rr.Code)
 	}
 }
+
+func TestHandleLimitOrder(t *testing.T) {
+	client := newTestAlpacaClient(t)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","type":"limit","limit_price":"150.25","time_in_force":"gtc"}`)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	h.Handle(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestHandleLimitOrderMissingPrice(t *testing.T) {
+	client := newTestAlpacaClient(t)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","type":"limit"}`)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	h.Handle(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestHandleNotionalOrder(t *testing.T) {
+	client := newTestAlpacaClient(t)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"BTC/USD","side":"buy","notional":"100"}`)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	h.Handle(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestHandleQtyAndNotional(t *testing.T) {
+	client := newTestAlpacaClient(t)
+	g := risk.NewGuard("0")
+	h := NewHookHandler(zap.NewNop(), client, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","notional":"100"}`)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	h.Handle(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
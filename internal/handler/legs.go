@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// Supported order classes.
+const (
+	classSimple  = "simple"
+	classBracket = "bracket"
+	classOCO     = "oco"
+	classOTO     = "oto"
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// TakeProfitLeg sets the take-profit exit of an advanced order. Exactly one
+// of LimitPrice, Percent or Offset must be set; Percent and Offset are
+// measured from the entry price.
+type TakeProfitLeg struct {
+	LimitPrice string `json:"limit_price,omitempty"`
+	Percent    string `json:"percent,omitempty"`
+	Offset     string `json:"offset,omitempty"`
+}
+
+// StopLossLeg sets the stop-loss exit of an advanced order. Exactly one of
+// StopPrice, Percent or Offset must be set. LimitPrice is optional and turns
+// the stop into a stop-limit.
+type StopLossLeg struct {
+	StopPrice  string `json:"stop_price,omitempty"`
+	LimitPrice string `json:"limit_price,omitempty"`
+	Percent    string `json:"percent,omitempty"`
+	Offset     string `json:"offset,omitempty"`
+}
+
+// applyLegs resolves the take-profit and stop-loss legs of alert into
+// absolute prices on req and checks they are consistent with the order
+// class and sit on the correct side of the entry.
+func applyLegs(alert AlertRequest, class string, req *adapter.OrderRequest) error {
+	tp, sl := alert.TakeProfit, alert.StopLoss
+
+	switch class {
+	case classSimple:
+		if tp != nil || sl != nil {
+			return errors.New("take_profit and stop_loss require order_class bracket, oco or oto")
+		}
+		return nil
+	case classBracket, classOCO:
+		if tp == nil || sl == nil {
+			return fmt.Errorf("%s orders require take_profit and stop_loss", class)
+		}
+	case classOTO:
+		if (tp == nil) == (sl == nil) {
+			return errors.New("oto orders require exactly one of take_profit or stop_loss")
+		}
+	}
+	req.OrderClass = class
+
+	// An OCO order is the exit for an existing position, so its side is
+	// the opposite of the position being protected.
+	long := req.Side == "buy"
+	if class == classOCO {
+		long = !long
+	}
+
+	ref, err := entryReference(alert, req, class)
+	if err != nil {
+		return err
+	}
+
+	if tp != nil {
+		req.TakeProfitLimitPrice, err = resolveLeg("take_profit", "limit_price",
+			tp.LimitPrice, tp.Percent, tp.Offset, ref, long)
+		if err != nil {
+			return err
+		}
+		if ref != nil && !beyond(*req.TakeProfitLimitPrice, *ref, long) {
+			return fmt.Errorf("take_profit must be %s the entry price", direction(long))
+		}
+	}
+
+	if sl != nil {
+		req.StopLossStopPrice, err = resolveLeg("stop_loss", "stop_price",
+			sl.StopPrice, sl.Percent, sl.Offset, ref, !long)
+		if err != nil {
+			return err
+		}
+		if ref != nil && !beyond(*req.StopLossStopPrice, *ref, !long) {
+			return fmt.Errorf("stop_loss must be %s the entry price", direction(!long))
+		}
+		if req.StopLossLimitPrice, err = parseOptionalPositive("stop_loss.limit_price", sl.LimitPrice); err != nil {
+			return err
+		}
+		// The stop-loss limit may trail the stop but must not be better than it.
+		if req.StopLossLimitPrice != nil && beyond(*req.StopLossLimitPrice, *req.StopLossStopPrice, long) {
+			return fmt.Errorf("stop_loss.limit_price must not be %s stop_price", direction(long))
+		}
+	}
+
+	if req.TakeProfitLimitPrice != nil && req.StopLossStopPrice != nil &&
+		!beyond(*req.TakeProfitLimitPrice, *req.StopLossStopPrice, long) {
+		return fmt.Errorf("take_profit must be %s stop_loss", direction(long))
+	}
+	return nil
+}
+
+// entryReference returns the price legs are measured from: the order's
+// limit or stop price, or the alert's reference price for market entries
+// and OCO exits. It returns nil when no price is known.
+func entryReference(alert AlertRequest, req *adapter.OrderRequest, class string) (*decimal.Decimal, error) {
+	if class != classOCO {
+		if req.LimitPrice != nil {
+			return req.LimitPrice, nil
+		}
+		if req.StopPrice != nil {
+			return req.StopPrice, nil
+		}
+	}
+	return parseOptionalPositive("price", alert.Price)
+}
+
+// resolveLeg converts an absolute, percent or offset leg into an absolute
+// price. up reports whether the leg sits above the reference price.
+func resolveLeg(leg, absField, abs, percent, offset string, ref *decimal.Decimal, up bool) (*decimal.Decimal, error) {
+	set := 0
+	for _, v := range []string{abs, percent, offset} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("%s requires exactly one of %s, percent or offset", leg, absField)
+	}
+
+	if abs != "" {
+		return parsePositive(leg+"."+absField, abs)
+	}
+
+	if ref == nil {
+		return nil, fmt.Errorf("%s percent and offset require limit_price, stop_price or price", leg)
+	}
+
+	var delta decimal.Decimal
+	if percent != "" {
+		p, err := parsePositive(leg+".percent", percent)
+		if err != nil {
+			return nil, err
+		}
+		delta = ref.Mul(*p).Div(hundred)
+	} else {
+		o, err := parsePositive(leg+".offset", offset)
+		if err != nil {
+			return nil, err
+		}
+		delta = *o
+	}
+
+	price := ref.Sub(delta)
+	if up {
+		price = ref.Add(delta)
+	}
+	price = roundPrice(price)
+	if !price.IsPositive() {
+		return nil, fmt.Errorf("%s resolves to a non-positive price", leg)
+	}
+	return &price, nil
+}
+
+// roundPrice rounds to the tick size Alpaca accepts: cents at or above one
+// dollar and hundredths of a cent below.
+func roundPrice(d decimal.Decimal) decimal.Decimal {
+	if d.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+		return d.Round(2)
+	}
+	return d.Round(4)
+}
+
+// beyond reports whether price is strictly above ref when up is true, or
+// strictly below it otherwise.
+func beyond(price, ref decimal.Decimal, up bool) bool {
+	if up {
+		return price.GreaterThan(ref)
+	}
+	return price.LessThan(ref)
+}
+
+func direction(up bool) string {
+	if up {
+		return "above"
+	}
+	return "below"
+}
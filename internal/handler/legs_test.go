@@ -0,0 +1,106 @@
+package handler
+
+import "testing"
+
+func TestBuildOrderBracketPercent(t *testing.T) {
+	alert := AlertRequest{
+		Bot: "b", Symbol: "AAPL", Side: "buy", Qty: "1",
+		OrderClass: "bracket",
+		Price:      "100",
+		TakeProfit: &TakeProfitLeg{Percent: "5"},
+		StopLoss:   &StopLossLeg{Offset: "2.5", LimitPrice: "97"},
+	}
+	req, err := buildOrder(alert)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.OrderClass != "bracket" {
+		t.Fatalf("expected bracket, got %s", req.OrderClass)
+	}
+	if req.TakeProfitLimitPrice.String() != "105" {
+		t.Fatalf("expected take profit 105, got %s", req.TakeProfitLimitPrice)
+	}
+	if req.StopLossStopPrice.String() != "97.5" {
+		t.Fatalf("expected stop 97.5, got %s", req.StopLossStopPrice)
+	}
+	if req.StopLossLimitPrice.String() != "97" {
+		t.Fatalf("expected stop limit 97, got %s", req.StopLossLimitPrice)
+	}
+}
+
+func TestBuildOrderBracketSellFromLimit(t *testing.T) {
+	alert := AlertRequest{
+		Bot: "b", Symbol: "AAPL", Side: "sell", Qty: "1",
+		Type: "limit", LimitPrice: "200",
+		OrderClass: "bracket",
+		TakeProfit: &TakeProfitLeg{Percent: "10"},
+		StopLoss:   &StopLossLeg{Percent: "1"},
+	}
+	req, err := buildOrder(alert)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.TakeProfitLimitPrice.String() != "180" {
+		t.Fatalf("expected take profit 180, got %s", req.TakeProfitLimitPrice)
+	}
+	if req.StopLossStopPrice.String() != "202" {
+		t.Fatalf("expected stop 202, got %s", req.StopLossStopPrice)
+	}
+}
+
+func TestBuildOrderLegValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		alert   AlertRequest
+		wantErr bool
+	}{
+		{"bracket absolute", AlertRequest{Side: "buy", Type: "limit", LimitPrice: "100", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, false},
+		{"bracket market without reference", AlertRequest{Side: "buy", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, false},
+		{"bracket missing stop", AlertRequest{Side: "buy", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}}, true},
+		{"take profit below buy entry", AlertRequest{Side: "buy", Type: "limit", LimitPrice: "100", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "90"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"stop above buy entry", AlertRequest{Side: "buy", Type: "limit", LimitPrice: "100", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "105"}}, true},
+		{"take profit below stop without reference", AlertRequest{Side: "buy", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "90"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"stop limit above stop for long", AlertRequest{Side: "buy", Price: "100", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95", LimitPrice: "96"}}, true},
+		{"percent without reference", AlertRequest{Side: "buy", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{Percent: "5"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"two leg forms", AlertRequest{Side: "buy", Price: "100", OrderClass: "bracket",
+			TakeProfit: &TakeProfitLeg{Percent: "5", Offset: "1"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"stop percent below zero", AlertRequest{Side: "buy", Price: "100", OrderClass: "oto",
+			StopLoss: &StopLossLeg{Percent: "150"}}, true},
+		{"oto single leg", AlertRequest{Side: "buy", Price: "100", OrderClass: "oto",
+			StopLoss: &StopLossLeg{Percent: "2"}}, false},
+		{"oto both legs", AlertRequest{Side: "buy", Price: "100", OrderClass: "oto",
+			TakeProfit: &TakeProfitLeg{Percent: "5"}, StopLoss: &StopLossLeg{Percent: "2"}}, true},
+		{"oco exit for long", AlertRequest{Side: "sell", Price: "100", OrderClass: "oco",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, false},
+		{"oco wrong side", AlertRequest{Side: "buy", Price: "100", OrderClass: "oco",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"oco market", AlertRequest{Side: "sell", Type: "market", OrderClass: "oco",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"oco top level price", AlertRequest{Side: "sell", Type: "limit", LimitPrice: "110", OrderClass: "oco",
+			TakeProfit: &TakeProfitLeg{LimitPrice: "110"}, StopLoss: &StopLossLeg{StopPrice: "95"}}, true},
+		{"legs on simple order", AlertRequest{Side: "buy", TakeProfit: &TakeProfitLeg{LimitPrice: "110"}}, true},
+		{"unknown class", AlertRequest{Side: "buy", OrderClass: "trailing"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.alert
+			a.Bot, a.Symbol, a.Qty = "b", "AAPL", "1"
+			_, err := buildOrder(a)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
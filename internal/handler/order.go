@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// Supported order types.
+const (
+	orderMarket    = "market"
+	orderLimit     = "limit"
+	orderStop      = "stop"
+	orderStopLimit = "stop_limit"
+)
+
+var validTimeInForce = map[string]bool{
+	"day": true,
+	"gtc": true,
+	"opg": true,
+	"cls": true,
+	"ioc": true,
+	"fok": true,
+}
+
+// buildOrder validates the order fields of alert, including any advanced
+// order legs, and converts them into a broker order request. Side and
+// required fields are checked by the caller.
+func buildOrder(alert AlertRequest) (adapter.OrderRequest, error) {
+	req := adapter.OrderRequest{
+		Bot:           alert.Bot,
+		Symbol:        alert.Symbol,
+		Side:          alert.Side,
+		Type:          strings.ToLower(alert.Type),
+		ClientOrderID: clientOrderID(alert),
+	}
+
+	class := strings.ToLower(alert.OrderClass)
+	switch class {
+	case "":
+		class = classSimple
+	case classSimple, classBracket, classOCO, classOTO:
+	default:
+		return req, fmt.Errorf("invalid order_class %q", alert.OrderClass)
+	}
+
+	if req.Type == "" {
+		req.Type = orderMarket
+		if class == classOCO {
+			req.Type = orderLimit
+		}
+	}
+
+	// Orders are sized by exactly one of qty or notional
+	var err error
+	switch {
+	case alert.Qty != "" && alert.Notional != "":
+		return req, errors.New("qty and notional are mutually exclusive")
+	case alert.Notional != "":
+		if req.Notional, err = parsePositive("notional", alert.Notional); err != nil {
+			return req, err
+		}
+		if req.Type != orderMarket {
+			return req, errors.New("notional is only supported for market orders")
+		}
+		if class != classSimple {
+			return req, errors.New("notional is not supported with order_class legs")
+		}
+	default:
+		qty, err := parsePositive("qty", alert.Qty)
+		if err != nil {
+			return req, err
+		}
+		req.Qty = *qty
+	}
+
+	if alert.TimeInForce != "" {
+		tif := strings.ToLower(alert.TimeInForce)
+		if !validTimeInForce[tif] {
+			return req, fmt.Errorf("invalid time_in_force %q", alert.TimeInForce)
+		}
+		req.TimeInForce = tif
+	}
+
+	if req.LimitPrice, err = parseOptionalPositive("limit_price", alert.LimitPrice); err != nil {
+		return req, err
+	}
+	if req.StopPrice, err = parseOptionalPositive("stop_price", alert.StopPrice); err != nil {
+		return req, err
+	}
+
+	// OCO prices are carried entirely by the take-profit and stop-loss legs.
+	if class == classOCO {
+		if req.Type != orderLimit {
+			return req, errors.New("oco orders must be limit orders")
+		}
+		if req.LimitPrice != nil || req.StopPrice != nil {
+			return req, errors.New("oco orders take prices from take_profit and stop_loss")
+		}
+		return req, applyLegs(alert, class, &req)
+	}
+
+	needLimit, needStop := false, false
+	switch req.Type {
+	case orderMarket:
+	case orderLimit:
+		needLimit = true
+	case orderStop:
+		needStop = true
+	case orderStopLimit:
+		needLimit, needStop = true, true
+	default:
+		return req, fmt.Errorf("invalid type %q", alert.Type)
+	}
+
+	if needLimit && req.LimitPrice == nil {
+		return req, fmt.Errorf("limit_price is required for %s orders", req.Type)
+	}
+	if !needLimit && req.LimitPrice != nil {
+		return req, fmt.Errorf("limit_price is not allowed for %s orders", req.Type)
+	}
+	if needStop && req.StopPrice == nil {
+		return req, fmt.Errorf("stop_price is required for %s orders", req.Type)
+	}
+	if !needStop && req.StopPrice != nil {
+		return req, fmt.Errorf("stop_price is not allowed for %s orders", req.Type)
+	}
+
+	return req, applyLegs(alert, class, &req)
+}
+
+// parsePositive parses a strictly positive decimal field.
+func parsePositive(field, v string) (*decimal.Decimal, error) {
+	d, err := decimal.NewFromString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", field, v)
+	}
+	if !d.IsPositive() {
+		return nil, errors.New(field + " must be positive")
+	}
+	return &d, nil
+}
+
+// parseOptionalPositive is parsePositive for fields that may be omitted.
+func parseOptionalPositive(field, v string) (*decimal.Decimal, error) {
+	if v == "" {
+		return nil, nil
+	}
+	return parsePositive(field, v)
+}
+
+// sizeLabel describes how alert is sized, for notifications.
+func sizeLabel(alert AlertRequest) string {
+	if alert.Notional != "" {
+		return "notional " + alert.Notional
+	}
+	return "qty " + alert.Qty
+}
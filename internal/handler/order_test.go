@@ -0,0 +1,68 @@
+package handler
+
+import "testing"
+
+func TestBuildOrderValidation(t *testing.T) {
+	base := AlertRequest{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: "1"}
+
+	tests := []struct {
+		name    string
+		modify  func(a *AlertRequest)
+		wantErr bool
+	}{
+		{"market default", func(a *AlertRequest) {}, false},
+		{"limit", func(a *AlertRequest) { a.Type = "limit"; a.LimitPrice = "10" }, false},
+		{"limit upper case", func(a *AlertRequest) { a.Type = "LIMIT"; a.LimitPrice = "10" }, false},
+		{"stop", func(a *AlertRequest) { a.Type = "stop"; a.StopPrice = "9" }, false},
+		{"stop limit", func(a *AlertRequest) { a.Type = "stop_limit"; a.StopPrice = "9"; a.LimitPrice = "9.5" }, false},
+		{"limit without price", func(a *AlertRequest) { a.Type = "limit" }, true},
+		{"stop without price", func(a *AlertRequest) { a.Type = "stop" }, true},
+		{"stop limit without limit", func(a *AlertRequest) { a.Type = "stop_limit"; a.StopPrice = "9" }, true},
+		{"market with limit price", func(a *AlertRequest) { a.LimitPrice = "10" }, true},
+		{"limit with stop price", func(a *AlertRequest) { a.Type = "limit"; a.LimitPrice = "10"; a.StopPrice = "9" }, true},
+		{"unknown type", func(a *AlertRequest) { a.Type = "trailing" }, true},
+		{"negative price", func(a *AlertRequest) { a.Type = "limit"; a.LimitPrice = "-1" }, true},
+		{"bad price", func(a *AlertRequest) { a.Type = "limit"; a.LimitPrice = "abc" }, true},
+		{"time in force", func(a *AlertRequest) { a.TimeInForce = "IOC" }, false},
+		{"bad time in force", func(a *AlertRequest) { a.TimeInForce = "forever" }, true},
+		{"zero qty", func(a *AlertRequest) { a.Qty = "0" }, true},
+		{"bad qty", func(a *AlertRequest) { a.Qty = "ten" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := base
+			tt.modify(&a)
+			_, err := buildOrder(a)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildOrderNotional(t *testing.T) {
+	req, err := buildOrder(AlertRequest{Bot: "b", Symbol: "BTC/USD", Side: "buy", Notional: "250.50"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Notional == nil || req.Notional.String() != "250.5" {
+		t.Fatalf("expected notional 250.5, got %v", req.Notional)
+	}
+
+	invalid := []AlertRequest{
+		{Side: "buy", Qty: "1", Notional: "100"},
+		{Side: "buy", Notional: "-5"},
+		{Side: "buy", Notional: "100", Type: "limit", LimitPrice: "10"},
+		{Side: "buy", Notional: "100", OrderClass: "oto", Price: "10", StopLoss: &StopLossLeg{Percent: "1"}},
+	}
+	for i, a := range invalid {
+		a.Bot, a.Symbol = "b", "AAPL"
+		if _, err := buildOrder(a); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+}
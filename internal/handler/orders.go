@@ -0,0 +1,354 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/dedup"
+	"github.com/njdaniel/alertbridge/internal/queue"
+)
+
+// OrderStatus is the response of GET /orders/{id}.
+type OrderStatus struct {
+	ID      string         `json:"id,omitempty"` // queue job ID, empty for alerts not in the journal
+	Status  string         `json:"status"`       // queued, running, succeeded or failed, or the broker's order status
+	Alert   *AlertRequest  `json:"alert,omitempty"`
+	Risk    *RiskDecision  `json:"risk,omitempty"` // nil when the risk rules were not reached
+	Error   *ErrorResponse `json:"error,omitempty"`
+	Orders  []OrderState   `json:"orders"`
+	Created *time.Time     `json:"created,omitempty"`
+	Updated *time.Time     `json:"updated,omitempty"`
+}
+
+// RiskDecision reports whether the risk rules allowed an alert.
+type RiskDecision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"` // the rule that rejected the alert
+	Reason  string `json:"reason,omitempty"`
+}
+
+// OrderState is the latest known state of a broker order placed for an
+// alert.
+type OrderState struct {
+	Account        string           `json:"account,omitempty"`
+	OrderID        string           `json:"order_id"`
+	ClientOrderID  string           `json:"client_order_id,omitempty"`
+	Symbol         string           `json:"symbol"`
+	Side           string           `json:"side"`
+	Status         string           `json:"status"`
+	FilledQty      decimal.Decimal  `json:"filled_qty"`
+	FilledAvgPrice *decimal.Decimal `json:"filled_avg_price,omitempty"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Refreshed      bool             `json:"refreshed"` // false when the broker could not be asked
+}
+
+// OrdersHandler serves GET /orders/{id}, where id is a queue job ID, an
+// alert ID or a client order ID. Requests must carry token as a bearer
+// token.
+func (h *HookHandler) OrdersHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRequestID(w, r)
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "", "Method not allowed")
+			return
+		}
+		if err := auth.VerifyBearer(r, token); err != nil {
+			h.logger.Warn("rejected order lookup",
+				zap.Error(err),
+				zap.String("remote_addr", r.RemoteAddr))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "", "Unauthorized")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/orders/")
+		if id == "" || strings.Contains(id, "/") {
+			writeError(w, http.StatusNotFound, CodeNotFound, "", "Not found")
+			return
+		}
+
+		status, ok := h.lookupOrder(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, CodeNotFound, "", "No alert or order with ID "+id)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// outcomeTTL is how long the outcomes of synchronously processed alerts
+// can be looked up.
+const outcomeTTL = 24 * time.Hour
+
+// outcomeKeys returns the IDs an alert's outcome is found by: its alert ID
+// and client order ID. It is empty for alerts without an ID.
+func outcomeKeys(alert AlertRequest) []string {
+	cid := clientOrderID(alert)
+	if cid == "" {
+		return nil
+	}
+	if alert.ID == "" {
+		return []string{cid}
+	}
+	return []string{alert.ID, cid}
+}
+
+// recordOutcome stores the response rw recorded for a synchronously
+// processed alert under keys, in the shape of a finished queue job.
+func (h *HookHandler) recordOutcome(rw *recordingWriter, alert AlertRequest, requestID string, created time.Time, keys []string) {
+	code := rw.status
+	if code == 0 {
+		code = http.StatusOK
+	}
+	job := queue.Job{
+		Bot:       alert.Bot,
+		RequestID: requestID,
+		Status:    queue.StatusFailed,
+		Created:   created,
+		Updated:   time.Now(),
+		Code:      code,
+		Keys:      keys,
+	}
+	if code >= 200 && code < 300 {
+		job.Status = queue.StatusSucceeded
+	}
+	job.Payload, _ = json.Marshal(alert)
+	if body := rw.body.Bytes(); json.Valid(body) {
+		job.Result = json.RawMessage(body)
+	}
+	record, err := json.Marshal(job)
+	if err != nil {
+		h.logger.Error("failed to record alert outcome", zap.Error(err), zap.String("bot", alert.Bot))
+		return
+	}
+	for _, key := range keys {
+		h.outcomes.Complete(key, dedup.Response{Status: code, Body: record})
+	}
+}
+
+// findJob returns the queued job or synchronous outcome found by id: a job
+// ID, an alert ID or a client order ID, including those of the numbered
+// steps of a position change.
+func (h *HookHandler) findJob(id string) (queue.Job, bool) {
+	keys := []string{id}
+	if i := strings.LastIndex(id, "-"); i > 0 && isStep(id[i+1:]) {
+		keys = append(keys, id[:i])
+	}
+	for _, key := range keys {
+		if h.queue != nil {
+			if job, ok := h.queue.FindKey(key); ok {
+				return job, true
+			}
+		}
+		if resp, ok := h.outcomes.Lookup(key); ok {
+			var job queue.Job
+			if json.Unmarshal(resp.Body, &job) == nil {
+				return job, true
+			}
+		}
+	}
+	return queue.Job{}, false
+}
+
+// isStep reports whether s is the step number appended to the client
+// order IDs of a position change.
+func isStep(s string) bool {
+	if s == "" || len(s) > 3 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupOrder finds id among the queued jobs and the outcomes of
+// synchronous alerts, refreshing the orders found there from the broker,
+// or else asks the brokers for an order with id as its client order ID.
+func (h *HookHandler) lookupOrder(id string) (OrderStatus, bool) {
+	if job, ok := h.findJob(id); ok {
+		return h.jobStatus(job), true
+	}
+
+	var states []OrderState
+	for _, account := range h.orderAccounts() {
+		order, err := account.broker.GetOrderByClientOrderID(id)
+		if err != nil {
+			if !errors.Is(err, adapter.ErrOrderNotFound) {
+				h.logger.Error("order lookup failed",
+					zap.Error(err),
+					zap.String("account", account.name),
+					zap.String("client_order_id", id))
+			}
+			continue
+		}
+		state := orderState(account.name, order)
+		state.Refreshed = true
+		states = append(states, state)
+	}
+	if len(states) == 0 {
+		return OrderStatus{}, false
+	}
+	// Nothing is known of the alert, so the status is the broker's own
+	return OrderStatus{Status: states[0].Status, Orders: states}, true
+}
+
+// jobStatus describes job and the current state of the orders it placed.
+func (h *HookHandler) jobStatus(job queue.Job) OrderStatus {
+	created, updated := job.Created, job.Updated
+	status := OrderStatus{
+		ID:      job.ID,
+		Status:  job.Status,
+		Orders:  []OrderState{},
+		Created: &created,
+		Updated: &updated,
+	}
+	var alert AlertRequest
+	if json.Unmarshal(job.Payload, &alert) == nil {
+		status.Alert = &alert
+	}
+	if job.Status != queue.StatusSucceeded && job.Status != queue.StatusFailed {
+		return status
+	}
+
+	if job.Code >= 400 {
+		var resp ErrorResponse
+		if json.Unmarshal(job.Result, &resp) == nil && resp.Code != "" {
+			status.Error = &resp
+		}
+	}
+	status.Risk = riskDecision(job.Code, status.Error)
+
+	for _, state := range resultOrders(job.Result) {
+		// Dry-run orders never reached the broker
+		if state.Status == adapter.DryRunStatus {
+			status.Orders = append(status.Orders, state)
+			continue
+		}
+		if broker := h.orderBroker(alert, state.Account); broker != nil {
+			order, err := broker.GetOrder(state.OrderID)
+			if err == nil {
+				state = orderState(state.Account, order)
+				state.Refreshed = true
+			} else {
+				h.logger.Warn("failed to refresh order",
+					zap.Error(err),
+					zap.String("job_id", job.ID),
+					zap.String("order_id", state.OrderID))
+			}
+		}
+		status.Orders = append(status.Orders, state)
+	}
+	return status
+}
+
+// riskDecision derives the risk decision from the outcome of an alert.
+// Errors raised before the risk rules ran leave it unknown.
+func riskDecision(code int, resp *ErrorResponse) *RiskDecision {
+	if resp == nil {
+		if code >= 200 && code < 300 {
+			return &RiskDecision{Allowed: true}
+		}
+		return nil
+	}
+	switch resp.Code {
+	case CodeRiskRejected:
+		return &RiskDecision{Allowed: false, Rule: resp.Rule, Reason: resp.Message}
+	case CodeBrokerRejected, CodeBrokerError, CodeNothingToClose:
+		return &RiskDecision{Allowed: true}
+	}
+	return nil
+}
+
+// resultOrders extracts the orders from a stored response, which is an
+// order, a PositionResult or a FanoutResponse.
+func resultOrders(result json.RawMessage) []OrderState {
+	var shape struct {
+		ID      string          `json:"id"`
+		Status  string          `json:"status"`
+		Orders  []*alpaca.Order `json:"orders"`
+		Results []FanoutResult  `json:"results"`
+	}
+	if json.Unmarshal(result, &shape) != nil {
+		return nil
+	}
+
+	var states []OrderState
+	switch {
+	case shape.Results != nil:
+		for _, r := range shape.Results {
+			if r.Order != nil {
+				states = append(states, orderState(r.Account, r.Order))
+			}
+		}
+	case shape.Orders != nil:
+		for _, o := range shape.Orders {
+			states = append(states, orderState("", o))
+		}
+	case shape.ID != "" || shape.Status == adapter.DryRunStatus:
+		var order alpaca.Order
+		if json.Unmarshal(result, &order) == nil {
+			states = append(states, orderState("", &order))
+		}
+	}
+	return states
+}
+
+func orderState(account string, order *alpaca.Order) OrderState {
+	return OrderState{
+		Account:        account,
+		OrderID:        order.ID,
+		ClientOrderID:  order.ClientOrderID,
+		Symbol:         order.Symbol,
+		Side:           string(order.Side),
+		Status:         order.Status,
+		FilledQty:      order.FilledQty,
+		FilledAvgPrice: order.FilledAvgPrice,
+		UpdatedAt:      order.UpdatedAt,
+	}
+}
+
+// orderBroker returns the broker of the named account, or of the account
+// alert is routed to when account is empty.
+func (h *HookHandler) orderBroker(alert AlertRequest, account string) adapter.Broker {
+	if h.routing == nil {
+		return h.broker
+	}
+	if account == "" {
+		var err error
+		if account, err = h.routing.AccountFor(alert.Bot, alert.Account); err != nil {
+			return nil
+		}
+	}
+	return h.accounts[account]
+}
+
+type namedBroker struct {
+	name   string
+	broker adapter.Broker
+}
+
+// orderAccounts lists every broker orders may have been placed with.
+func (h *HookHandler) orderAccounts() []namedBroker {
+	if h.routing == nil {
+		return []namedBroker{{broker: h.broker}}
+	}
+	accounts := make([]namedBroker, 0, len(h.accounts))
+	for name, broker := range h.accounts {
+		accounts = append(accounts, namedBroker{name: name, broker: broker})
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].name < accounts[j].name })
+	return accounts
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/queue"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+// getOrder requests /orders/id with the test token.
+func getOrder(h *HookHandler, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/orders/"+id, nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	rr := httptest.NewRecorder()
+	h.OrdersHandler("t0ken").ServeHTTP(rr, req)
+	return rr
+}
+
+// runAsync queues body, processes it and returns the job ID.
+func runAsync(t *testing.T, h *HookHandler, q *queue.Queue, body string) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	var accepted AcceptedResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &accepted); err != nil || rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.RunWorkers(ctx, 1)
+	waitFinished(t, q, accepted.ID)
+	return accepted.ID
+}
+
+func TestOrdersLookupJournal(t *testing.T) {
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+	price := decimal.RequireFromString("190.25")
+	broker := &fakeBroker{lookup: func(id string) (*alpaca.Order, error) {
+		if id != "fake" {
+			return nil, adapter.ErrOrderNotFound
+		}
+		return &alpaca.Order{ID: "fake", Symbol: "AAPL", Side: "buy", Status: "filled",
+			FilledQty: decimal.NewFromInt(1), FilledAvgPrice: &price}, nil
+	}}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetQueue(q)
+	jobID := runAsync(t, h, q, `{"id":"sig-1","bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+
+	for _, id := range []string{jobID, "sig-1", clientOrderID(AlertRequest{Bot: "b", ID: "sig-1"})} {
+		rr := getOrder(h, id)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("lookup %s: expected 200, got %d: %s", id, rr.Code, rr.Body)
+		}
+		var status OrderStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if status.ID != jobID || status.Status != queue.StatusSucceeded || status.Alert == nil || status.Alert.Symbol != "AAPL" {
+			t.Fatalf("lookup %s: unexpected status %+v", id, status)
+		}
+		if status.Risk == nil || !status.Risk.Allowed {
+			t.Fatalf("lookup %s: expected allowed risk decision, got %+v", id, status.Risk)
+		}
+		if len(status.Orders) != 1 {
+			t.Fatalf("lookup %s: expected 1 order, got %d", id, len(status.Orders))
+		}
+		o := status.Orders[0]
+		if o.OrderID != "fake" || o.Status != "filled" || !o.Refreshed || o.FilledAvgPrice == nil || !o.FilledAvgPrice.Equal(price) {
+			t.Fatalf("lookup %s: expected refreshed fill, got %+v", id, o)
+		}
+	}
+}
+
+func TestOrdersLookupRiskRejection(t *testing.T) {
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+	guard := risk.NewGuard("60")
+	guard.Check("b")
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, guard, nil, nil, true, true, true)
+	h.SetQueue(q)
+	jobID := runAsync(t, h, q, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+
+	var status OrderStatus
+	json.Unmarshal(getOrder(h, jobID).Body.Bytes(), &status)
+	if status.Status != queue.StatusFailed || status.Risk == nil || status.Risk.Allowed || status.Risk.Rule != "cooldown" || status.Risk.Reason == "" {
+		t.Fatalf("expected rejected risk decision, got %+v %+v", status, status.Risk)
+	}
+	if status.Error == nil || status.Error.Code != CodeRiskRejected || len(status.Orders) != 0 {
+		t.Fatalf("expected risk error and no orders, got %+v", status)
+	}
+}
+
+func TestOrdersLookupBroker(t *testing.T) {
+	broker := &fakeBroker{lookup: func(id string) (*alpaca.Order, error) {
+		if id != "b-abc" {
+			return nil, adapter.ErrOrderNotFound
+		}
+		return &alpaca.Order{ID: "o1", ClientOrderID: "b-abc", Symbol: "AAPL", Side: "sell", Status: "new"}, nil
+	}}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	rr := getOrder(h, "b-abc")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var status OrderStatus
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if status.ID != "" || len(status.Orders) != 1 || status.Orders[0].OrderID != "o1" || status.Orders[0].Status != "new" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	// Only the broker knows of the order, so nothing is made up
+	if status.Status != "new" || status.Risk != nil || status.Alert != nil {
+		t.Fatalf("expected the broker's status and no risk decision, got %+v", status)
+	}
+
+	if rr := getOrder(h, "unknown"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown ID, got %d", rr.Code)
+	}
+}
+
+func TestOrdersLookupSync(t *testing.T) {
+	broker := &fakeBroker{lookup: func(id string) (*alpaca.Order, error) {
+		if id != "fake" {
+			return nil, adapter.ErrOrderNotFound
+		}
+		return &alpaca.Order{ID: "fake", Symbol: "AAPL", Side: "buy", Status: "filled"}, nil
+	}}
+	guard := risk.NewGuard("60")
+	h := NewHookHandler(zap.NewNop(), broker, guard, nil, nil, true, true, true)
+	if rr := postAlert(h, `{"id":"sig-1","bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	postAlert(h, `{"id":"sig-2","bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+
+	for _, id := range []string{"sig-1", clientOrderID(AlertRequest{Bot: "b", ID: "sig-1"})} {
+		var status OrderStatus
+		rr := getOrder(h, id)
+		json.Unmarshal(rr.Body.Bytes(), &status)
+		if rr.Code != http.StatusOK || status.Status != queue.StatusSucceeded || status.Alert == nil || status.Alert.ID != "sig-1" {
+			t.Fatalf("lookup %s: unexpected status %d %+v", id, rr.Code, status)
+		}
+		if status.Risk == nil || !status.Risk.Allowed || len(status.Orders) != 1 || status.Orders[0].Status != "filled" {
+			t.Fatalf("lookup %s: expected allowed, refreshed order, got %+v", id, status)
+		}
+	}
+
+	var status OrderStatus
+	json.Unmarshal(getOrder(h, "sig-2").Body.Bytes(), &status)
+	if status.Status != queue.StatusFailed || status.Risk == nil || status.Risk.Allowed || status.Risk.Rule != "cooldown" {
+		t.Fatalf("expected the cooldown rejection of sig-2, got %+v %+v", status, status.Risk)
+	}
+}
+
+func TestOrdersHandlerAuth(t *testing.T) {
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), nil, nil, true, true, true)
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		status int
+	}{
+		{"missing token", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "Bearer nope", http.StatusUnauthorized},
+		{"wrong method", http.MethodPost, "Bearer t0ken", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/orders/x", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rr := httptest.NewRecorder()
+			h.OrdersHandler("t0ken").ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rr.Code)
+			}
+		})
+	}
+}
+
+func TestResultOrders(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		ids    []string
+	}{
+		{"order", `{"id":"o1","symbol":"AAPL"}`, []string{"o1"}},
+		{"position", `{"symbol":"AAPL","current":"0","target":"2","orders":[{"id":"o1"},{"id":"o2"}]}`, []string{"o1", "o2"}},
+		{"fan-out", `{"results":[{"account":"a","order":{"id":"o1"}},{"account":"b","error":"rejected"}]}`, []string{"o1"}},
+		{"dry run", `{"id":"","symbol":"AAPL","status":"dry_run"}`, []string{""}},
+		{"error", `{"code":"risk_rejected","message":"cooldown"}`, nil},
+	}
+	for _, tt := range tests {
+		states := resultOrders(json.RawMessage(tt.result))
+		if len(states) != len(tt.ids) {
+			t.Fatalf("%s: expected %d orders, got %d", tt.name, len(tt.ids), len(states))
+		}
+		for i, id := range tt.ids {
+			if states[i].OrderID != id {
+				t.Fatalf("%s: expected order %s, got %s", tt.name, id, states[i].OrderID)
+			}
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/risk"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+// Target positions accepted in the position field.
+const (
+	positionLong  = "long"
+	positionShort = "short"
+	positionFlat  = "flat"
+)
+
+// PositionResult is the response to a target-position alert.
+type PositionResult struct {
+	Symbol  string          `json:"symbol"`
+	Current decimal.Decimal `json:"current"`
+	Target  decimal.Decimal `json:"target"`
+	Orders  []*alpaca.Order `json:"orders"`
+}
+
+// positionStep is one order needed to move towards the target position.
+type positionStep struct {
+	side string
+	qty  decimal.Decimal
+}
+
+// parseTarget validates a target-position alert and returns the signed
+// target quantity: positive for long, negative for short and zero for flat.
+func parseTarget(alert AlertRequest) (decimal.Decimal, error) {
+	if alert.Side != "" {
+		return decimal.Zero, errors.New("side must be omitted when position is set")
+	}
+	if alert.Notional != "" {
+		return decimal.Zero, errors.New("notional is not supported when position is set")
+	}
+	if (alert.Type != "" && !strings.EqualFold(alert.Type, orderMarket)) ||
+		alert.LimitPrice != "" || alert.StopPrice != "" ||
+		(alert.OrderClass != "" && !strings.EqualFold(alert.OrderClass, classSimple)) ||
+		alert.TakeProfit != nil || alert.StopLoss != nil {
+		return decimal.Zero, errors.New("position alerts only support simple market orders")
+	}
+	if alert.TimeInForce != "" && !validTimeInForce[strings.ToLower(alert.TimeInForce)] {
+		return decimal.Zero, fmt.Errorf("invalid time_in_force %q", alert.TimeInForce)
+	}
+
+	switch strings.ToLower(alert.Position) {
+	case positionFlat:
+		if alert.Qty != "" {
+			qty, err := decimal.NewFromString(alert.Qty)
+			if err != nil || !qty.IsZero() {
+				return decimal.Zero, errors.New("qty must be omitted or 0 for a flat position")
+			}
+		}
+		return decimal.Zero, nil
+	case positionLong, positionShort:
+		if alert.Qty == "" {
+			return decimal.Zero, fmt.Errorf("qty is required for a %s position", alert.Position)
+		}
+		qty, err := parsePositive("qty", alert.Qty)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if strings.EqualFold(alert.Position, positionShort) {
+			return qty.Neg(), nil
+		}
+		return *qty, nil
+	default:
+		return decimal.Zero, fmt.Errorf("invalid position %q", alert.Position)
+	}
+}
+
+// planPosition returns the orders that move current to target. A reversal
+// through zero is split into a closing order and an opening order, since
+// Alpaca does not flip a position in a single order.
+func planPosition(current, target decimal.Decimal) []positionStep {
+	if current.Equal(target) {
+		return nil
+	}
+	if current.Sign()*target.Sign() < 0 {
+		return append(planPosition(current, decimal.Zero), planPosition(decimal.Zero, target)...)
+	}
+	delta := target.Sub(current)
+	if delta.IsPositive() {
+		return []positionStep{{side: "buy", qty: delta}}
+	}
+	return []positionStep{{side: "sell", qty: delta.Neg()}}
+}
+
+// handlePosition processes an alert that states the desired position in
+// a symbol rather than an order, placing whatever orders close the gap.
+func (h *HookHandler) handlePosition(w http.ResponseWriter, alert AlertRequest) {
+	target, ok := h.preparePosition(w, alert)
+	if !ok {
+		return
+	}
+
+	account, broker, ok := h.brokerFor(w, alert)
+	if !ok || !h.observePrice(w, broker, alert) {
+		return
+	}
+
+	current := decimal.Zero
+	pos, err := broker.GetPosition(alert.Symbol)
+	switch {
+	case err == nil:
+		current = pos.Qty
+	case !errors.Is(err, adapter.ErrNoPosition):
+		h.logger.Error("failed to get position",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("symbol", alert.Symbol))
+		if h.notifier != nil && h.notifyFailure {
+			h.notifier.SendMessage("Position lookup failed for bot " + alert.Bot + ": " + err.Error())
+		}
+		writeError(w, http.StatusInternalServerError, brokerErrorCode(err), alert.Bot, "Failed to get position")
+		return
+	}
+
+	result := PositionResult{
+		Symbol:  alert.Symbol,
+		Current: current,
+		Target:  target,
+		Orders:  []*alpaca.Order{},
+	}
+
+	// Nothing to trade, so nothing for the risk rules to check or reserve
+	if current.Equal(target) {
+		h.logger.Info("position already at target",
+			zap.String("bot", alert.Bot),
+			zap.String("symbol", alert.Symbol),
+			zap.String("target", target.String()),
+			zap.String("account", account))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	// The risk rules see the net change, and may shrink it
+	in := positionIntent(alert, account, broker, current, target)
+	intents, ok := h.checkRisk(w, alert, in)
+	if !ok {
+		return
+	}
+	if !intents[0].Qty.Equal(in.Qty) {
+		delta := intents[0].Qty
+		if in.Side == "sell" {
+			delta = delta.Neg()
+		}
+		target = current.Add(delta)
+		result.Target = target
+	}
+
+	steps := planPosition(current, target)
+	if len(steps) == 0 {
+		// A rule shrank the change to nothing
+		h.riskGuard.Release(intents...)
+	}
+	for i, step := range steps {
+		req := adapter.OrderRequest{
+			Bot:         alert.Bot,
+			Symbol:      alert.Symbol,
+			Side:        step.side,
+			Qty:         step.qty,
+			Type:        orderMarket,
+			TimeInForce: strings.ToLower(alert.TimeInForce),
+		}
+		if id := clientOrderID(alert); id != "" {
+			req.ClientOrderID = fmt.Sprintf("%s-%d", id, i+1)
+		}
+		order, err := broker.PlaceOrder(req)
+		if err != nil {
+			if len(result.Orders) == 0 {
+				h.riskGuard.Release(intents...)
+			}
+			h.logger.Error("failed to create order",
+				zap.Error(err),
+				zap.String("bot", alert.Bot),
+				zap.String("symbol", alert.Symbol),
+				zap.String("side", step.side),
+				zap.String("qty", step.qty.String()),
+				zap.Int("orders_placed", len(result.Orders)))
+			if h.notifier != nil && h.notifyFailure {
+				h.notifier.SendMessage(fmt.Sprintf("Position update failed for bot %s after %d of %d orders: %s",
+					alert.Bot, len(result.Orders), len(steps), err.Error()))
+			}
+			writeError(w, http.StatusInternalServerError, brokerErrorCode(err), alert.Bot, "Failed to create order")
+			return
+		}
+		metrics.OrderTotal.WithLabelValues(alert.Bot, step.side, orderMode(alert)).Inc()
+		result.Orders = append(result.Orders, order)
+	}
+
+	msg, note := "position updated", "Position updated: "
+	if alert.DryRun {
+		msg, note = "would update position", "Would update position: "
+	}
+	h.logger.Info(msg,
+		zap.String("bot", alert.Bot),
+		zap.String("symbol", alert.Symbol),
+		zap.String("current", current.String()),
+		zap.String("target", target.String()),
+		zap.String("account", account),
+		zap.Int("orders", len(result.Orders)),
+		zap.Bool("dry_run", alert.DryRun))
+	if h.notifier != nil && h.notifySuccess && len(result.Orders) > 0 {
+		h.notifier.SendMessage(note + alert.Bot + " " + alert.Symbol + " " +
+			current.String() + " -> " + target.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// positionIntent describes the move from current to target to the risk
+// rules as a single market order for the difference.
+func positionIntent(alert AlertRequest, account string, broker adapter.Broker, current, target decimal.Decimal) risk.Intent {
+	in := risk.Intent{
+		Bot:     alert.Bot,
+		Account: account,
+		Broker:  broker,
+		Symbol:  alert.Symbol,
+		Type:    orderMarket,
+		DryRun:  alert.DryRun,
+	}
+	in.Price, _ = parseOptionalPositive("price", alert.Price)
+	delta := target.Sub(current)
+	switch {
+	case delta.IsPositive():
+		in.Side, in.Qty = "buy", delta
+	case delta.IsNegative():
+		in.Side, in.Qty = "sell", delta.Neg()
+	}
+	return in
+}
+
+// preparePosition validates a target-position alert and returns its signed
+// target quantity. It writes a 400 response for an invalid alert and
+// reports whether processing may continue.
+func (h *HookHandler) preparePosition(w http.ResponseWriter, alert AlertRequest) (decimal.Decimal, bool) {
+	if alert.Bot == "" || alert.Symbol == "" {
+		h.logger.Error("missing required fields",
+			zap.String("bot", alert.Bot),
+			zap.String("symbol", alert.Symbol),
+			zap.String("position", alert.Position))
+		writeError(w, http.StatusBadRequest, CodeMissingFields, alert.Bot, "Missing required fields")
+		return decimal.Zero, false
+	}
+
+	err := validateFields(alert)
+	var target decimal.Decimal
+	if err == nil {
+		target, err = parseTarget(alert)
+	}
+	if err != nil {
+		h.logger.Error("invalid position request",
+			zap.Error(err),
+			zap.String("bot", alert.Bot),
+			zap.String("position", alert.Position),
+			zap.String("qty", alert.Qty))
+		writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot, err.Error())
+		return decimal.Zero, false
+	}
+
+	// A target is a position in one account: scaled copies of it would
+	// each trade from a different current position
+	if targets := h.fanoutTargets(alert); len(targets) > 0 {
+		h.logger.Error("position alert from fan-out bot",
+			zap.String("bot", alert.Bot),
+			zap.String("position", alert.Position))
+		writeError(w, http.StatusBadRequest, CodeInvalidField, alert.Bot,
+			"Position alerts cannot fan out: set account to target one of the bot's accounts")
+		return decimal.Zero, false
+	}
+	return target, true
+}
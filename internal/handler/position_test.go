@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func TestPlanPosition(t *testing.T) {
+	d := decimal.RequireFromString
+	tests := []struct {
+		current, target string
+		want            []string
+	}{
+		{"0", "10", []string{"buy 10"}},
+		{"10", "10", nil},
+		{"10", "4", []string{"sell 6"}},
+		{"10", "0", []string{"sell 10"}},
+		{"10", "-5", []string{"sell 10", "sell 5"}},
+		{"-5", "10", []string{"buy 5", "buy 10"}},
+		{"-5", "-8", []string{"sell 3"}},
+		{"-5", "0", []string{"buy 5"}},
+	}
+	for _, tt := range tests {
+		steps := planPosition(d(tt.current), d(tt.target))
+		var got []string
+		for _, s := range steps {
+			got = append(got, s.side+" "+s.qty.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s -> %s: expected %v, got %v", tt.current, tt.target, tt.want, got)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s -> %s: expected %v, got %v", tt.current, tt.target, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		alert   AlertRequest
+		want    string
+		wantErr bool
+	}{
+		{AlertRequest{Position: "long", Qty: "10"}, "10", false},
+		{AlertRequest{Position: "SHORT", Qty: "5"}, "-5", false},
+		{AlertRequest{Position: "flat"}, "0", false},
+		{AlertRequest{Position: "flat", Qty: "0"}, "0", false},
+		{AlertRequest{Position: "flat", Qty: "3"}, "", true},
+		{AlertRequest{Position: "long"}, "", true},
+		{AlertRequest{Position: "long", Qty: "all"}, "", true},
+		{AlertRequest{Position: "long", Qty: "1", Side: "buy"}, "", true},
+		{AlertRequest{Position: "long", Qty: "1", Type: "limit", LimitPrice: "1"}, "", true},
+		{AlertRequest{Position: "sideways", Qty: "1"}, "", true},
+	}
+	for i, tt := range tests {
+		got, err := parseTarget(tt.alert)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("case %d: unexpected error state: %v", i, err)
+		}
+		if err == nil && got.String() != tt.want {
+			t.Fatalf("case %d: expected %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestHandlePositionReversal(t *testing.T) {
+	var sides []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/positions/AAPL", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"symbol":"AAPL","qty":"10","side":"long"}`))
+	})
+	mux.HandleFunc("/v2/orders", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		json.Unmarshal(body, &req)
+		sides = append(sides, req["side"].(string)+" "+req["qty"].(string))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1"}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := adapter.NewAlpacaClient("key", "secret", ts.URL)
+	h := NewHookHandler(zap.NewNop(), client, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","position":"short","qty":"5"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if len(sides) != 2 || sides[0] != "sell 10" || sides[1] != "sell 5" {
+		t.Fatalf("unexpected orders %v", sides)
+	}
+
+	var result PositionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Current.Equal(decimal.NewFromInt(10)) || !result.Target.Equal(decimal.NewFromInt(-5)) || len(result.Orders) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestHandlePositionAlreadyFlat(t *testing.T) {
+	var closed string
+	client := newPositionAlpacaClient(t, "", &closed)
+	h := NewHookHandler(zap.NewNop(), client, risk.NewGuard("0"), nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","position":"flat"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var result PositionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result.Orders) != 0 {
+		t.Fatalf("expected no orders, got %d", len(result.Orders))
+	}
+}
+
+func TestHandlePositionAtTargetSkipsCooldown(t *testing.T) {
+	broker := &fakeBroker{position: &alpaca.Position{Symbol: "AAPL", Qty: decimal.NewFromInt(5), Side: "long"}}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("60"), nil, nil, true, true, true)
+
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","position":"long","qty":"5"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if orders := broker.placed(); len(orders) != 0 {
+		t.Fatalf("expected no orders, got %+v", orders)
+	}
+	// The no-op did not start the cooldown
+	if rr := postAlert(h, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected the next alert to pass, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestHandlePositionRiskModify(t *testing.T) {
+	broker := &fakeBroker{position: &alpaca.Position{Symbol: "AAPL", Qty: decimal.NewFromInt(2), Side: "long"}}
+	g := risk.NewGuard("0")
+	g.Register(ruleFunc{"cap", func(in risk.Intent) risk.Decision {
+		if in.Side != "buy" || !in.Qty.Equal(decimal.NewFromInt(8)) {
+			return risk.Deny("unexpected intent %s %s", in.Side, in.Qty)
+		}
+		in.Qty = decimal.NewFromInt(3)
+		return risk.Modify(in, "capped")
+	}})
+	h := NewHookHandler(zap.NewNop(), broker, g, nil, nil, true, true, true)
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","position":"long","qty":"10"}`)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var result PositionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Target.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("expected the target to shrink to 5, got %s", result.Target)
+	}
+	if orders := broker.placed(); len(orders) != 1 || orders[0].Side != "buy" || !orders[0].Qty.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("expected one buy of 3, got %+v", orders)
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/ratelimit"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+// SetRateLimits limits requests to /hook across all senders, per source
+// IP and per bot. A nil limiter disables that scope. The global and IP
+// limits apply before the body is read; the bot limit applies once the
+// alert has authenticated, so forged alerts cannot use up a bot's budget.
+func (h *HookHandler) SetRateLimits(global, perIP, perBot *ratelimit.Limiter) {
+	h.globalLimit = global
+	h.ipLimit = perIP
+	h.botLimit = perBot
+}
+
+// allowSource applies the global and per-IP rate limits to r.
+func (h *HookHandler) allowSource(w http.ResponseWriter, r *http.Request) bool {
+	if !h.allow(w, r, h.globalLimit, "global", "", "") {
+		return false
+	}
+	if h.ipLimit == nil {
+		return true
+	}
+	return h.allow(w, r, h.ipLimit, "ip", auth.ClientIP(r).String(), "")
+}
+
+// allowBot applies the per-bot rate limit to alert.
+func (h *HookHandler) allowBot(w http.ResponseWriter, r *http.Request, alert AlertRequest) bool {
+	return h.allow(w, r, h.botLimit, "bot", alert.Bot, alert.Bot)
+}
+
+// allow takes a token for key from limiter and writes a 429 response with
+// Retry-After when there is none. It reports whether processing may
+// continue.
+func (h *HookHandler) allow(w http.ResponseWriter, r *http.Request, limiter *ratelimit.Limiter, scope, key, bot string) bool {
+	if limiter == nil {
+		return true
+	}
+	ok, wait := limiter.Allow(key)
+	if ok {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	metrics.RateLimitedTotal.WithLabelValues(scope, bot).Inc()
+	fields := []zap.Field{
+		zap.String("scope", scope),
+		zap.String("bot", bot),
+		zap.Int("retry_after_sec", retryAfter),
+	}
+	if h.fullLogging || scope == "ip" {
+		fields = append(fields, zap.String("client_ip", auth.ClientIP(r).String()))
+	}
+	h.logger.Warn("rate limit exceeded", fields...)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, http.StatusTooManyRequests, CodeRateLimited, bot, "Rate limit exceeded")
+	return false
+}
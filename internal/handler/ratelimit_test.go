@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/ratelimit"
+	"github.com/njdaniel/alertbridge/internal/risk"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+func TestHandleRateLimits(t *testing.T) {
+	one := ratelimit.Limit{Rate: 1.0 / 60, Burst: 1}
+	tests := []struct {
+		name   string
+		setup  func(h *HookHandler)
+		second string // bot and remote address of the second request
+		remote string
+		scope  string
+		label  string // bot label of the rate_limited_total series
+	}{
+		{"per bot", func(h *HookHandler) { h.SetRateLimits(nil, nil, ratelimit.New(one)) }, "rl_bot", "10.0.0.1:1", "bot", "rl_bot"},
+		{"per ip", func(h *HookHandler) { h.SetRateLimits(nil, ratelimit.New(one), nil) }, "rl_other", "10.0.0.1:1", "ip", ""},
+		{"global", func(h *HookHandler) { h.SetRateLimits(ratelimit.New(one), nil, nil) }, "rl_other", "10.0.0.2:1", "global", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+			tt.setup(h)
+
+			send := func(bot, remote string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/hook",
+					strings.NewReader(`{"bot":"`+bot+`","symbol":"AAPL","side":"buy","qty":"1"}`))
+				req.RemoteAddr = remote
+				rr := httptest.NewRecorder()
+				h.Handle(rr, req)
+				return rr
+			}
+
+			if rr := send("rl_bot", "10.0.0.1:1"); rr.Code != http.StatusOK {
+				t.Fatalf("expected first request to pass, got %d", rr.Code)
+			}
+			before := testutil.ToFloat64(metrics.RateLimitedTotal.WithLabelValues(tt.scope, tt.label))
+			rr := send(tt.second, tt.remote)
+			if rr.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected 429, got %d", rr.Code)
+			}
+			if got := rr.Header().Get("Retry-After"); got != "60" {
+				t.Fatalf("expected Retry-After 60, got %q", got)
+			}
+			after := testutil.ToFloat64(metrics.RateLimitedTotal.WithLabelValues(tt.scope, tt.label))
+			if after-before != 1 {
+				t.Fatalf("expected rate_limited_total{scope=%q} to increment", tt.scope)
+			}
+			if len(broker.placed()) != 1 {
+				t.Fatalf("expected 1 order, got %d", len(broker.placed()))
+			}
+		})
+	}
+}
+
+func TestHandleBotLimitAfterAuth(t *testing.T) {
+	h := NewHookHandler(zap.NewNop(), &fakeBroker{}, risk.NewGuard("0"), []byte("s"), nil, true, true, true)
+	h.SetRateLimits(nil, nil, ratelimit.New(ratelimit.Limit{Rate: 1.0 / 60, Burst: 1}))
+
+	// A forged alert does not consume the bot's token
+	forged := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`))
+	req.Header.Set("X-TV-Signature", "forged-signature")
+	h.Handle(forged, req)
+	if forged.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", forged.Code)
+	}
+
+	body := []byte(`{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`)
+	req = httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(string(body)))
+	req.Header.Set("X-TV-Signature", sign("s", body))
+	rr := httptest.NewRecorder()
+	h.Handle(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+// SetBotAllowedIPs restricts the bots in allowed to alerts sent from their
+// listed networks. Bots without an entry accept any source that passed the
+// global allow-list.
+func (h *HookHandler) SetBotAllowedIPs(allowed map[string][]*net.IPNet) {
+	h.botAllowedIPs = allowed
+}
+
+// checkSource enforces the bot's source allow-list and writes a 403
+// response for a source outside it. It reports whether processing may
+// continue.
+func (h *HookHandler) checkSource(w http.ResponseWriter, r *http.Request, alert AlertRequest) bool {
+	nets := h.botAllowedIPs[alert.Bot]
+	if len(nets) == 0 {
+		return true
+	}
+	ip := auth.ClientIP(r)
+	if ip != nil && auth.ContainsIP(nets, ip) {
+		return true
+	}
+
+	metrics.SourceRejectedTotal.WithLabelValues("bot", alert.Bot).Inc()
+	h.logger.Warn("rejected request source",
+		zap.String("bot", alert.Bot),
+		zap.String("client_ip", ip.String()),
+		zap.String("remote_addr", r.RemoteAddr))
+	writeError(w, http.StatusForbidden, CodeSourceForbidden, alert.Bot, "Source address not allowed")
+	return false
+}
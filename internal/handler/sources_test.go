@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/auth"
+	"github.com/njdaniel/alertbridge/internal/risk"
+	"github.com/njdaniel/alertbridge/pkg/metrics"
+)
+
+func TestHandleBotAllowedIPs(t *testing.T) {
+	nets, err := auth.ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+	tests := []struct {
+		name   string
+		bot    string
+		remote string
+		code   int
+	}{
+		{"allowed source", "locked", "10.1.2.3:4000", http.StatusOK},
+		{"rejected source", "locked", "203.0.113.9:4000", http.StatusForbidden},
+		{"unrestricted bot", "open", "203.0.113.9:4000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+			h.SetBotAllowedIPs(map[string][]*net.IPNet{"locked": nets})
+
+			body := `{"bot":"` + tt.bot + `","symbol":"AAPL","side":"buy","qty":"1"}`
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+			req.RemoteAddr = tt.remote
+			before := testutil.ToFloat64(metrics.SourceRejectedTotal.WithLabelValues("bot", tt.bot))
+			rr := httptest.NewRecorder()
+
+			h.Handle(rr, req)
+			if rr.Code != tt.code {
+				t.Fatalf("expected %d, got %d", tt.code, rr.Code)
+			}
+			rejected := testutil.ToFloat64(metrics.SourceRejectedTotal.WithLabelValues("bot", tt.bot)) - before
+			if want := tt.code == http.StatusForbidden; want != (rejected == 1) || want != (len(broker.placed()) == 0) {
+				t.Fatalf("unexpected rejection count %v and orders %d", rejected, len(broker.placed()))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+// DefaultMaxBodyBytes is the request body cap used unless SetBodyLimit
+// changes it. Alerts are a few hundred bytes.
+const DefaultMaxBodyBytes = 64 << 10
+
+// symbolPattern matches stock tickers such as AAPL or BRK.B and crypto
+// pairs such as BTC/USD or BTCUSD.
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9]{1,10}([./-][A-Z0-9]{1,10})?$`)
+
+// SetBodyLimit caps request bodies at n bytes; larger bodies are rejected
+// with 413 Request Entity Too Large. A limit of 0 or less removes the cap.
+func (h *HookHandler) SetBodyLimit(n int64) {
+	h.maxBodyBytes = n
+}
+
+// SetStrictJSON rejects alerts with fields the handler does not know,
+// such as a misspelled "sdie", and bodies with trailing data.
+func (h *HookHandler) SetStrictJSON(strict bool) {
+	h.strictJSON = strict
+}
+
+// readBody reads the request body within the configured limit and writes
+// a 400 or 413 response when it cannot. It reports whether processing may
+// continue.
+func (h *HookHandler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body := r.Body
+	if h.maxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+	}
+	b, err := io.ReadAll(body)
+	if err == nil {
+		return b, true
+	}
+
+	fields := []zap.Field{zap.Error(err)}
+	if h.fullLogging {
+		fields = append(fields, zap.String("remote_addr", r.RemoteAddr))
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.logger.Error("request body too large", append(fields, zap.Int64("limit", tooLarge.Limit))...)
+		writeError(w, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "", "Request body too large")
+		return nil, false
+	}
+	h.logger.Error("failed to read request body", fields...)
+	writeError(w, http.StatusBadRequest, CodeInvalidRequest, "", "Invalid request body")
+	return nil, false
+}
+
+// decodeAlert parses body into an alert. In strict mode unknown fields and
+// trailing data are errors.
+func (h *HookHandler) decodeAlert(body []byte) (AlertRequest, error) {
+	var alert AlertRequest
+	if !h.strictJSON {
+		err := json.Unmarshal(body, &alert)
+		return alert, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&alert); err != nil {
+		return AlertRequest{}, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return AlertRequest{}, errors.New("unexpected data after alert")
+	}
+	return alert, nil
+}
+
+// validateFields checks the symbol and size of alert before it reaches
+// the risk guard. Target-position alerts validate their qty in
+// parseTarget.
+func validateFields(alert AlertRequest) error {
+	if !symbolPattern.MatchString(alert.Symbol) {
+		return fmt.Errorf("invalid symbol %q", alert.Symbol)
+	}
+	if alert.Position != "" {
+		return nil
+	}
+	if _, closing, _ := parseClosePercent(alert.Qty); !closing && alert.Qty != "" {
+		if _, err := parsePositive("qty", alert.Qty); err != nil {
+			return err
+		}
+	}
+	if _, err := parseOptionalPositive("notional", alert.Notional); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/risk"
+)
+
+func TestHandleBodyLimit(t *testing.T) {
+	broker := &fakeBroker{}
+	h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+	h.SetBodyLimit(64)
+
+	body := `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","type":"` + strings.Repeat("x", 64) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	h.Handle(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rr.Code)
+	}
+	if len(broker.placed()) != 0 {
+		t.Fatal("expected no order for an oversized body")
+	}
+}
+
+func TestHandleStrictJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		body   string
+		code   int
+	}{
+		{"lenient ignores unknown fields", false, `{"bot":"b","symbol":"AAPL","sdie":"sell","side":"buy","qty":"1"}`, http.StatusOK},
+		{"strict rejects unknown fields", true, `{"bot":"b","symbol":"AAPL","sdie":"sell","side":"buy","qty":"1"}`, http.StatusBadRequest},
+		{"strict rejects trailing data", true, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}{}`, http.StatusBadRequest},
+		{"strict accepts known fields", true, `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1","passphrase":""}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			h := NewHookHandler(zap.NewNop(), broker, risk.NewGuard("0"), nil, nil, true, true, true)
+			h.SetStrictJSON(tt.strict)
+
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+
+			h.Handle(rr, req)
+			if rr.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			// Decode details are logged, not returned to unauthenticated senders
+			if tt.code == http.StatusBadRequest && (strings.Contains(rr.Body.String(), "sdie") || !strings.Contains(rr.Body.String(), CodeInvalidJSON)) {
+				t.Fatalf("expected a generic invalid_json error, got %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateFields(t *testing.T) {
+	tests := []struct {
+		alert AlertRequest
+		ok    bool
+	}{
+		{AlertRequest{Symbol: "AAPL", Qty: "1"}, true},
+		{AlertRequest{Symbol: "BRK.B", Qty: "0.5"}, true},
+		{AlertRequest{Symbol: "BTC/USD", Notional: "100"}, true},
+		{AlertRequest{Symbol: "AAPL", Qty: "all"}, true},
+		{AlertRequest{Symbol: "AAPL", Qty: "50%"}, true},
+		{AlertRequest{Symbol: "AAPL", Position: "flat", Qty: "0"}, true},
+		{AlertRequest{Symbol: "aapl", Qty: "1"}, false},
+		{AlertRequest{Symbol: "AAPL; DROP", Qty: "1"}, false},
+		{AlertRequest{Symbol: "{{ticker}}", Qty: "1"}, false},
+		{AlertRequest{Symbol: "AAPL", Qty: "0"}, false},
+		{AlertRequest{Symbol: "AAPL", Qty: "-1"}, false},
+		{AlertRequest{Symbol: "AAPL", Qty: "ten"}, false},
+		{AlertRequest{Symbol: "AAPL", Notional: "-5"}, false},
+	}
+	for _, tt := range tests {
+		err := validateFields(tt.alert)
+		if (err == nil) != tt.ok {
+			t.Fatalf("validateFields(%+v) = %v, want ok %v", tt.alert, err, tt.ok)
+		}
+	}
+}
+
+func TestHandleRejectsInvalidSymbolBeforeRisk(t *testing.T) {
+	broker := &fakeBroker{}
+	guard := risk.NewGuard("60")
+	h := NewHookHandler(zap.NewNop(), broker, guard, nil, nil, true, true, true)
+
+	body := `{"bot":"b","symbol":"{{ticker}}","side":"buy","qty":"1"}`
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+
+	// The rejected alert must not have started the bot's cooldown
+	body = `{"bot":"b","symbol":"AAPL","side":"buy","qty":"1"}`
+	rr = httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,402 @@
+// Package queue is a durable job queue backed by an append-only journal
+// file. Accepted alerts are written to disk before they are acknowledged,
+// so that work interrupted by a crash or restart is resumed.
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ErrClosed is returned by Enqueue and Next after Close.
+var ErrClosed = errors.New("queue is closed")
+
+// Job is a unit of work and, once finished, its outcome.
+type Job struct {
+	ID        string          `json:"id"`
+	Seq       uint64          `json:"seq"` // enqueue order
+	Bot       string          `json:"bot"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Created   time.Time       `json:"created"`
+	Updated   time.Time       `json:"updated"`
+	Code      int             `json:"code,omitempty"`   // HTTP status of the outcome
+	Result    json.RawMessage `json:"result,omitempty"` // response body of the outcome
+	Keys      []string        `json:"keys,omitempty"`   // further IDs FindKey finds the job by
+}
+
+// compactMinLines is the journal length below which Finish does not
+// compact it.
+var compactMinLines = 1000
+
+// record is one line of the journal.
+type record struct {
+	Op  string `json:"op"` // "enqueue" or "finish"
+	Job Job    `json:"job"`
+}
+
+// Queue hands out jobs in the order they were enqueued. Jobs of the same
+// bot run one at a time so that a bot's alerts keep their order.
+type Queue struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	jobs      map[string]*Job
+	index     map[string]string // Keys of jobs to the newest job's ID
+	pending   []string          // IDs of queued jobs, oldest first
+	busy      map[string]bool   // bots with a running job
+	changed   chan struct{}     // closed when pending or busy changes
+	seq       uint64            // Seq of the newest job
+	closed    bool
+	retain    time.Duration
+	now       func() time.Time
+	lines     int       // records in the journal
+	compacted time.Time // when the journal was last compacted
+}
+
+// Open opens the journal at path, creating it when missing. Jobs that were
+// queued or running when the journal was last written are queued again.
+// Finished jobs older than retain are dropped, at Open and whenever the
+// journal is compacted; a retain of zero keeps them all.
+func Open(path string, retain time.Duration) (*Queue, error) {
+	q := &Queue{
+		path:    path,
+		jobs:    make(map[string]*Job),
+		index:   make(map[string]string),
+		busy:    make(map[string]bool),
+		changed: make(chan struct{}),
+		retain:  retain,
+		now:     time.Now,
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load replays the journal. A truncated last line, left by a crash while
+// it was being written, is ignored.
+func (q *Queue) load() error {
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read queue journal: %w", err)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec record
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil {
+				if err == io.EOF {
+					break
+				}
+				return fmt.Errorf("parse queue journal %s line %d: %w", q.path, n, jsonErr)
+			}
+			job := rec.Job
+			q.jobs[job.ID] = &job
+			if job.Seq > q.seq {
+				q.seq = job.Seq
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	for _, job := range q.jobs {
+		if job.Status == StatusRunning {
+			job.Status = StatusQueued
+		}
+		if job.Status == StatusQueued {
+			q.pending = append(q.pending, job.ID)
+		}
+	}
+	sort.Slice(q.pending, func(i, j int) bool { return q.jobs[q.pending[i]].Seq < q.jobs[q.pending[j]].Seq })
+	return nil
+}
+
+// compact atomically rewrites the journal with one line per retained job
+// and reopens it for appending. The caller holds q.mu or has not yet
+// shared q.
+func (q *Queue) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	ids := make([]string, 0, len(q.jobs))
+	for id, job := range q.jobs {
+		if q.retain > 0 && job.finished() && q.now().Sub(job.Updated) > q.retain {
+			delete(q.jobs, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return q.jobs[ids[i]].Seq < q.jobs[ids[j]].Seq })
+	for _, id := range ids {
+		if err := enc.Encode(record{Op: "enqueue", Job: *q.jobs[id]}); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write queue journal: %w", err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write queue journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write queue journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write queue journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write queue journal: %w", err)
+	}
+
+	// The open file still points at the replaced journal
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open queue journal: %w", err)
+	}
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file = f
+	q.lines = len(ids)
+	q.compacted = q.now()
+
+	q.index = make(map[string]string)
+	for _, id := range ids {
+		q.indexJob(q.jobs[id])
+	}
+	return nil
+}
+
+// indexJob makes job's Keys find it, unless they already find a newer
+// job. The caller holds q.mu.
+func (q *Queue) indexJob(job *Job) {
+	for _, key := range job.Keys {
+		if key == "" {
+			continue
+		}
+		if other, ok := q.jobs[q.index[key]]; ok && other.Seq > job.Seq {
+			continue
+		}
+		q.index[key] = job.ID
+	}
+}
+
+// compactDue reports whether the journal holds two records per job, or
+// finished jobs may have outlived retain since the last compaction. The
+// caller holds q.mu.
+func (q *Queue) compactDue() bool {
+	if q.lines >= compactMinLines && q.lines >= 2*len(q.jobs) {
+		return true
+	}
+	return q.retain > 0 && q.now().Sub(q.compacted) >= q.retain
+}
+
+// append writes rec to the journal and syncs it to disk. The caller holds
+// q.mu.
+func (q *Queue) append(rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write queue journal: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("sync queue journal: %w", err)
+	}
+	q.lines++
+	return nil
+}
+
+// notify wakes goroutines waiting in Next. The caller holds q.mu.
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Enqueue persists job and queues it. ID, Bot and Payload must be set,
+// and Keys may be; Seq, Status and the timestamps are filled in.
+func (q *Queue) Enqueue(job Job) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, ErrClosed
+	}
+	if _, ok := q.jobs[job.ID]; ok {
+		return Job{}, fmt.Errorf("job %s already exists", job.ID)
+	}
+
+	now := q.now()
+	job.Seq = q.seq + 1
+	job.Status = StatusQueued
+	job.Created, job.Updated = now, now
+	if err := q.append(record{Op: "enqueue", Job: job}); err != nil {
+		return Job{}, err
+	}
+	q.seq = job.Seq
+	q.jobs[job.ID] = &job
+	q.indexJob(&job)
+	q.pending = append(q.pending, job.ID)
+	q.notify()
+	return job, nil
+}
+
+// Next waits for the oldest queued job whose bot has no running job,
+// marks it running and returns it. The caller must Finish it.
+func (q *Queue) Next(ctx context.Context) (Job, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return Job{}, ErrClosed
+		}
+		for i, id := range q.pending {
+			job := q.jobs[id]
+			if q.busy[job.Bot] {
+				continue
+			}
+			q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+			q.busy[job.Bot] = true
+			job.Status = StatusRunning
+			job.Updated = q.now()
+			q.mu.Unlock()
+			return *job, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Finish records the outcome of a running job: code is the HTTP status of
+// its response and result the response body. A job whose outcome cannot
+// be persisted runs again after a restart. Finish compacts the journal
+// when it is due.
+func (q *Queue) Finish(id string, code int, result []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+
+	delete(q.busy, job.Bot)
+	q.notify()
+
+	done := *job
+	done.Status = StatusFailed
+	if code >= 200 && code < 300 {
+		done.Status = StatusSucceeded
+	}
+	done.Code = code
+	if json.Valid(result) {
+		done.Result = json.RawMessage(result)
+	} else {
+		done.Result, _ = json.Marshal(string(result))
+	}
+	done.Updated = q.now()
+	if !q.closed {
+		if err := q.append(record{Op: "finish", Job: done}); err != nil {
+			return err
+		}
+	}
+	*job = done
+	if !q.closed && q.compactDue() {
+		if err := q.compact(); err != nil {
+			return fmt.Errorf("compact queue journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// Get returns the job with id.
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// FindKey returns the job with ID key or, failing that, the newest job
+// enqueued with key among its Keys.
+func (q *Queue) FindKey(key string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[key]
+	if !ok {
+		job, ok = q.jobs[q.index[key]]
+	}
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Len returns the number of queued jobs.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Close stops the queue and closes the journal. Queued jobs stay in the
+// journal and run after the next Open.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.notify()
+	return q.file.Close()
+}
+
+func (j *Job) finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
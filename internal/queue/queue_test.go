@@ -0,0 +1,300 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) (*Queue, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return q, path
+}
+
+func enqueue(t *testing.T, q *Queue, id, bot string) {
+	t.Helper()
+	if _, err := q.Enqueue(Job{ID: id, Bot: bot, Payload: json.RawMessage(`{"bot":"` + bot + `"}`)}); err != nil {
+		t.Fatalf("Enqueue(%s) failed: %v", id, err)
+	}
+}
+
+func next(t *testing.T, q *Queue) Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	job, err := q.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	return job
+}
+
+func TestQueueOrder(t *testing.T) {
+	q, _ := openTemp(t)
+	defer q.Close()
+	enqueue(t, q, "a1", "a")
+	enqueue(t, q, "a2", "a")
+	enqueue(t, q, "b1", "b")
+
+	if job := next(t, q); job.ID != "a1" || job.Status != StatusRunning {
+		t.Fatalf("expected running a1, got %s %s", job.ID, job.Status)
+	}
+	// a2 waits for a1, so b1 runs next
+	if job := next(t, q); job.ID != "b1" {
+		t.Fatalf("expected b1 while bot a is busy, got %s", job.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Next to wait while both bots are busy, got %v", err)
+	}
+
+	if err := q.Finish("a1", 200, []byte(`{"id":"order"}`)); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if job := next(t, q); job.ID != "a2" {
+		t.Fatalf("expected a2 after a1 finished, got %s", job.ID)
+	}
+}
+
+func TestQueueNextWakesOnEnqueue(t *testing.T) {
+	q, _ := openTemp(t)
+	defer q.Close()
+
+	got := make(chan Job)
+	go func() {
+		job, _ := q.Next(context.Background())
+		got <- job
+	}()
+	time.Sleep(10 * time.Millisecond)
+	enqueue(t, q, "j1", "bot")
+
+	select {
+	case job := <-got:
+		if job.ID != "j1" {
+			t.Fatalf("expected j1, got %s", job.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected waiting Next to receive the new job")
+	}
+}
+
+func TestQueueFinish(t *testing.T) {
+	q, _ := openTemp(t)
+	defer q.Close()
+	enqueue(t, q, "ok", "a")
+	enqueue(t, q, "bad", "b")
+	next(t, q)
+	next(t, q)
+
+	q.Finish("ok", 200, []byte(`{"id":"order"}`))
+	q.Finish("bad", 500, []byte("not json"))
+
+	if job, _ := q.Get("ok"); job.Status != StatusSucceeded || job.Code != 200 || string(job.Result) != `{"id":"order"}` {
+		t.Fatalf("unexpected succeeded job %+v", job)
+	}
+	if job, _ := q.Get("bad"); job.Status != StatusFailed || string(job.Result) != `"not json"` {
+		t.Fatalf("unexpected failed job %+v", job)
+	}
+	if _, ok := q.Get("missing"); ok {
+		t.Fatal("expected unknown job to be missing")
+	}
+}
+
+func TestQueueFindKey(t *testing.T) {
+	q, path := openTemp(t)
+	for _, id := range []string{"j1", "j2"} {
+		if _, err := q.Enqueue(Job{ID: id, Bot: "a", Keys: []string{"alert", id + "-cid"}}); err != nil {
+			t.Fatalf("Enqueue(%s) failed: %v", id, err)
+		}
+	}
+
+	if job, ok := q.FindKey("alert"); !ok || job.ID != "j2" {
+		t.Fatalf("expected newest match j2, got %s %v", job.ID, ok)
+	}
+	if job, ok := q.FindKey("j1-cid"); !ok || job.ID != "j1" {
+		t.Fatalf("expected j1, got %s %v", job.ID, ok)
+	}
+	if job, ok := q.FindKey("j1"); !ok || job.ID != "j1" {
+		t.Fatalf("expected lookup by job ID, got %s %v", job.ID, ok)
+	}
+	if _, ok := q.FindKey("other"); ok {
+		t.Fatal("expected no match")
+	}
+
+	// The index is rebuilt from the journal
+	q.Close()
+	q, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+	if job, ok := q.FindKey("alert"); !ok || job.ID != "j2" {
+		t.Fatalf("expected j2 after reopen, got %s %v", job.ID, ok)
+	}
+}
+
+func TestQueueCompactsPeriodically(t *testing.T) {
+	defer func(n int) { compactMinLines = n }(compactMinLines)
+	compactMinLines = 4
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+
+	lines := func() int {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read journal: %v", err)
+		}
+		return bytes.Count(data, []byte("\n"))
+	}
+	for _, id := range []string{"j1", "j2", "j3"} {
+		enqueue(t, q, id, id)
+		next(t, q)
+		if err := q.Finish(id, 200, []byte(`{}`)); err != nil {
+			t.Fatalf("Finish(%s) failed: %v", id, err)
+		}
+	}
+	// Two records per job trip the size check once the journal has four
+	// Compacted at the second Finish, then j3 appended two records
+	if n := lines(); n != 4 {
+		t.Fatalf("expected a compacted journal of 4 lines, got %d", n)
+	}
+
+	// Once retain has passed, finished jobs are dropped without a reopen
+	now = now.Add(2 * time.Hour)
+	enqueue(t, q, "j4", "a")
+	next(t, q)
+	if err := q.Finish("j4", 200, []byte(`{}`)); err != nil {
+		t.Fatalf("Finish(j4) failed: %v", err)
+	}
+	if _, ok := q.Get("j1"); ok {
+		t.Fatal("expected j1 past retention to be dropped")
+	}
+	if n := lines(); n != 1 {
+		t.Fatalf("expected 1 line after compaction, got %d", n)
+	}
+	enqueue(t, q, "j5", "a")
+	if n := lines(); n != 2 {
+		t.Fatalf("expected appends to reach the compacted journal, got %d lines", n)
+	}
+}
+
+func TestQueueResume(t *testing.T) {
+	q, path := openTemp(t)
+	enqueue(t, q, "done", "a")
+	enqueue(t, q, "running", "b")
+	enqueue(t, q, "queued", "c")
+	next(t, q)
+	next(t, q)
+	q.Finish("done", 200, []byte(`{}`))
+	q.Close()
+
+	q, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+	if q.Len() != 2 {
+		t.Fatalf("expected unfinished jobs to be queued again, got %d", q.Len())
+	}
+	if job := next(t, q); job.ID != "running" {
+		t.Fatalf("expected interrupted job first, got %s", job.ID)
+	}
+	if job := next(t, q); job.ID != "queued" {
+		t.Fatalf("expected queued job second, got %s", job.ID)
+	}
+	if job, _ := q.Get("done"); job.Status != StatusSucceeded {
+		t.Fatalf("expected finished job to keep its outcome, got %s", job.Status)
+	}
+
+	// New jobs are ordered after resumed ones
+	enqueue(t, q, "new", "d")
+	if job, _ := q.Get("new"); job.Seq <= 3 {
+		t.Fatalf("expected sequence to continue, got %d", job.Seq)
+	}
+}
+
+func TestQueueTruncatedJournal(t *testing.T) {
+	q, path := openTemp(t)
+	enqueue(t, q, "j1", "a")
+	q.Close()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	f.WriteString(`{"op":"enqueue","job":{"id":"j2"`)
+	f.Close()
+
+	q, err = Open(path, 0)
+	if err != nil {
+		t.Fatalf("expected truncated last line to be ignored, got %v", err)
+	}
+	defer q.Close()
+	if q.Len() != 1 {
+		t.Fatalf("expected 1 queued job, got %d", q.Len())
+	}
+}
+
+func TestQueueCorruptJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	os.WriteFile(path, []byte("garbage\n{\"op\":\"enqueue\",\"job\":{\"id\":\"j1\"}}\n"), 0o600)
+	if _, err := Open(path, 0); err == nil {
+		t.Fatal("expected error for a corrupt journal")
+	}
+}
+
+func TestQueueRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+	enqueue(t, q, "old", "a")
+	enqueue(t, q, "pending", "b")
+	next(t, q)
+	q.Finish("old", 200, []byte(`{}`))
+	q.Close()
+
+	q, err = Open(path, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+	if _, ok := q.Get("old"); ok {
+		t.Fatal("expected finished job past retention to be dropped")
+	}
+	if _, ok := q.Get("pending"); !ok {
+		t.Fatal("expected unfinished job to be kept regardless of age")
+	}
+}
+
+func TestQueueClosed(t *testing.T) {
+	q, _ := openTemp(t)
+	q.Close()
+	if _, err := q.Enqueue(Job{ID: "j", Bot: "b"}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from Enqueue, got %v", err)
+	}
+	if _, err := q.Next(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from Next, got %v", err)
+	}
+}
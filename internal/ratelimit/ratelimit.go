@@ -0,0 +1,140 @@
+// Package ratelimit provides keyed token-bucket rate limiters.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token-bucket rate: Rate tokens are added per second up to a
+// capacity of Burst. The zero Limit does not limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit written as "N/unit" or "N/unit:burst", where
+// unit is s, m or h. The burst defaults to N, so "60/m" allows 60 requests
+// at once and then one per second.
+func ParseLimit(s string) (Limit, error) {
+	spec, burstStr, hasBurst := strings.Cut(strings.TrimSpace(s), ":")
+	nStr, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: want N/unit", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(nStr))
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: count must be a positive integer", s)
+	}
+
+	var per time.Duration
+	switch strings.TrimSpace(unit) {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("invalid rate limit %q: unit must be s, m or h", s)
+	}
+
+	burst := n
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(burstStr))
+		if err != nil || burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", s)
+		}
+	}
+	return Limit{Rate: float64(n) / per.Seconds(), Burst: burst}, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepEvery is how many calls to Allow pass between sweeps of idle
+// buckets.
+const sweepEvery = 1024
+
+// Limiter applies a Limit to each key independently.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     Limit
+	overrides map[string]Limit
+	buckets   map[string]*bucket
+	calls     int
+	now       func() time.Time
+}
+
+// New creates a Limiter that applies limit to every key.
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		overrides: make(map[string]Limit),
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+// SetKeyLimit applies limit to key instead of the default limit.
+func (l *Limiter) SetKeyLimit(key string, limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.overrides[key] = limit
+	delete(l.buckets, key)
+}
+
+// Allow takes a token for key. When none is left it returns false and how
+// long to wait until one is.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	limit := l.limitFor(key)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if l.calls++; l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *Limiter) limitFor(key string) Limit {
+	if limit, ok := l.overrides[key]; ok {
+		return limit
+	}
+	return l.limit
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket
+// behaves the same. The caller holds l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limitFor(key)
+		if limit.Rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
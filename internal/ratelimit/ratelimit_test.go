@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in    string
+		rate  float64
+		burst int
+	}{
+		{"10/s", 10, 10},
+		{"60/m", 1, 60},
+		{"3600/h:5", 1, 5},
+	}
+	for _, tt := range tests {
+		l, err := ParseLimit(tt.in)
+		if err != nil {
+			t.Fatalf("ParseLimit(%q) failed: %v", tt.in, err)
+		}
+		if l.Rate != tt.rate || l.Burst != tt.burst {
+			t.Fatalf("ParseLimit(%q) = %+v", tt.in, l)
+		}
+	}
+	for _, bad := range []string{"", "10", "0/s", "10/d", "10/s:0", "x/m"} {
+		if _, err := ParseLimit(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("expected burst request %d to pass", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != time.Second {
+		t.Fatalf("expected rejection with 1s wait, got %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("expected other keys to have their own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, wait := l.Allow("a"); ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected rejection with 500ms wait, got %v %v", ok, wait)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("expected refilled token to pass")
+	}
+}
+
+func TestLimiterKeyLimit(t *testing.T) {
+	l := New(Limit{})
+	l.SetKeyLimit("vip", Limit{Rate: 1, Burst: 3})
+
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow("anyone"); !ok {
+			t.Fatal("expected zero default limit not to limit")
+		}
+	}
+
+	passed := 0
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow("vip"); ok {
+			passed++
+		}
+	}
+	if passed != 3 {
+		t.Fatalf("expected override burst of 3, got %d", passed)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(Limit{Rate: 1, Burst: 1})
+	l.now = func() time.Time { return now }
+
+	l.Allow("idle")
+	now = now.Add(time.Minute)
+	l.sweep(now)
+	if _, ok := l.buckets["idle"]; ok {
+		t.Fatal("expected refilled bucket to be swept")
+	}
+}
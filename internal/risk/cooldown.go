@@ -0,0 +1,100 @@
+package risk
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Cooldown denies alerts that arrive within a period of the bot's last
+// accepted alert. Evaluate starts the cooldown of the live alerts it
+// allows, so that of two concurrent alerts only one passes; Release
+// restarts it from the alert before when the order is not placed.
+type Cooldown struct {
+	logger *zap.Logger
+	period time.Duration
+	mu     sync.Mutex
+	slots  map[string]cooldownSlot
+}
+
+// cooldownSlot is the start of a bot's cooldown.
+type cooldownSlot struct {
+	at   time.Time
+	eval uint64    // the evaluation that reserved it
+	prev time.Time // the start it replaced, restored by Release
+}
+
+// NewCooldown returns a cooldown rule. A period of zero or less allows
+// every alert.
+func NewCooldown(period time.Duration) *Cooldown {
+	return &Cooldown{
+		logger: zap.NewNop(),
+		period: period,
+		slots:  make(map[string]cooldownSlot),
+	}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (c *Cooldown) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		c.logger = logger
+	}
+}
+
+// Name implements Rule.
+func (c *Cooldown) Name() string { return "cooldown" }
+
+// Evaluate implements Rule. Intents of the evaluation that started the
+// cooldown pass, so that an alert fanned out to several accounts is not
+// denied by itself.
+func (c *Cooldown) Evaluate(in Intent) Decision {
+	if c.period <= 0 {
+		return Allow()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot, exists := c.slots[in.Bot]
+	if exists && in.eval != 0 && slot.eval == in.eval {
+		return Allow()
+	}
+	if exists {
+		timeSinceLast := time.Since(slot.at)
+		if timeSinceLast < c.period {
+			c.logger.Warn("cooldown check failed",
+				zap.String("bot", in.Bot),
+				zap.Duration("time_since_last", timeSinceLast),
+				zap.Duration("cooldown", c.period))
+			return Deny("cooldown period not elapsed for bot %s", in.Bot)
+		}
+	}
+	// A rehearsal is checked like a live alert but must not block one
+	if !in.DryRun {
+		c.slots[in.Bot] = cooldownSlot{at: time.Now(), eval: in.eval, prev: slot.at}
+	}
+
+	c.logger.Debug("cooldown check passed",
+		zap.String("bot", in.Bot),
+		zap.Duration("cooldown", c.period))
+	return Allow()
+}
+
+// Release implements Releaser and restores the bot's previous cooldown
+// when in's evaluation started the current one.
+func (c *Cooldown) Release(in Intent) {
+	if c.period <= 0 || in.eval == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot, ok := c.slots[in.Bot]
+	if !ok || slot.eval != in.eval {
+		return
+	}
+	if slot.prev.IsZero() {
+		delete(c.slots, in.Bot)
+		return
+	}
+	c.slots[in.Bot] = cooldownSlot{at: slot.prev}
+}
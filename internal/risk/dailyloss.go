@@ -0,0 +1,174 @@
+package risk
+
+import (
+	"time"
+	_ "time/tzdata" // the trading day is kept in New York time
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// tradingZone is the time zone of the US trading day.
+var tradingZone = mustLoadLocation("America/New_York")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// DailyLoss stops new entries once the day's loss reaches max_daily_loss.
+// The global limit applies to the account, comparing its equity with
+// last_equity, the equity at the previous close. A bot's own limit applies
+// to the orders it placed since midnight New York time, found by the bot
+// name in their client order IDs, marked at the current price. Closes and
+// orders that reduce a position always pass, so a losing bot can exit.
+type DailyLoss struct {
+	logger *zap.Logger
+	limits *LimitTable
+	now    func() time.Time
+}
+
+// NewDailyLoss returns a daily loss rule for limits.
+func NewDailyLoss(limits *LimitTable) *DailyLoss {
+	return &DailyLoss{logger: zap.NewNop(), limits: limits, now: time.Now}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (d *DailyLoss) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		d.logger = logger
+	}
+}
+
+// Name implements Rule.
+func (d *DailyLoss) Name() string { return "daily_loss" }
+
+// Evaluate implements Rule. Without a broker the rule allows the order.
+func (d *DailyLoss) Evaluate(in Intent) Decision {
+	var accountMax, botMax *decimal.Decimal
+	if d.limits != nil {
+		accountMax = d.limits.Default.MaxDailyLoss
+		botMax = d.limits.Bots[in.Bot].MaxDailyLoss
+	}
+	if in.Closing || in.Broker == nil || (accountMax == nil && botMax == nil) {
+		return Allow()
+	}
+
+	reducing, err := reducesPosition(in)
+	if err != nil {
+		return Deny("failed to get position: %v", err)
+	}
+	if reducing {
+		return Allow()
+	}
+
+	if accountMax != nil {
+		acct, err := accountOf(in)
+		if err != nil {
+			return Deny("failed to get account: %v", err)
+		}
+		pnl := acct.Equity.Sub(acct.LastEquity)
+		if pnl.LessThanOrEqual(accountMax.Neg()) {
+			d.logger.Warn("account daily loss limit reached",
+				zap.String("bot", in.Bot),
+				zap.String("account", in.Account),
+				zap.String("pnl", pnl.String()),
+				zap.String("max_daily_loss", accountMax.String()))
+			return Deny("account daily loss %s reached max_daily_loss %s", pnl.Neg().StringFixed(2), accountMax)
+		}
+	}
+
+	if botMax != nil {
+		pnl, err := d.botPnL(in)
+		if err != nil {
+			return Deny("failed to compute daily PnL: %v", err)
+		}
+		if pnl.LessThanOrEqual(botMax.Neg()) {
+			d.logger.Warn("bot daily loss limit reached",
+				zap.String("bot", in.Bot),
+				zap.String("account", in.Account),
+				zap.String("pnl", pnl.String()),
+				zap.String("max_daily_loss", botMax.String()))
+			return Deny("bot %s daily loss %s reached max_daily_loss %s", in.Bot, pnl.Neg().StringFixed(2), botMax)
+		}
+	}
+	return Allow()
+}
+
+// botPnL returns the realized and unrealized PnL of the orders in.Bot
+// placed today in the intent's account.
+func (d *DailyLoss) botPnL(in Intent) (decimal.Decimal, error) {
+	now := d.now().In(tradingZone)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tradingZone)
+	orders, err := ordersOf(in, midnight)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	// Per symbol: cash received less cash paid, and the shares still held
+	cash := make(map[string]decimal.Decimal)
+	held := make(map[string]decimal.Decimal)
+	last := make(map[string]decimal.Decimal)
+	var fill func(o alpaca.Order)
+	fill = func(o alpaca.Order) {
+		if o.FilledAvgPrice != nil && o.FilledQty.IsPositive() {
+			value := o.FilledQty.Mul(*o.FilledAvgPrice)
+			if o.Side == alpaca.Sell {
+				cash[o.Symbol] = cash[o.Symbol].Add(value)
+				held[o.Symbol] = held[o.Symbol].Sub(o.FilledQty)
+			} else {
+				cash[o.Symbol] = cash[o.Symbol].Sub(value)
+				held[o.Symbol] = held[o.Symbol].Add(o.FilledQty)
+			}
+			last[o.Symbol] = *o.FilledAvgPrice
+		}
+		for _, leg := range o.Legs {
+			fill(leg)
+		}
+	}
+	for _, o := range orders {
+		if bot, ok := adapter.ClientOrderBot(o.ClientOrderID); ok && bot == in.Bot {
+			fill(o)
+		}
+	}
+
+	pnl := decimal.Zero
+	for symbol, c := range cash {
+		pnl = pnl.Add(c)
+		qty := held[symbol]
+		if qty.IsZero() {
+			continue
+		}
+		price := last[symbol]
+		pos, err := positionOf(in, symbol)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if pos != nil && pos.CurrentPrice != nil {
+			price = *pos.CurrentPrice
+		}
+		pnl = pnl.Add(qty.Mul(price))
+	}
+	return pnl, nil
+}
+
+// reducesPosition reports whether in shrinks the open position in its
+// symbol without reversing it.
+func reducesPosition(in Intent) (bool, error) {
+	pos, err := openPosition(in)
+	if err != nil || pos == nil {
+		return false, err
+	}
+	qty, ok := orderQty(in, markPrice(in, pos))
+	if !ok {
+		return false, nil
+	}
+	after := pos.Qty.Add(signed(in.Side, qty))
+	return after.Sign()*pos.Qty.Sign() >= 0 && after.Abs().LessThan(pos.Qty.Abs()), nil
+}
@@ -0,0 +1,110 @@
+package risk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// newLosingBroker returns a simulator in which bot b bought 10 AAPL at 100
+// and bot c bought 10 MSFT at 100 today, after which AAPL fell to 80.
+func newLosingBroker(t *testing.T) *adapter.SimBroker {
+	t.Helper()
+	b, err := adapter.NewSimBroker(decimal.NewFromInt(100000), "")
+	if err != nil {
+		t.Fatalf("NewSimBroker: %v", err)
+	}
+	b.Tick("AAPL", decimal.NewFromInt(100))
+	b.Tick("MSFT", decimal.NewFromInt(100))
+	for bot, symbol := range map[string]string{"b": "AAPL", "c": "MSFT"} {
+		if _, err := b.PlaceOrder(adapter.OrderRequest{Bot: bot, Symbol: symbol, Side: "buy", Qty: decimal.NewFromInt(10)}); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+	}
+	b.Tick("AAPL", decimal.NewFromInt(80))
+	return b
+}
+
+func TestDailyLossBot(t *testing.T) {
+	limits := &LimitTable{Bots: map[string]Limits{"b": {MaxDailyLoss: dec(150)}, "c": {MaxDailyLoss: dec(150)}}}
+	rule := NewDailyLoss(limits)
+	broker := newLosingBroker(t)
+
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionDeny {
+		t.Fatalf("expected bot b's loss of 200 to stop entries, got %s", d.Action)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "TSLA", Side: "sell", Qty: decimal.NewFromInt(1), Price: dec(10)}); d.Action != ActionDeny {
+		t.Fatalf("expected new short in another symbol to be stopped, got %s", d.Action)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(5)}); d.Action != ActionAllow {
+		t.Fatalf("expected reducing order to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Closing: true}); d.Action != ActionAllow {
+		t.Fatalf("expected close to pass, got %s: %s", d.Action, d.Reason)
+	}
+	// Bot c is flat on the day even though the account is not
+	if d := rule.Evaluate(Intent{Bot: "c", Broker: broker, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionAllow {
+		t.Fatalf("expected bot c to pass, got %s: %s", d.Action, d.Reason)
+	}
+
+	// Realized losses count once the position is closed
+	if _, err := broker.PlaceOrder(adapter.OrderRequest{Bot: "b", Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(10)}); err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionDeny {
+		t.Fatalf("expected realized loss to stop entries, got %s", d.Action)
+	}
+
+	// The next day starts afresh
+	rule.now = func() time.Time { return time.Now().Add(24 * time.Hour) }
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionAllow {
+		t.Fatalf("expected entries to pass the next day, got %s: %s", d.Action, d.Reason)
+	}
+}
+
+func TestDailyLossAccount(t *testing.T) {
+	broker := newLosingBroker(t)
+
+	rule := NewDailyLoss(&LimitTable{Default: Limits{MaxDailyLoss: dec(200)}})
+	if d := rule.Evaluate(Intent{Bot: "c", Broker: broker, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionDeny {
+		t.Fatalf("expected account loss of 200 to stop every bot, got %s", d.Action)
+	}
+	if d := rule.Evaluate(Intent{Bot: "c", Broker: broker, Symbol: "MSFT", Side: "sell", Qty: decimal.NewFromInt(10)}); d.Action != ActionAllow {
+		t.Fatalf("expected exit to pass, got %s: %s", d.Action, d.Reason)
+	}
+
+	rule = NewDailyLoss(&LimitTable{Default: Limits{MaxDailyLoss: dec(500)}})
+	if d := rule.Evaluate(Intent{Bot: "c", Broker: broker, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionAllow {
+		t.Fatalf("expected loss within the limit to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := NewDailyLoss(nil).Evaluate(Intent{Bot: "c", Broker: broker, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionAllow {
+		t.Fatalf("expected no limit to pass, got %s: %s", d.Action, d.Reason)
+	}
+}
+
+func TestDailyLossBotExactMatch(t *testing.T) {
+	b, err := adapter.NewSimBroker(decimal.NewFromInt(100000), "")
+	if err != nil {
+		t.Fatalf("NewSimBroker: %v", err)
+	}
+	b.Tick("AAPL", decimal.NewFromInt(100))
+	if _, err := b.PlaceOrder(adapter.OrderRequest{Bot: "foo-bar", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)}); err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	b.Tick("AAPL", decimal.NewFromInt(80))
+
+	rule := NewDailyLoss(&LimitTable{Bots: map[string]Limits{
+		"foo":     {MaxDailyLoss: dec(150)},
+		"foo-bar": {MaxDailyLoss: dec(150)},
+	}})
+	if d := rule.Evaluate(Intent{Bot: "foo", Broker: b, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1), Price: dec(10)}); d.Action != ActionAllow {
+		t.Fatalf("expected foo not to carry foo-bar's loss, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "foo-bar", Broker: b, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1), Price: dec(10)}); d.Action != ActionDeny {
+		t.Fatalf("expected foo-bar's loss to stop entries, got %s", d.Action)
+	}
+
+}
@@ -1,46 +1,42 @@
 package risk
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
 	"os"
 	"strconv"
-	"sync"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// Guard evaluates alerts against its kill switch and then an ordered chain
+// of rules. The first rule to deny an alert stops the chain.
 type Guard struct {
-	logger      *zap.Logger
-	cooldownSec int
-	lastAlert   map[string]time.Time
-	mu          sync.RWMutex
-
-	promURL   string
-	pnlMax    float64
-	pnlMin    float64
-	pnlMaxSet bool
+	logger *zap.Logger
+	rules  []Rule
+	named  map[string]Rule // every registered rule, by name
+	kill   killSwitch
+	evals  atomic.Uint64 // numbers Evaluate calls for the rules' reservations
 }
 
+// NewGuard returns a guard with the built-in chain: the cooldown of
+// cooldownSec seconds followed by the Prometheus PnL rule configured from
+// PROM_URL, PNL_MAX and PNL_MIN.
 func NewGuard(cooldownSec string) *Guard {
 	sec, _ := strconv.Atoi(cooldownSec)
 	promURL := os.Getenv("PROM_URL")
 
-	var (
-		pnlMax    float64
-		pnlMaxSet bool
-	)
+	var pnlMax *float64
 	if v, ok := os.LookupEnv("PNL_MAX"); ok {
-		pnlMaxSet = true
-		var err error
-		pnlMax, err = strconv.ParseFloat(v, 64)
+		max, err := strconv.ParseFloat(v, 64)
 		if err != nil {
 			fmt.Printf("Warning: Invalid PNL_MAX value '%s', using default 0.0. Error: %v\n", v, err)
-			pnlMax = 0.0
+			max = 0.0
 		}
+		pnlMax = &max
 	}
 
 	var pnlMin float64
@@ -53,152 +49,143 @@ func NewGuard(cooldownSec string) *Guard {
 		}
 	}
 
-	return &Guard{
-		logger:      zap.NewNop(),
-		cooldownSec: sec,
-		lastAlert:   make(map[string]time.Time),
-		promURL:     promURL,
-		pnlMax:      pnlMax,
-		pnlMin:      pnlMin,
-		pnlMaxSet:   pnlMaxSet,
-	}
+	g := &Guard{logger: zap.NewNop(), named: make(map[string]Rule)}
+	g.Register(NewCooldown(time.Duration(sec) * time.Second))
+	g.Register(NewPrometheusPnL(promURL, pnlMax, pnlMin))
+	return g
 }
 
-// SetLogger allows injecting a custom logger for debugging.
+// SetLogger allows injecting a custom logger for debugging. It is passed
+// on to the registered rules that accept one.
 func (g *Guard) SetLogger(logger *zap.Logger) {
-	if logger != nil {
-		g.logger = logger
+	if logger == nil {
+		return
+	}
+	g.logger = logger
+	for _, r := range g.named {
+		setRuleLogger(r, logger)
 	}
 }
 
-func (g *Guard) Check(bot string) error {
-	// Check cooldown
-	if g.cooldownSec > 0 {
-		g.mu.RLock()
-		lastAlert, exists := g.lastAlert[bot]
-		g.mu.RUnlock()
-
-		if exists {
-			cooldown := time.Duration(g.cooldownSec) * time.Second
-			timeSinceLast := time.Since(lastAlert)
-			if timeSinceLast < cooldown {
-				g.logger.Warn("cooldown check failed",
-					zap.String("bot", bot),
-					zap.Duration("time_since_last", timeSinceLast),
-					zap.Duration("cooldown", cooldown))
-				return fmt.Errorf("cooldown period not elapsed for bot %s", bot)
+func setRuleLogger(r Rule, logger *zap.Logger) {
+	if l, ok := r.(interface{ SetLogger(*zap.Logger) }); ok {
+		l.SetLogger(logger.With(zap.String("rule", r.Name())))
+	}
+}
+
+// Register appends r to the end of the chain, replacing any rule with the
+// same name. Rules are registered and ordered at startup; neither Register
+// nor SetOrder may be called while alerts are evaluated.
+func (g *Guard) Register(r Rule) {
+	setRuleLogger(r, g.logger)
+	if _, ok := g.named[r.Name()]; ok {
+		for i, existing := range g.rules {
+			if existing.Name() == r.Name() {
+				g.rules = append(g.rules[:i], g.rules[i+1:]...)
+				break
 			}
 		}
-
-		g.mu.Lock()
-		g.lastAlert[bot] = time.Now()
-		g.mu.Unlock()
-
-		g.logger.Debug("cooldown check passed",
-			zap.String("bot", bot),
-			zap.Int("cooldown_sec", g.cooldownSec))
 	}
+	g.named[r.Name()] = r
+	g.rules = append(g.rules, r)
+}
 
-	// Check PnL if Prometheus endpoint is available
-	if err := g.checkPnL(bot); err != nil {
-		return err
+// SetOrder limits the chain to the registered rules in names, evaluated in
+// that order.
+func (g *Guard) SetOrder(names []string) error {
+	rules := make([]Rule, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		r, ok := g.named[name]
+		if !ok {
+			return fmt.Errorf("unknown risk rule %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("risk rule %q listed twice", name)
+		}
+		seen[name] = true
+		rules = append(rules, r)
 	}
-
+	g.rules = rules
 	return nil
 }
 
-func (g *Guard) checkPnL(bot string) error {
-	if g.promURL == "" {
-		// Prometheus not configured
-		g.logger.Debug("PnL check skipped - Prometheus not configured",
-			zap.String("bot", bot))
-		return nil
-	}
-
-	query := fmt.Sprintf("pnl{bot=\"%s\"}", bot)
-	endpoint := fmt.Sprintf("%s/api/v1/query?query=%s", g.promURL, url.QueryEscape(query))
-
-	g.logger.Debug("querying Prometheus for PnL",
-		zap.String("bot", bot),
-		zap.String("endpoint", endpoint))
-
-	resp, err := http.Get(endpoint)
-	if err != nil {
-		g.logger.Error("failed to query Prometheus",
-			zap.Error(err),
-			zap.String("bot", bot),
-			zap.String("endpoint", endpoint))
-		return fmt.Errorf("failed to query Prometheus: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		g.logger.Error("Prometheus query failed",
-			zap.Int("status_code", resp.StatusCode),
-			zap.String("bot", bot),
-			zap.String("endpoint", endpoint))
-		return fmt.Errorf("Prometheus query failed with status code %d for endpoint %s", resp.StatusCode, endpoint)
-	}
-
-	var pr struct {
-		Data struct {
-			Result []struct {
-				Value []interface{} `json:"value"`
-			} `json:"result"`
-		} `json:"data"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
-		g.logger.Error("failed to decode Prometheus response",
-			zap.Error(err),
-			zap.String("bot", bot))
-		return fmt.Errorf("failed to decode Prometheus response: %w", err)
+// Rules returns the names of the rules in the chain, in order.
+func (g *Guard) Rules() []string {
+	names := make([]string, len(g.rules))
+	for i, r := range g.rules {
+		names[i] = r.Name()
 	}
+	return names
+}
 
-	if len(pr.Data.Result) == 0 || len(pr.Data.Result[0].Value) < 2 {
-		g.logger.Debug("no PnL data found",
-			zap.String("bot", bot))
-		return nil
+// Evaluate runs every intent of an alert past the kill switch and through
+// the chain. It returns the intents as modified by the rules, or a
+// *Rejection naming the rule that denied one of them. The intents of one
+// call share the reservations of rules that keep state; when one intent is
+// denied the reservations of all of them are released, and the caller
+// passes the returned intents to Release when the alert's orders fail.
+func (g *Guard) Evaluate(intents ...Intent) ([]Intent, error) {
+	for _, in := range intents {
+		if rej := g.checkHalt(in); rej != nil {
+			return nil, rej
+		}
 	}
 
-	valueStr, ok := pr.Data.Result[0].Value[1].(string)
-	if !ok {
-		g.logger.Error("unexpected PnL value type",
-			zap.String("bot", bot),
-			zap.Any("value", pr.Data.Result[0].Value[1]))
-		return fmt.Errorf("unexpected PnL value type")
+	eval := g.evals.Add(1)
+	out := make([]Intent, len(intents))
+	for i, in := range intents {
+		in.eval, in.snap = eval, newSnapshot()
+		for _, r := range g.rules {
+			d := r.Evaluate(in)
+			switch d.Action {
+			case ActionDeny:
+				g.Release(append(out[:i], in)...)
+				return nil, &Rejection{Rule: r.Name(), Reason: d.Reason}
+			case ActionModify:
+				g.logger.Info("risk rule modified order",
+					zap.String("rule", r.Name()),
+					zap.String("bot", in.Bot),
+					zap.String("symbol", in.Symbol),
+					zap.String("reason", d.Reason),
+					zap.String("qty", in.Qty.String()),
+					zap.String("new_qty", d.Intent.Qty.String()))
+				snap := in.snap
+				in = d.Intent
+				in.eval, in.snap = eval, snap
+			}
+		}
+		in.snap = nil
+		out[i] = in
 	}
+	return out, nil
+}
 
-	pnl, err := strconv.ParseFloat(valueStr, 64)
-	if err != nil {
-		g.logger.Error("invalid PnL value",
-			zap.Error(err),
-			zap.String("bot", bot),
-			zap.String("value", valueStr))
-		return fmt.Errorf("invalid PnL value: %w", err)
+// Release undoes the reservations rules made for intents returned by
+// Evaluate, for an alert whose orders were not placed.
+func (g *Guard) Release(intents ...Intent) {
+	for _, r := range g.rules {
+		if rel, ok := r.(Releaser); ok {
+			for _, in := range intents {
+				rel.Release(in)
+			}
+		}
 	}
+}
 
-	g.logger.Debug("PnL check",
-		zap.String("bot", bot),
-		zap.Float64("pnl", pnl),
-		zap.Float64("pnl_max", g.pnlMax),
-		zap.Float64("pnl_min", g.pnlMin))
-
-	if g.pnlMaxSet && pnl > g.pnlMax {
-		g.logger.Warn("PnL exceeds maximum",
-			zap.String("bot", bot),
-			zap.Float64("pnl", pnl),
-			zap.Float64("max", g.pnlMax))
-		return fmt.Errorf("pnl %.2f exceeds max %.2f", pnl, g.pnlMax)
-	}
+// Check evaluates an alert from bot about which nothing else is known.
+func (g *Guard) Check(bot string) error {
+	_, err := g.Evaluate(Intent{Bot: bot})
+	return err
+}
 
-	if g.pnlMin != 0 && pnl < g.pnlMin {
-		g.logger.Warn("PnL below minimum",
-			zap.String("bot", bot),
-			zap.Float64("pnl", pnl),
-			zap.Float64("min", g.pnlMin))
-		return fmt.Errorf("pnl %.2f below min %.2f", pnl, g.pnlMin)
+// RejectedBy returns the name of the rule that produced err, or "" when
+// err is not a rejection.
+func RejectedBy(err error) string {
+	var rej *Rejection
+	if errors.As(err, &rej) {
+		return rej.Rule
 	}
-
-	return nil
+	return ""
 }
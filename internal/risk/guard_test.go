@@ -1,10 +1,16 @@
 package risk
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 func TestGuardCooldown(t *testing.T) {
@@ -25,6 +31,50 @@ func TestGuardCooldown(t *testing.T) {
 	}
 }
 
+func TestGuardCooldownConcurrent(t *testing.T) {
+	g := newTestGuard(t)
+	var passed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.Check("bot") == nil {
+				passed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := passed.Load(); n != 1 {
+		t.Fatalf("expected exactly one concurrent alert to pass, got %d", n)
+	}
+}
+
+func TestGuardRelease(t *testing.T) {
+	g := newTestGuard(t)
+	if err := g.Check("bot"); err != nil {
+		t.Fatalf("first alert failed: %v", err)
+	}
+	failed, err := g.Evaluate(Intent{Bot: "other"}, Intent{Bot: "other"})
+	if err != nil {
+		t.Fatalf("expected the intents of one alert to share the cooldown, got %v", err)
+	}
+
+	// An alert whose order failed gives its place back
+	g.Release(failed...)
+	if err := g.Check("other"); err != nil {
+		t.Fatalf("expected released cooldown to allow bot other, got %v", err)
+	}
+	// Releasing a stale reservation leaves the newer cooldown alone
+	g.Release(failed...)
+	if err := g.Check("other"); RejectedBy(err) != "cooldown" {
+		t.Fatalf("expected cooldown to reject bot other, got %v", err)
+	}
+	if err := g.Check("bot"); RejectedBy(err) != "cooldown" {
+		t.Fatalf("expected cooldown to reject bot, got %v", err)
+	}
+}
+
 func TestGuardCheckPnLPass(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -159,3 +209,102 @@ func TestGuardCheckPnLParseFail(t *testing.T) {
 		t.Fatalf("expected parse error")
 	}
 }
+
+// testRule records the intents it sees and returns decide's verdict.
+type testRule struct {
+	name   string
+	decide func(in Intent) Decision
+	seen   []Intent
+}
+
+func (r *testRule) Name() string { return r.name }
+
+func (r *testRule) Evaluate(in Intent) Decision {
+	r.seen = append(r.seen, in)
+	return r.decide(in)
+}
+
+func newTestGuard(t *testing.T, rules ...Rule) *Guard {
+	t.Helper()
+	t.Setenv("PROM_URL", "")
+	t.Setenv("PNL_MAX", "")
+	t.Setenv("PNL_MIN", "")
+	g := NewGuard("60")
+	for _, r := range rules {
+		g.Register(r)
+	}
+	return g
+}
+
+func TestGuardChain(t *testing.T) {
+	halve := &testRule{name: "halve", decide: func(in Intent) Decision {
+		in.Qty = in.Qty.Div(decimal.NewFromInt(2))
+		return Modify(in, "too large")
+	}}
+	limit := &testRule{name: "limit", decide: func(in Intent) Decision {
+		if in.Qty.GreaterThan(decimal.NewFromInt(4)) {
+			return Deny("qty %s above 4", in.Qty)
+		}
+		return Allow()
+	}}
+	g := newTestGuard(t, halve, limit)
+	if got := strings.Join(g.Rules(), ","); got != "cooldown,pnl,halve,limit" {
+		t.Fatalf("unexpected chain %s", got)
+	}
+
+	out, err := g.Evaluate(Intent{Bot: "a", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(6)})
+	if err != nil {
+		t.Fatalf("expected halved order to pass, got %v", err)
+	}
+	if !out[0].Qty.Equal(decimal.NewFromInt(3)) || !limit.seen[0].Qty.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("expected later rules and the caller to see the modified intent, got %s", out[0].Qty)
+	}
+
+	_, err = g.Evaluate(Intent{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)})
+	var rej *Rejection
+	if !errors.As(err, &rej) || rej.Rule != "limit" || RejectedBy(err) != "limit" {
+		t.Fatalf("expected rejection by limit, got %v", err)
+	}
+	// The rejected alert did not start bot b's cooldown
+	if _, err := g.Evaluate(Intent{Bot: "b", Qty: decimal.NewFromInt(1)}); err != nil {
+		t.Fatalf("expected bot b to be allowed after a rejection, got %v", err)
+	}
+	if err := g.Check("a"); RejectedBy(err) != "cooldown" {
+		t.Fatalf("expected cooldown to reject bot a, got %v", err)
+	}
+}
+
+func TestGuardEvaluateAll(t *testing.T) {
+	deny := &testRule{name: "deny", decide: func(in Intent) Decision {
+		if in.Account == "bad" {
+			return Deny("account %s not allowed", in.Account)
+		}
+		return Allow()
+	}}
+	g := newTestGuard(t, deny)
+	if _, err := g.Evaluate(Intent{Bot: "a", Account: "good"}, Intent{Bot: "a", Account: "bad"}); RejectedBy(err) != "deny" {
+		t.Fatalf("expected one rejected intent to reject the alert, got %v", err)
+	}
+	if _, err := g.Evaluate(Intent{Bot: "a", Account: "good"}, Intent{Bot: "a", Account: "other"}); err != nil {
+		t.Fatalf("expected cooldown not to have started, got %v", err)
+	}
+}
+
+func TestGuardSetOrder(t *testing.T) {
+	g := newTestGuard(t, &testRule{name: "extra", decide: func(Intent) Decision { return Deny("always") }})
+	if err := g.SetOrder([]string{"extra", " cooldown"}); err != nil {
+		t.Fatalf("SetOrder failed: %v", err)
+	}
+	if got := strings.Join(g.Rules(), ","); got != "extra,cooldown" {
+		t.Fatalf("unexpected chain %s", got)
+	}
+	if err := g.SetOrder([]string{"cooldown", "missing"}); err == nil {
+		t.Fatal("expected error for an unknown rule")
+	}
+	if err := g.SetOrder([]string{"cooldown", "cooldown"}); err == nil {
+		t.Fatal("expected error for a repeated rule")
+	}
+	if err := g.SetOrder([]string{"pnl"}); err != nil || g.Check("a") != nil || g.Check("a") != nil {
+		t.Fatalf("expected chain without cooldown to allow repeated alerts, got %v", err)
+	}
+}
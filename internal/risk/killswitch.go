@@ -0,0 +1,207 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// KillSwitchRule names the kill switch in rejections. It runs ahead of the
+// chain and cannot be reordered or left out with SetOrder.
+const KillSwitchRule = "kill_switch"
+
+// Kill switch modes.
+const (
+	// ModeHalt rejects every order, closes included.
+	ModeHalt = "halt"
+	// ModeReduceOnly rejects orders that open, add to or reverse a
+	// position, so bots can still exit.
+	ModeReduceOnly = "reduce_only"
+)
+
+// Halt is one setting of the kill switch.
+type Halt struct {
+	Mode   string    `json:"mode"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// Halts is the state of the kill switch. A bot halted both globally and on
+// its own is held by the stricter of the two.
+type Halts struct {
+	All  *Halt           `json:"all,omitempty"` // applies to every bot
+	Bots map[string]Halt `json:"bots,omitempty"`
+}
+
+// killSwitch holds the guard's halts and the file they are saved to.
+type killSwitch struct {
+	mu    sync.RWMutex
+	halts Halts
+	path  string
+}
+
+// SetHaltFile loads the kill switch state saved at path, if the file
+// exists, and saves every later change there so that halts survive a
+// restart.
+func (g *Guard) SetHaltFile(path string) error {
+	g.kill.mu.Lock()
+	defer g.kill.mu.Unlock()
+
+	g.kill.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read kill switch state: %w", err)
+	}
+	var halts Halts
+	if err := json.Unmarshal(b, &halts); err != nil {
+		return fmt.Errorf("failed to decode kill switch state: %w", err)
+	}
+	g.kill.halts = halts
+	return nil
+}
+
+// Halt engages the kill switch in mode, ModeHalt when empty, for bots or
+// for every bot when bots is empty. The halt takes effect even when it
+// cannot be saved, in which case the error is returned with the new
+// state.
+func (g *Guard) Halt(bots []string, mode, reason string) (Halts, error) {
+	if mode == "" {
+		mode = ModeHalt
+	}
+	if mode != ModeHalt && mode != ModeReduceOnly {
+		return g.Halts(), fmt.Errorf("invalid kill switch mode %q", mode)
+	}
+
+	g.kill.mu.Lock()
+	defer g.kill.mu.Unlock()
+	halt := Halt{Mode: mode, Reason: reason, Since: time.Now().UTC()}
+	if len(bots) == 0 {
+		g.kill.halts.All = &halt
+	} else {
+		if g.kill.halts.Bots == nil {
+			g.kill.halts.Bots = make(map[string]Halt)
+		}
+		for _, bot := range bots {
+			g.kill.halts.Bots[bot] = halt
+		}
+	}
+	g.logger.Warn("kill switch engaged",
+		zap.Strings("bots", bots),
+		zap.String("mode", mode),
+		zap.String("reason", reason))
+	return g.kill.copy(), g.kill.save()
+}
+
+// Resume releases the halts of bots, or every halt when bots is empty. A
+// bot stays halted while a halt of every bot is engaged.
+func (g *Guard) Resume(bots []string) (Halts, error) {
+	g.kill.mu.Lock()
+	defer g.kill.mu.Unlock()
+	if len(bots) == 0 {
+		g.kill.halts = Halts{}
+	}
+	for _, bot := range bots {
+		delete(g.kill.halts.Bots, bot)
+	}
+	g.logger.Warn("kill switch released", zap.Strings("bots", bots))
+	return g.kill.copy(), g.kill.save()
+}
+
+// Halts returns the state of the kill switch.
+func (g *Guard) Halts() Halts {
+	g.kill.mu.RLock()
+	defer g.kill.mu.RUnlock()
+	return g.kill.copy()
+}
+
+// checkHalt denies in when its bot is halted, or when it is in reduce-only
+// mode and in does not shrink a position.
+func (g *Guard) checkHalt(in Intent) *Rejection {
+	g.kill.mu.RLock()
+	halt := g.kill.halts.All
+	if h, ok := g.kill.halts.Bots[in.Bot]; ok && (halt == nil || halt.Mode != ModeHalt) {
+		halt = &h
+	}
+	g.kill.mu.RUnlock()
+
+	if halt == nil {
+		return nil
+	}
+	if halt.Mode == ModeReduceOnly {
+		if in.Closing {
+			return nil
+		}
+		reducing, err := reducesPosition(in)
+		if err != nil {
+			return &Rejection{Rule: KillSwitchRule, Reason: fmt.Sprintf("failed to get position: %v", err)}
+		}
+		if reducing {
+			return nil
+		}
+	}
+
+	reason := fmt.Sprintf("trading is halted for bot %s", in.Bot)
+	if halt.Mode == ModeReduceOnly {
+		reason = fmt.Sprintf("bot %s is reduce-only", in.Bot)
+	}
+	if halt.Reason != "" {
+		reason += ": " + halt.Reason
+	}
+	return &Rejection{Rule: KillSwitchRule, Reason: reason}
+}
+
+// copy returns a copy of the halts that is safe to hand out. The caller
+// holds mu.
+func (k *killSwitch) copy() Halts {
+	halts := Halts{}
+	if k.halts.All != nil {
+		all := *k.halts.All
+		halts.All = &all
+	}
+	if len(k.halts.Bots) > 0 {
+		halts.Bots = make(map[string]Halt, len(k.halts.Bots))
+		for bot, h := range k.halts.Bots {
+			halts.Bots[bot] = h
+		}
+	}
+	return halts
+}
+
+// save writes the halts to the state file, if configured, via a temporary
+// file so a crash never leaves it half written. The caller holds mu.
+func (k *killSwitch) save() error {
+	if k.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(k.halts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode kill switch state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save kill switch state: %w", err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save kill switch state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save kill switch state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), k.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save kill switch state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package risk
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestGuardHalt(t *testing.T) {
+	t.Setenv("PROM_URL", "")
+	g := NewGuard("0")
+	buy := Intent{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1)}
+
+	if _, err := g.Halt(nil, "pause", ""); err == nil {
+		t.Fatalf("expected invalid mode to be refused")
+	}
+	halts, err := g.Halt([]string{"b"}, "", "incident")
+	if err != nil || halts.Bots["b"].Mode != ModeHalt || halts.All != nil {
+		t.Fatalf("unexpected halts %+v %v", halts, err)
+	}
+	_, err = g.Evaluate(buy)
+	if RejectedBy(err) != KillSwitchRule || err.Error() != "trading is halted for bot b: incident" {
+		t.Fatalf("expected kill switch rejection, got %v", err)
+	}
+	if err := g.Check("other"); err != nil {
+		t.Fatalf("expected other bot to trade, got %v", err)
+	}
+	// A full halt also stops closes
+	if _, err := g.Evaluate(Intent{Bot: "b", Symbol: "AAPL", Side: "sell", Closing: true}); RejectedBy(err) != KillSwitchRule {
+		t.Fatalf("expected close to be halted, got %v", err)
+	}
+
+	if _, err := g.Halt(nil, ModeHalt, ""); err != nil {
+		t.Fatalf("Halt failed: %v", err)
+	}
+	if err := g.Check("other"); RejectedBy(err) != KillSwitchRule {
+		t.Fatalf("expected every bot to be halted, got %v", err)
+	}
+	// Releasing one bot leaves the global halt in place
+	if _, err := g.Resume([]string{"b"}); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if err := g.Check("b"); RejectedBy(err) != KillSwitchRule {
+		t.Fatalf("expected global halt to hold bot b, got %v", err)
+	}
+	halts, _ = g.Resume(nil)
+	if halts.All != nil || len(halts.Bots) != 0 {
+		t.Fatalf("expected every halt released, got %+v", halts)
+	}
+	if _, err := g.Evaluate(buy); err != nil {
+		t.Fatalf("expected trading to resume, got %v", err)
+	}
+}
+
+func TestGuardHaltReduceOnly(t *testing.T) {
+	t.Setenv("PROM_URL", "")
+	g := NewGuard("0")
+	broker := newLimitBroker(t, 10)
+	if _, err := g.Halt(nil, ModeReduceOnly, ""); err != nil {
+		t.Fatalf("Halt failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		in   Intent
+		ok   bool
+	}{
+		{"add", Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1)}, false},
+		{"reduce", Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(4)}, true},
+		{"flatten", Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(10)}, true},
+		{"reverse", Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(11)}, false},
+		{"close", Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Closing: true}, true},
+		{"open", Intent{Bot: "b", Broker: broker, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(1)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := g.Evaluate(tt.in)
+			if (err == nil) != tt.ok {
+				t.Fatalf("expected allowed=%v, got %v", tt.ok, err)
+			}
+		})
+	}
+
+	// A full halt of one bot is stricter than the global reduce-only
+	g.Halt([]string{"b"}, ModeHalt, "")
+	if _, err := g.Evaluate(tests[1].in); RejectedBy(err) != KillSwitchRule {
+		t.Fatalf("expected bot halt to win, got %v", err)
+	}
+}
+
+func TestGuardHaltFile(t *testing.T) {
+	t.Setenv("PROM_URL", "")
+	path := filepath.Join(t.TempDir(), "halts.json")
+
+	g := NewGuard("0")
+	if err := g.SetHaltFile(path); err != nil {
+		t.Fatalf("SetHaltFile failed: %v", err)
+	}
+	if _, err := g.Halt([]string{"b"}, ModeReduceOnly, "drawdown"); err != nil {
+		t.Fatalf("Halt failed: %v", err)
+	}
+
+	restarted := NewGuard("0")
+	if err := restarted.SetHaltFile(path); err != nil {
+		t.Fatalf("SetHaltFile failed: %v", err)
+	}
+	if h := restarted.Halts().Bots["b"]; h.Mode != ModeReduceOnly || h.Reason != "drawdown" {
+		t.Fatalf("expected halt to survive a restart, got %+v", restarted.Halts())
+	}
+}
@@ -0,0 +1,405 @@
+package risk
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// Limits caps the size of orders and positions. Nil fields are not
+// enforced.
+type Limits struct {
+	// MaxQty and MaxNotional cap a single order in shares and dollars.
+	MaxQty      *decimal.Decimal
+	MaxNotional *decimal.Decimal
+	// MaxPosition caps the absolute position in the symbol once the order
+	// fills.
+	MaxPosition *decimal.Decimal
+	// MaxExposure caps gross market value, long and short positions alike,
+	// once the order fills: the account's when set globally, and that of
+	// the positions the bot opened when set for a bot; see ExposureLimit.
+	// It is not looked up per symbol.
+	MaxExposure *decimal.Decimal
+	// MaxDailyLoss stops new entries once the day's loss reaches it; see
+	// DailyLoss. It is not looked up per symbol.
+	MaxDailyLoss *decimal.Decimal
+}
+
+// Or returns l with its unset fields taken from fallback.
+func (l Limits) Or(fallback Limits) Limits {
+	if l.MaxQty == nil {
+		l.MaxQty = fallback.MaxQty
+	}
+	if l.MaxNotional == nil {
+		l.MaxNotional = fallback.MaxNotional
+	}
+	if l.MaxPosition == nil {
+		l.MaxPosition = fallback.MaxPosition
+	}
+	if l.MaxExposure == nil {
+		l.MaxExposure = fallback.MaxExposure
+	}
+	if l.MaxDailyLoss == nil {
+		l.MaxDailyLoss = fallback.MaxDailyLoss
+	}
+	return l
+}
+
+// LimitTable holds limits per bot and symbol. Each field is looked up
+// separately, from the most specific setting to the least: the bot's
+// symbol, the bot, the symbol, and then Default. Symbols match regardless
+// of case.
+type LimitTable struct {
+	Default    Limits
+	Symbols    map[string]Limits
+	Bots       map[string]Limits
+	BotSymbols map[string]map[string]Limits
+}
+
+// For returns the limits that apply to bot's orders in symbol.
+func (t *LimitTable) For(bot, symbol string) Limits {
+	if t == nil {
+		return Limits{}
+	}
+	l := lookupSymbol(t.BotSymbols[bot], symbol)
+	l = l.Or(t.Bots[bot])
+	l = l.Or(lookupSymbol(t.Symbols, symbol))
+	return l.Or(t.Default)
+}
+
+func lookupSymbol(limits map[string]Limits, symbol string) Limits {
+	if l, ok := limits[symbol]; ok {
+		return l
+	}
+	for s, l := range limits {
+		if strings.EqualFold(s, symbol) {
+			return l
+		}
+	}
+	return Limits{}
+}
+
+// OrderLimit denies orders above the max_qty or max_notional of their bot
+// and symbol.
+type OrderLimit struct {
+	logger *zap.Logger
+	limits *LimitTable
+}
+
+// NewOrderLimit returns an order size rule for limits.
+func NewOrderLimit(limits *LimitTable) *OrderLimit {
+	return &OrderLimit{logger: zap.NewNop(), limits: limits}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (o *OrderLimit) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		o.logger = logger
+	}
+}
+
+// Name implements Rule.
+func (o *OrderLimit) Name() string { return "max_order" }
+
+// Evaluate implements Rule. Closes are allowed, since they only reduce the
+// position. An order whose size in the capped unit cannot be worked out,
+// such as a notional order without a known price under max_qty, is denied.
+func (o *OrderLimit) Evaluate(in Intent) Decision {
+	lim := o.limits.For(in.Bot, in.Symbol)
+	if in.Closing || (lim.MaxQty == nil && lim.MaxNotional == nil) {
+		return Allow()
+	}
+
+	pos, err := openPosition(in)
+	if err != nil {
+		return Deny("failed to get position: %v", err)
+	}
+	price := markPrice(in, pos)
+
+	if lim.MaxQty != nil {
+		qty, ok := orderQty(in, price)
+		if !ok {
+			return Deny("order size of %s unknown: max_qty needs a price", in.Symbol)
+		}
+		if qty.GreaterThan(*lim.MaxQty) {
+			o.logger.Warn("order qty exceeds limit",
+				zap.String("bot", in.Bot),
+				zap.String("symbol", in.Symbol),
+				zap.String("qty", qty.String()),
+				zap.String("max_qty", lim.MaxQty.String()))
+			return Deny("qty %s exceeds max_qty %s for %s", qty, lim.MaxQty, in.Symbol)
+		}
+	}
+	if lim.MaxNotional != nil {
+		notional, ok := orderNotional(in, price)
+		if !ok {
+			return Deny("order value of %s unknown: max_notional needs a price", in.Symbol)
+		}
+		if notional.GreaterThan(*lim.MaxNotional) {
+			o.logger.Warn("order notional exceeds limit",
+				zap.String("bot", in.Bot),
+				zap.String("symbol", in.Symbol),
+				zap.String("notional", notional.String()),
+				zap.String("max_notional", lim.MaxNotional.String()))
+			return Deny("notional %s exceeds max_notional %s for %s", notional, lim.MaxNotional, in.Symbol)
+		}
+	}
+	return Allow()
+}
+
+// PositionLimit denies orders that would leave the position in their
+// symbol above max_position. Orders that shrink the position are allowed.
+type PositionLimit struct {
+	logger *zap.Logger
+	limits *LimitTable
+}
+
+// NewPositionLimit returns a position size rule for limits.
+func NewPositionLimit(limits *LimitTable) *PositionLimit {
+	return &PositionLimit{logger: zap.NewNop(), limits: limits}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (p *PositionLimit) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		p.logger = logger
+	}
+}
+
+// Name implements Rule.
+func (p *PositionLimit) Name() string { return "max_position" }
+
+// Evaluate implements Rule. The current position is read from the intent's
+// broker; without one the bot is taken to be flat.
+func (p *PositionLimit) Evaluate(in Intent) Decision {
+	lim := p.limits.For(in.Bot, in.Symbol)
+	if in.Closing || lim.MaxPosition == nil {
+		return Allow()
+	}
+
+	pos, err := openPosition(in)
+	if err != nil {
+		return Deny("failed to get position: %v", err)
+	}
+	qty, ok := orderQty(in, markPrice(in, pos))
+	if !ok {
+		return Deny("order size of %s unknown: max_position needs a price", in.Symbol)
+	}
+
+	current := decimal.Zero
+	if pos != nil {
+		current = pos.Qty
+	}
+	after := current.Add(signed(in.Side, qty))
+	if after.Abs().GreaterThan(*lim.MaxPosition) && after.Abs().GreaterThan(current.Abs()) {
+		p.logger.Warn("position exceeds limit",
+			zap.String("bot", in.Bot),
+			zap.String("symbol", in.Symbol),
+			zap.String("current", current.String()),
+			zap.String("after", after.String()),
+			zap.String("max_position", lim.MaxPosition.String()))
+		return Deny("position %s in %s would exceed max_position %s", after, in.Symbol, lim.MaxPosition)
+	}
+	return Allow()
+}
+
+// ExposureLimit denies orders that would raise gross exposure, longs plus
+// shorts at market value, above max_exposure. The global limit applies to
+// the account's gross market value. A bot's own limit applies to the
+// positions its filled orders opened, found by the bot name in their
+// client order IDs. Orders that shrink a position are allowed.
+type ExposureLimit struct {
+	logger *zap.Logger
+	limits *LimitTable
+}
+
+// NewExposureLimit returns a gross exposure rule for limits.
+func NewExposureLimit(limits *LimitTable) *ExposureLimit {
+	return &ExposureLimit{logger: zap.NewNop(), limits: limits}
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (e *ExposureLimit) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		e.logger = logger
+	}
+}
+
+// Name implements Rule.
+func (e *ExposureLimit) Name() string { return "max_exposure" }
+
+// Evaluate implements Rule. Exposure is read from the intent's broker
+// account; without a broker the rule allows the order.
+func (e *ExposureLimit) Evaluate(in Intent) Decision {
+	var accountMax, botMax *decimal.Decimal
+	if e.limits != nil {
+		accountMax = e.limits.Default.MaxExposure
+		botMax = e.limits.Bots[in.Bot].MaxExposure
+	}
+	if in.Closing || in.Broker == nil || (accountMax == nil && botMax == nil) {
+		return Allow()
+	}
+
+	pos, err := openPosition(in)
+	if err != nil {
+		return Deny("failed to get position: %v", err)
+	}
+	price := markPrice(in, pos)
+	qty, ok := orderQty(in, price)
+	if !ok || price == nil {
+		return Deny("order value of %s unknown: max_exposure needs a price", in.Symbol)
+	}
+
+	if accountMax != nil {
+		current := decimal.Zero
+		if pos != nil {
+			current = pos.Qty
+		}
+		if added := addedQty(current, in.Side, qty); added.IsPositive() {
+			acct, err := accountOf(in)
+			if err != nil {
+				return Deny("failed to get account: %v", err)
+			}
+			gross := acct.LongMarketValue.Add(acct.ShortMarketValue.Abs())
+			total := gross.Add(added.Mul(*price))
+			if total.GreaterThan(*accountMax) {
+				e.logger.Warn("account exposure exceeds limit",
+					zap.String("bot", in.Bot),
+					zap.String("account", in.Account),
+					zap.String("exposure", gross.String()),
+					zap.String("after", total.String()),
+					zap.String("max_exposure", accountMax.String()))
+				return Deny("account gross exposure %s would exceed max_exposure %s", total.StringFixed(2), accountMax)
+			}
+		}
+	}
+
+	if botMax != nil {
+		gross, held, err := botExposure(in)
+		if err != nil {
+			return Deny("failed to compute exposure: %v", err)
+		}
+		current := held[strings.ReplaceAll(in.Symbol, "/", "")]
+		if added := addedQty(current, in.Side, qty); added.IsPositive() {
+			total := gross.Add(added.Mul(*price))
+			if total.GreaterThan(*botMax) {
+				e.logger.Warn("bot exposure exceeds limit",
+					zap.String("bot", in.Bot),
+					zap.String("account", in.Account),
+					zap.String("exposure", gross.String()),
+					zap.String("after", total.String()),
+					zap.String("max_exposure", botMax.String()))
+				return Deny("bot %s gross exposure %s would exceed max_exposure %s", in.Bot, total.StringFixed(2), botMax)
+			}
+		}
+	}
+	return Allow()
+}
+
+// addedQty returns how much an order of qty on side grows the absolute
+// size of current, a signed position; only the part of the order beyond
+// the current position adds exposure.
+func addedQty(current decimal.Decimal, side string, qty decimal.Decimal) decimal.Decimal {
+	after := current.Add(signed(side, qty))
+	return after.Abs().Sub(current.Abs())
+}
+
+// botExposure returns the gross market value of the part of each open
+// position in the intent's account that in.Bot's filled orders opened, and
+// that part per symbol, keyed by the symbol without the slash of crypto
+// pairs.
+func botExposure(in Intent) (decimal.Decimal, map[string]decimal.Decimal, error) {
+	orders, err := ordersOf(in, time.Time{})
+	if err != nil {
+		return decimal.Zero, nil, err
+	}
+	filled := make(map[string]decimal.Decimal)
+	var fill func(o alpaca.Order)
+	fill = func(o alpaca.Order) {
+		if o.FilledQty.IsPositive() {
+			symbol := strings.ReplaceAll(o.Symbol, "/", "")
+			filled[symbol] = filled[symbol].Add(signed(string(o.Side), o.FilledQty))
+		}
+		for _, leg := range o.Legs {
+			fill(leg)
+		}
+	}
+	for _, o := range orders {
+		if bot, ok := adapter.ClientOrderBot(o.ClientOrderID); ok && bot == in.Bot {
+			fill(o)
+		}
+	}
+
+	positions, err := positionsOf(in)
+	if err != nil {
+		return decimal.Zero, nil, err
+	}
+	// The bot holds no more of a position than the account does, and none
+	// of one its fills point the other way
+	gross := decimal.Zero
+	held := make(map[string]decimal.Decimal)
+	for _, pos := range positions {
+		symbol := strings.ReplaceAll(pos.Symbol, "/", "")
+		own := filled[symbol]
+		if own.Sign() != pos.Qty.Sign() {
+			continue
+		}
+		if pos.Qty.Abs().LessThan(own.Abs()) {
+			own = pos.Qty
+		}
+		held[symbol] = own
+		if pos.MarketValue != nil {
+			gross = gross.Add(pos.MarketValue.Abs().Mul(own.Div(pos.Qty)))
+		}
+	}
+	return gross, held, nil
+}
+
+// markPrice returns the price an intent is valued at: its own price, or
+// else the current price of the open position.
+func markPrice(in Intent, pos *alpaca.Position) *decimal.Decimal {
+	if in.Price != nil {
+		return in.Price
+	}
+	if pos != nil {
+		return pos.CurrentPrice
+	}
+	return nil
+}
+
+// orderQty returns the intent's size in shares, converting a notional
+// order at price.
+func orderQty(in Intent, price *decimal.Decimal) (decimal.Decimal, bool) {
+	if in.Notional == nil {
+		return in.Qty, true
+	}
+	if price == nil || !price.IsPositive() {
+		return decimal.Zero, false
+	}
+	return in.Notional.Div(*price), true
+}
+
+// orderNotional returns the intent's size in dollars, valuing a quantity
+// order at price.
+func orderNotional(in Intent, price *decimal.Decimal) (decimal.Decimal, bool) {
+	if in.Notional != nil {
+		return *in.Notional, true
+	}
+	if price == nil {
+		return decimal.Zero, false
+	}
+	return in.Qty.Mul(*price), true
+}
+
+// signed returns qty as a change in position: negative for sells.
+func signed(side string, qty decimal.Decimal) decimal.Decimal {
+	if strings.EqualFold(side, "sell") {
+		return qty.Neg()
+	}
+	return qty
+}
@@ -0,0 +1,213 @@
+package risk
+
+import (
+	"testing"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+func dec(v int64) *decimal.Decimal {
+	d := decimal.NewFromInt(v)
+	return &d
+}
+
+// newLimitBroker returns a simulator holding qty shares of AAPL at 100.
+func newLimitBroker(t *testing.T, qty int64) *adapter.SimBroker {
+	t.Helper()
+	b, err := adapter.NewSimBroker(decimal.NewFromInt(100000), "")
+	if err != nil {
+		t.Fatalf("NewSimBroker: %v", err)
+	}
+	b.Tick("AAPL", decimal.NewFromInt(100))
+	if qty != 0 {
+		if _, err := b.PlaceOrder(adapter.OrderRequest{Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(qty)}); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+	}
+	return b
+}
+
+func TestLimitTableFor(t *testing.T) {
+	table := &LimitTable{
+		Default:    Limits{MaxQty: dec(100), MaxExposure: dec(1000000)},
+		Symbols:    map[string]Limits{"TSLA": {MaxQty: dec(10)}},
+		Bots:       map[string]Limits{"b": {MaxQty: dec(50), MaxNotional: dec(5000)}},
+		BotSymbols: map[string]map[string]Limits{"b": {"AAPL": {MaxPosition: dec(20)}}},
+	}
+
+	l := table.For("b", "aapl")
+	if !l.MaxQty.Equal(*dec(50)) || !l.MaxNotional.Equal(*dec(5000)) || !l.MaxPosition.Equal(*dec(20)) || !l.MaxExposure.Equal(*dec(1000000)) {
+		t.Fatalf("unexpected limits for b/AAPL %+v", l)
+	}
+	// The bot's own max_qty wins over the symbol's
+	if l := table.For("b", "TSLA"); !l.MaxQty.Equal(*dec(50)) || l.MaxPosition != nil {
+		t.Fatalf("unexpected limits for b/TSLA %+v", l)
+	}
+	if l := table.For("other", "TSLA"); !l.MaxQty.Equal(*dec(10)) || l.MaxNotional != nil {
+		t.Fatalf("unexpected limits for other/TSLA %+v", l)
+	}
+	var empty *LimitTable
+	if l := empty.For("b", "AAPL"); l.MaxQty != nil {
+		t.Fatalf("expected no limits from a nil table, got %+v", l)
+	}
+}
+
+func TestOrderLimit(t *testing.T) {
+	rule := NewOrderLimit(&LimitTable{Default: Limits{MaxQty: dec(10), MaxNotional: dec(2000)}})
+	price := dec(150)
+
+	tests := []struct {
+		name string
+		in   Intent
+		want Action
+	}{
+		{"within", Intent{Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10), Price: price}, ActionAllow},
+		{"qty", Intent{Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(11), Price: price}, ActionDeny},
+		{"notional", Intent{Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(9), Price: dec(250)}, ActionDeny},
+		{"notional order", Intent{Symbol: "AAPL", Side: "buy", Notional: dec(1500), Price: price}, ActionAllow},
+		{"no price", Intent{Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(1)}, ActionDeny},
+		{"close", Intent{Symbol: "AAPL", Side: "sell", Closing: true}, ActionAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := rule.Evaluate(tt.in); d.Action != tt.want {
+				t.Fatalf("expected %s, got %s: %s", tt.want, d.Action, d.Reason)
+			}
+		})
+	}
+
+	// The open position's price values orders that carry none
+	broker := newLimitBroker(t, 5)
+	if d := rule.Evaluate(Intent{Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)}); d.Action != ActionAllow {
+		t.Fatalf("expected order valued at the position's price to pass, got %s: %s", d.Action, d.Reason)
+	}
+}
+
+func TestPositionLimit(t *testing.T) {
+	rule := NewPositionLimit(&LimitTable{BotSymbols: map[string]map[string]Limits{"b": {"AAPL": {MaxPosition: dec(20)}}}})
+	broker := newLimitBroker(t, 15)
+
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(5)}); d.Action != ActionAllow {
+		t.Fatalf("expected position of 20 to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(6)}); d.Action != ActionDeny {
+		t.Fatalf("expected position of 21 to be denied, got %s", d.Action)
+	}
+	// Flipping to a short of 40 grows the position past the cap
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(55)}); d.Action != ActionDeny {
+		t.Fatalf("expected reversal past the cap to be denied, got %s", d.Action)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(10)}); d.Action != ActionAllow {
+		t.Fatalf("expected reducing order to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "other", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(100)}); d.Action != ActionAllow {
+		t.Fatalf("expected bot without a cap to pass, got %s: %s", d.Action, d.Reason)
+	}
+
+	// A position already over the cap may still be reduced
+	big := newLimitBroker(t, 30)
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: big, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(5)}); d.Action != ActionAllow {
+		t.Fatalf("expected reducing an oversized position to pass, got %s: %s", d.Action, d.Reason)
+	}
+}
+
+func TestExposureLimit(t *testing.T) {
+	rule := NewExposureLimit(&LimitTable{Bots: map[string]Limits{"b": {MaxExposure: dec(3000)}}})
+	broker := newLimitBroker(t, 0)
+	broker.Tick("MSFT", decimal.NewFromInt(100))
+	for _, req := range []adapter.OrderRequest{
+		{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(20)},   // 2000 long
+		{Bot: "b-2", Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(50)}, // another bot's 5000
+	} {
+		if _, err := broker.PlaceOrder(req); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+	}
+
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)}); d.Action != ActionAllow {
+		t.Fatalf("expected exposure of 3000 to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Notional: dec(1100)}); d.Action != ActionDeny {
+		t.Fatalf("expected exposure of 3100 to be denied, got %s", d.Action)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "TSLA", Side: "sell", Qty: decimal.NewFromInt(5), Price: dec(250)}); d.Action != ActionDeny {
+		t.Fatalf("expected a short to add to gross exposure, got %s", d.Action)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "sell", Qty: decimal.NewFromInt(20)}); d.Action != ActionAllow {
+		t.Fatalf("expected closing sell to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "TSLA", Side: "buy", Qty: decimal.NewFromInt(1)}); d.Action != ActionDeny {
+		t.Fatalf("expected order without a price to be denied, got %s", d.Action)
+	}
+	// MSFT is b-2's, so selling it would open a short for b
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "MSFT", Side: "sell", Qty: decimal.NewFromInt(20)}); d.Action != ActionDeny {
+		t.Fatalf("expected b's sell of another bot's shares to add exposure, got %s", d.Action)
+	}
+	// b-2's exposure is its own, and it has no cap
+	if d := rule.Evaluate(Intent{Bot: "b-2", Broker: broker, Symbol: "MSFT", Side: "buy", Qty: decimal.NewFromInt(50)}); d.Action != ActionAllow {
+		t.Fatalf("expected bot without a cap to pass, got %s: %s", d.Action, d.Reason)
+	}
+}
+
+func TestExposureLimitAccount(t *testing.T) {
+	rule := NewExposureLimit(&LimitTable{Default: Limits{MaxExposure: dec(3000)}})
+	broker := newLimitBroker(t, 20) // 2000 long, placed by no bot
+
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(10)}); d.Action != ActionAllow {
+		t.Fatalf("expected account exposure of 3000 to pass, got %s: %s", d.Action, d.Reason)
+	}
+	if d := rule.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(11)}); d.Action != ActionDeny {
+		t.Fatalf("expected account exposure of 3100 to be denied, got %s", d.Action)
+	}
+}
+
+// countingBroker counts the reads the risk rules make.
+type countingBroker struct {
+	*adapter.SimBroker
+	positions, accounts int
+}
+
+func (c *countingBroker) GetPosition(symbol string) (*alpaca.Position, error) {
+	c.positions++
+	return c.SimBroker.GetPosition(symbol)
+}
+
+func (c *countingBroker) GetAccount() (*alpaca.Account, error) {
+	c.accounts++
+	return c.SimBroker.GetAccount()
+}
+
+func TestGuardSharesBrokerReads(t *testing.T) {
+	t.Setenv("PROM_URL", "")
+	g := NewGuard("0")
+	limits := &LimitTable{Default: Limits{MaxQty: dec(100), MaxPosition: dec(100), MaxExposure: dec(100000), MaxDailyLoss: dec(1000)}}
+	g.Register(NewOrderLimit(limits))
+	g.Register(NewPositionLimit(limits))
+	g.Register(NewExposureLimit(limits))
+	g.Register(NewDailyLoss(limits))
+	broker := &countingBroker{SimBroker: newLimitBroker(t, 10)}
+
+	if _, err := g.Evaluate(Intent{Bot: "b", Broker: broker, Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(5)}); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if broker.positions != 1 || broker.accounts != 1 {
+		t.Fatalf("expected one position and one account read, got %d and %d", broker.positions, broker.accounts)
+	}
+}
+
+func TestGuardLimits(t *testing.T) {
+	t.Setenv("PROM_URL", "")
+	g := NewGuard("0")
+	limits := &LimitTable{Default: Limits{MaxQty: dec(10)}}
+	g.Register(NewOrderLimit(limits))
+	g.Register(NewPositionLimit(limits))
+	g.Register(NewExposureLimit(limits))
+
+	_, err := g.Evaluate(Intent{Bot: "b", Symbol: "AAPL", Side: "buy", Qty: decimal.NewFromInt(11)})
+	if RejectedBy(err) != "max_order" {
+		t.Fatalf("expected max_order rejection, got %v", err)
+	}
+}
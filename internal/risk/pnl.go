@@ -0,0 +1,145 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// PrometheusPnL denies alerts from bots whose pnl{bot="..."} series in
+// Prometheus is above max or below min.
+type PrometheusPnL struct {
+	logger  *zap.Logger
+	promURL string
+	max     float64
+	min     float64
+	maxSet  bool
+}
+
+// NewPrometheusPnL returns a PnL rule querying promURL. Without a URL the
+// rule allows every alert; a zero min is not enforced.
+func NewPrometheusPnL(promURL string, max *float64, min float64) *PrometheusPnL {
+	p := &PrometheusPnL{logger: zap.NewNop(), promURL: promURL, min: min}
+	if max != nil {
+		p.max, p.maxSet = *max, true
+	}
+	return p
+}
+
+// SetLogger allows injecting a custom logger for debugging.
+func (p *PrometheusPnL) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		p.logger = logger
+	}
+}
+
+// Name implements Rule.
+func (p *PrometheusPnL) Name() string { return "pnl" }
+
+// Evaluate implements Rule. Failing to query Prometheus denies the alert.
+func (p *PrometheusPnL) Evaluate(in Intent) Decision {
+	if err := p.check(in.Bot); err != nil {
+		return Deny("%s", err)
+	}
+	return Allow()
+}
+
+func (p *PrometheusPnL) check(bot string) error {
+	if p.promURL == "" {
+		// Prometheus not configured
+		p.logger.Debug("PnL check skipped - Prometheus not configured",
+			zap.String("bot", bot))
+		return nil
+	}
+
+	query := fmt.Sprintf("pnl{bot=\"%s\"}", bot)
+	endpoint := fmt.Sprintf("%s/api/v1/query?query=%s", p.promURL, url.QueryEscape(query))
+
+	p.logger.Debug("querying Prometheus for PnL",
+		zap.String("bot", bot),
+		zap.String("endpoint", endpoint))
+
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		p.logger.Error("failed to query Prometheus",
+			zap.Error(err),
+			zap.String("bot", bot),
+			zap.String("endpoint", endpoint))
+		return fmt.Errorf("failed to query Prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		p.logger.Error("Prometheus query failed",
+			zap.Int("status_code", resp.StatusCode),
+			zap.String("bot", bot),
+			zap.String("endpoint", endpoint))
+		return fmt.Errorf("Prometheus query failed with status code %d for endpoint %s", resp.StatusCode, endpoint)
+	}
+
+	var pr struct {
+		Data struct {
+			Result []struct {
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		p.logger.Error("failed to decode Prometheus response",
+			zap.Error(err),
+			zap.String("bot", bot))
+		return fmt.Errorf("failed to decode Prometheus response: %w", err)
+	}
+
+	if len(pr.Data.Result) == 0 || len(pr.Data.Result[0].Value) < 2 {
+		p.logger.Debug("no PnL data found",
+			zap.String("bot", bot))
+		return nil
+	}
+
+	valueStr, ok := pr.Data.Result[0].Value[1].(string)
+	if !ok {
+		p.logger.Error("unexpected PnL value type",
+			zap.String("bot", bot),
+			zap.Any("value", pr.Data.Result[0].Value[1]))
+		return fmt.Errorf("unexpected PnL value type")
+	}
+
+	pnl, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		p.logger.Error("invalid PnL value",
+			zap.Error(err),
+			zap.String("bot", bot),
+			zap.String("value", valueStr))
+		return fmt.Errorf("invalid PnL value: %w", err)
+	}
+
+	p.logger.Debug("PnL check",
+		zap.String("bot", bot),
+		zap.Float64("pnl", pnl),
+		zap.Float64("pnl_max", p.max),
+		zap.Float64("pnl_min", p.min))
+
+	if p.maxSet && pnl > p.max {
+		p.logger.Warn("PnL exceeds maximum",
+			zap.String("bot", bot),
+			zap.Float64("pnl", pnl),
+			zap.Float64("max", p.max))
+		return fmt.Errorf("pnl %.2f exceeds max %.2f", pnl, p.max)
+	}
+
+	if p.min != 0 && pnl < p.min {
+		p.logger.Warn("PnL below minimum",
+			zap.String("bot", bot),
+			zap.Float64("pnl", pnl),
+			zap.Float64("min", p.min))
+		return fmt.Errorf("pnl %.2f below min %.2f", pnl, p.min)
+	}
+
+	return nil
+}
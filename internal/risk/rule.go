@@ -0,0 +1,112 @@
+package risk
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// Intent is an order an alert asks for, as seen by the risk rules.
+type Intent struct {
+	Bot     string
+	Account string // account name, empty for the default broker
+	// Broker is the account's broker, for rules that look at positions or
+	// balances. It is nil when the guard is called without one.
+	Broker adapter.Broker
+
+	Symbol string
+	Side   string // buy or sell
+	// Qty is the order quantity. It is zero for notional orders and for
+	// closes, whose size is taken from the open position.
+	Qty      decimal.Decimal
+	Notional *decimal.Decimal
+	Type     string
+	// Price is the limit price, or else the alert's reference price. It is
+	// nil when neither is known.
+	Price *decimal.Decimal
+	// Closing is set for alerts that close all or part of the open
+	// position.
+	Closing bool
+	DryRun  bool
+
+	eval uint64    // the Guard.Evaluate call the intent belongs to, 0 outside one
+	snap *snapshot // the broker reads of the evaluation, nil outside one
+}
+
+// Action is a rule's verdict on an intent.
+type Action int
+
+const (
+	// ActionAllow passes the intent on to the next rule.
+	ActionAllow Action = iota
+	// ActionDeny rejects the alert.
+	ActionDeny
+	// ActionModify replaces the intent, e.g. with a smaller quantity, and
+	// passes the replacement on to the next rule.
+	ActionModify
+)
+
+// String returns the action's name.
+func (a Action) String() string {
+	switch a {
+	case ActionAllow:
+		return "allow"
+	case ActionDeny:
+		return "deny"
+	case ActionModify:
+		return "modify"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Decision is a rule's verdict on an intent with its reason.
+type Decision struct {
+	Action Action
+	Reason string
+	Intent Intent // the replacement intent when Action is ActionModify
+}
+
+// Allow passes an intent unchanged.
+func Allow() Decision {
+	return Decision{Action: ActionAllow}
+}
+
+// Deny rejects an intent with a formatted reason.
+func Deny(format string, args ...interface{}) Decision {
+	return Decision{Action: ActionDeny, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Modify replaces an intent with in.
+func Modify(in Intent, reason string) Decision {
+	return Decision{Action: ActionModify, Reason: reason, Intent: in}
+}
+
+// Rule is one check in the guard's chain. Rules must be safe for
+// concurrent use.
+type Rule interface {
+	// Name identifies the rule in logs, metrics and error responses.
+	Name() string
+	// Evaluate decides whether in may be placed.
+	Evaluate(in Intent) Decision
+}
+
+// Releaser is implemented by rules that reserve state for the intents they
+// allow, such as the cooldown, which starts in Evaluate so that concurrent
+// alerts cannot both pass. Release undoes the reservation made for in when
+// a later rule rejects the alert or its order fails, and does nothing when
+// there is none.
+type Releaser interface {
+	Release(in Intent)
+}
+
+// Rejection is the error returned when a rule denies an intent.
+type Rejection struct {
+	Rule   string
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
@@ -0,0 +1,110 @@
+package risk
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+
+	"github.com/njdaniel/alertbridge/internal/adapter"
+)
+
+// snapshot holds what the rules of one evaluation have read from an
+// intent's broker, so that each is asked for once however many rules look
+// at it. Rules evaluated outside a Guard read the broker every time.
+type snapshot struct {
+	positions map[string]positionRead
+	account   *accountRead
+	all       *positionsRead
+	orders    map[time.Time]ordersRead
+}
+
+type positionRead struct {
+	pos *alpaca.Position
+	err error
+}
+
+type accountRead struct {
+	acct *alpaca.Account
+	err  error
+}
+
+type positionsRead struct {
+	positions []alpaca.Position
+	err       error
+}
+
+type ordersRead struct {
+	orders []alpaca.Order
+	err    error
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		positions: make(map[string]positionRead),
+		orders:    make(map[time.Time]ordersRead),
+	}
+}
+
+// reads returns the snapshot of in's evaluation.
+func (in Intent) reads() *snapshot {
+	if in.snap == nil {
+		return newSnapshot()
+	}
+	return in.snap
+}
+
+// openPosition returns the intent's open position in its symbol, or nil
+// when there is none or no broker to ask.
+func openPosition(in Intent) (*alpaca.Position, error) {
+	return positionOf(in, in.Symbol)
+}
+
+// positionOf returns the open position in symbol of the intent's account,
+// or nil when there is none or no broker to ask.
+func positionOf(in Intent, symbol string) (*alpaca.Position, error) {
+	if in.Broker == nil {
+		return nil, nil
+	}
+	s := in.reads()
+	r, ok := s.positions[symbol]
+	if !ok {
+		r.pos, r.err = in.Broker.GetPosition(symbol)
+		if errors.Is(r.err, adapter.ErrNoPosition) {
+			r.pos, r.err = nil, nil
+		}
+		s.positions[symbol] = r
+	}
+	return r.pos, r.err
+}
+
+// accountOf returns the intent's broker account.
+func accountOf(in Intent) (*alpaca.Account, error) {
+	s := in.reads()
+	if s.account == nil {
+		acct, err := in.Broker.GetAccount()
+		s.account = &accountRead{acct: acct, err: err}
+	}
+	return s.account.acct, s.account.err
+}
+
+// positionsOf returns every open position of the intent's account.
+func positionsOf(in Intent) ([]alpaca.Position, error) {
+	s := in.reads()
+	if s.all == nil {
+		positions, err := in.Broker.ListPositions()
+		s.all = &positionsRead{positions: positions, err: err}
+	}
+	return s.all.positions, s.all.err
+}
+
+// ordersOf returns the orders of the intent's account submitted since.
+func ordersOf(in Intent, since time.Time) ([]alpaca.Order, error) {
+	s := in.reads()
+	r, ok := s.orders[since]
+	if !ok {
+		r.orders, r.err = in.Broker.ListOrdersSince(since)
+		s.orders[since] = r
+	}
+	return r.orders, r.err
+}
@@ -8,12 +8,56 @@ var (
 	OrderTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "order_total",
-			Help: "Total number of orders processed",
+			Help: "Total number of orders processed, by mode: live or dry_run",
+		},
+		[]string{"bot", "side", "mode"},
+	)
+
+	StaleAlertTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stale_alert_total",
+			Help: "Total number of alerts rejected for a missing, stale or future timestamp",
+		},
+		[]string{"bot", "reason"},
+	)
+
+	SourceRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "source_rejected_total",
+			Help: "Total number of requests rejected by a source IP allow-list",
+		},
+		[]string{"scope", "bot"},
+	)
+
+	RateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_total",
+			Help: "Total number of requests rejected by a rate limit",
+		},
+		[]string{"scope", "bot"},
+	)
+
+	RiskRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "risk_rejected_total",
+			Help: "Total number of alerts rejected by a risk rule",
+		},
+		[]string{"bot", "rule"},
+	)
+
+	QueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "alert_queue_depth",
+			Help: "Number of accepted alerts waiting for a worker in async mode",
 		},
-		[]string{"bot", "side"},
 	)
 )
 
 func init() {
 	prometheus.MustRegister(OrderTotal)
+	prometheus.MustRegister(StaleAlertTotal)
+	prometheus.MustRegister(SourceRejectedTotal)
+	prometheus.MustRegister(RateLimitedTotal)
+	prometheus.MustRegister(RiskRejectedTotal)
+	prometheus.MustRegister(QueueDepth)
 }
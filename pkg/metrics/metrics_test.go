@@ -11,7 +11,7 @@ func TestOrderTotalRegistered(t *testing.T) {
 	reg := prometheus.NewRegistry()
 	reg.MustRegister(OrderTotal)
 
-	OrderTotal.WithLabelValues("test", "buy").Add(0)
+	OrderTotal.WithLabelValues("test", "buy", "live").Add(0)
 	count, err := testutil.GatherAndCount(reg, "order_total")
 	if err != nil {
 		t.Fatalf("gather error: %v", err)
@@ -24,9 +24,18 @@ func TestOrderTotalRegistered(t *testing.T) {
 func TestOrderTotalIncrement(t *testing.T) {
 	lblBot := "metrics_bot"
 	lblSide := "buy"
-	before := testutil.ToFloat64(OrderTotal.WithLabelValues(lblBot, lblSide))
-	OrderTotal.WithLabelValues(lblBot, lblSide).Inc()
-	after := testutil.ToFloat64(OrderTotal.WithLabelValues(lblBot, lblSide))
+	before := testutil.ToFloat64(OrderTotal.WithLabelValues(lblBot, lblSide, "live"))
+	OrderTotal.WithLabelValues(lblBot, lblSide, "live").Inc()
+	after := testutil.ToFloat64(OrderTotal.WithLabelValues(lblBot, lblSide, "live"))
+	if diff := after - before; diff != 1 {
+		t.Fatalf("expected increment by 1, got %v", diff)
+	}
+}
+
+func TestStaleAlertTotalIncrement(t *testing.T) {
+	before := testutil.ToFloat64(StaleAlertTotal.WithLabelValues("metrics_bot", "stale"))
+	StaleAlertTotal.WithLabelValues("metrics_bot", "stale").Inc()
+	after := testutil.ToFloat64(StaleAlertTotal.WithLabelValues("metrics_bot", "stale"))
 	if diff := after - before; diff != 1 {
 		t.Fatalf("expected increment by 1, got %v", diff)
 	}